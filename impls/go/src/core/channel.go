@@ -0,0 +1,217 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/jamesroutley/mal/impls/go/src/types"
+)
+
+// makeChannel creates a channel. An optional argument sets the number of
+// values it buffers; by default, it doesn't buffer any, so each put! waits
+// for a take!.
+// > (chan 10)
+// #<channel>
+func makeChannel(args ...types.MalType) (types.MalType, error) {
+	if len(args) == 0 {
+		return types.NewChannel(0), nil
+	}
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	size, ok := args[0].(*types.MalInt)
+	if !ok || size.Value < 0 {
+		return nil, fmt.Errorf("chan takes a buffer size which isn't negative")
+	}
+	return types.NewChannel(size.Value), nil
+}
+
+// closeChannel closes a channel. Values which have already been put on the
+// channel can still be taken.
+func closeChannel(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	c, ok := args[0].(*types.MalChannel)
+	if !ok {
+		return nil, fmt.Errorf("close! takes a channel")
+	}
+	c.Close()
+	return &types.MalNil{}, nil
+}
+
+// put puts a value on a channel, waiting until there's room for it. It
+// returns true, or false if the channel has been closed. nil can't be put on
+// a channel, because take! uses it to mean the channel has been closed.
+// > (let* (c (chan 1)) (put! c 1))
+// true
+func (r *Runtime) put(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(2, args); err != nil {
+		return nil, err
+	}
+	c, ok := args[0].(*types.MalChannel)
+	if !ok {
+		return nil, fmt.Errorf("put! takes a channel")
+	}
+	if err := checkPutValue(args[1]); err != nil {
+		return nil, err
+	}
+
+	// Check the channel's been closed first, otherwise the select could
+	// put the value on a closed channel which has room for it
+	select {
+	case <-c.Closed:
+		return &types.MalBoolean{Value: false}, nil
+	default:
+	}
+
+	select {
+	case c.Values <- args[1]:
+		return &types.MalBoolean{Value: true}, nil
+	case <-c.Closed:
+		return &types.MalBoolean{Value: false}, nil
+	case <-r.ctx.Done():
+		return nil, fmt.Errorf("put!: interpreter was shut down: %w", r.ctx.Err())
+	}
+}
+
+// take takes a value from a channel, waiting until there is one. It returns
+// nil once the channel has been closed, and is empty.
+// > (let* (c (chan 1)) (do (put! c 1) (take! c)))
+// 1
+func (r *Runtime) take(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	c, ok := args[0].(*types.MalChannel)
+	if !ok {
+		return nil, fmt.Errorf("take! takes a channel")
+	}
+
+	select {
+	case value := <-c.Values:
+		return value, nil
+	case <-c.Closed:
+		return drain(c), nil
+	case <-r.ctx.Done():
+		return nil, fmt.Errorf("take!: interpreter was shut down: %w", r.ctx.Err())
+	}
+}
+
+// alts waits until one of a list of channel operations can be made, makes it,
+// and returns a list of its result and the channel it used. Each operation is
+// either a channel, to take a value from, or a list of a channel and a value,
+// to put the value on the channel. If more than one operation is ready, one
+// is chosen at random. An optional timeout in milliseconds stops alts! waiting
+// for ever; if it runs out, alts! returns nil.
+// > (let* (c (chan 1)) (alts! (list c (list c 2))))
+// (true #<channel>)
+func (r *Runtime) alts(args ...types.MalType) (types.MalType, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, fmt.Errorf("alts! takes 1 or 2 args, got %d", len(args))
+	}
+	ops, ok := args[0].(*types.MalList)
+	if !ok {
+		return nil, fmt.Errorf("alts! takes a list of channel operations")
+	}
+
+	// Each operation has two cases: one for the operation, and one for the
+	// channel being closed. The shutdown, and timeout cases go at the end.
+	channels := make([]*types.MalChannel, ops.Len())
+	cases := make([]reflect.SelectCase, 0, 2*ops.Len()+2)
+	for i, op := range ops.Items() {
+		switch op := op.(type) {
+		case *types.MalChannel:
+			channels[i] = op
+			cases = append(cases, reflect.SelectCase{
+				Dir:  reflect.SelectRecv,
+				Chan: reflect.ValueOf(op.Values),
+			})
+
+		case *types.MalList:
+			if op.Len() != 2 {
+				return nil, fmt.Errorf("alts!: puts must be a list of a channel and a value")
+			}
+			c, ok := op.Items()[0].(*types.MalChannel)
+			if !ok {
+				return nil, fmt.Errorf("alts!: puts must be a list of a channel and a value")
+			}
+			if err := checkPutValue(op.Items()[1]); err != nil {
+				return nil, err
+			}
+			channels[i] = c
+			cases = append(cases, reflect.SelectCase{
+				Dir:  reflect.SelectSend,
+				Chan: reflect.ValueOf(c.Values),
+				Send: reflect.ValueOf(&op.Items()[1]).Elem(),
+			})
+
+		default:
+			return nil, fmt.Errorf("alts!: operations must be channels or lists, got %s", op)
+		}
+		cases = append(cases, reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(channels[i].Closed),
+		})
+	}
+
+	shutdown := len(cases)
+	cases = append(cases, reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(r.ctx.Done()),
+	})
+	if len(args) == 2 {
+		ms, ok := args[1].(*types.MalInt)
+		if !ok {
+			return nil, fmt.Errorf("alts! takes a timeout in milliseconds as its second argument")
+		}
+		timer := time.NewTimer(time.Duration(ms.Value) * time.Millisecond)
+		defer timer.Stop()
+		cases = append(cases, reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(timer.C),
+		})
+	}
+
+	chosen, received, _ := reflect.Select(cases)
+	switch {
+	case chosen == shutdown:
+		return nil, fmt.Errorf("alts!: interpreter was shut down: %w", r.ctx.Err())
+	case chosen > shutdown:
+		// Timed out
+		return &types.MalNil{}, nil
+	}
+
+	c := channels[chosen/2]
+	isPut := cases[chosen/2*2].Dir == reflect.SelectSend
+	closed := chosen%2 == 1
+	var result types.MalType
+	switch {
+	case isPut:
+		result = &types.MalBoolean{Value: !closed}
+	case closed:
+		result = drain(c)
+	default:
+		result = received.Interface().(types.MalType)
+	}
+	return types.NewList(result, c), nil
+}
+
+// drain takes a value left in a closed channel's buffer, or returns nil if
+// there are none left
+func drain(c *types.MalChannel) types.MalType {
+	select {
+	case value := <-c.Values:
+		return value
+	default:
+		return &types.MalNil{}
+	}
+}
+
+func checkPutValue(value types.MalType) error {
+	if _, ok := value.(*types.MalNil); ok {
+		return fmt.Errorf("can't put nil on a channel")
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package core
 import (
 	"fmt"
 
+	"github.com/jamesroutley/mal/impls/go/src/reader"
 	"github.com/jamesroutley/mal/impls/go/src/types"
 )
 
@@ -13,33 +14,224 @@ type NamespaceItem struct {
 
 var Namespace []*NamespaceItem
 
-func register(symbol string, f func(...types.MalType) (types.MalType, error)) {
-	item := &NamespaceItem{
+// register adds a builtin function to the namespace. It raises an error if
+// it's passed an error value. arglists is the function's parameter lists,
+// e.g. "(a) (a b)", and doc describes what it does. Both are stored in its
+// metadata, where doc finds them.
+func register(symbol string, arglists string, doc string, f func(...types.MalType) (types.MalType, error)) {
+	Namespace = append(Namespace, newNamespaceItem(symbol, arglists, doc, rejectErrors(symbol, f)))
+}
+
+// registerAcceptingErrors adds a builtin function which can be passed error
+// values to the namespace
+func registerAcceptingErrors(symbol string, arglists string, doc string, f func(...types.MalType) (types.MalType, error)) {
+	Namespace = append(Namespace, newNamespaceItem(symbol, arglists, doc, f))
+}
+
+// registerMacro adds a builtin macro to the namespace. Like any macro, it's
+// passed its arguments unevaluated, and returns the code to evaluate in
+// their place.
+func registerMacro(symbol string, arglists string, doc string, f func(...types.MalType) (types.MalType, error)) {
+	item := newNamespaceItem(symbol, arglists, doc, f)
+	item.Func.IsMacro = true
+	Namespace = append(Namespace, item)
+}
+
+// rejectErrors wraps a builtin function, so it raises an error if it's passed
+// an error value
+func rejectErrors(symbol string, f func(...types.MalType) (types.MalType, error)) func(...types.MalType) (types.MalType, error) {
+	return func(args ...types.MalType) (types.MalType, error) {
+		for _, arg := range args {
+			if e, ok := arg.(*types.MalError); ok {
+				return nil, fmt.Errorf("%s was passed an error value: %s", symbol, e.Message)
+			}
+		}
+		return f(args...)
+	}
+}
+
+func newNamespaceItem(symbol string, arglists string, doc string, f func(...types.MalType) (types.MalType, error)) *NamespaceItem {
+	return &NamespaceItem{
 		Symbol: &types.MalSymbol{Value: symbol},
-		Func:   &types.MalFunction{Func: f},
+		Func:   NewBuiltin(symbol, arglists, doc, f),
+	}
+}
+
+// NewBuiltin creates a function implemented in Go, documented by arglists
+// and doc (see register)
+func NewBuiltin(symbol string, arglists string, doc string, f func(...types.MalType) (types.MalType, error)) *types.MalFunction {
+	lists, err := reader.ReadAll(arglists, "")
+	if err != nil {
+		panic(fmt.Sprintf("bad arglists for %s: %s", symbol, err))
+	}
+	meta, err := types.NewHashMap(
+		&types.MalKeyword{Value: "name"}, &types.MalString{Value: symbol},
+		&types.MalKeyword{Value: "arglists"}, types.NewList(lists...),
+		&types.MalKeyword{Value: "doc"}, &types.MalString{Value: doc},
+	)
+	if err != nil {
+		panic(err)
+	}
+	return &types.MalFunction{
+		Name: symbol,
+		Func: f,
+		Meta: meta,
 	}
-	Namespace = append(Namespace, item)
 }
 
 func init() {
-	register("+", add)
-	register("-", subtract)
-	register("*", multiply)
-	register("/", divide)
-	register("prn", prn)
-	register("list", list)
-	register("list?", isList)
-	register("empty?", isEmpty)
-	register("count", count)
-	register("=", equals)
-	register("<", lt)
-	register("<=", lte)
-	register(">", gt)
-	register(">=", gte)
-	register("read-string", readString)
-	register("slurp", slurp)
-	register("cons", cons)
-	register("concat", concat)
+	register("+", "(& xs)",
+		"Adds numbers together, or joins strings together", add)
+	register("-", "(a b)",
+		"Subtracts b from a", subtract)
+	register("*", "(a b)",
+		"Multiplies a and b", multiply)
+	register("/", "(a b)",
+		"Divides a by b, rounding towards zero", divide)
+	registerAcceptingErrors("prn", "(x)",
+		"Prints x, followed by a newline", prn)
+	registerAcceptingErrors("list", "(& items)",
+		"Returns a list of its arguments", list)
+	register("list?", "(x)",
+		"Returns true if x is a list", isList)
+	registerAcceptingErrors("vector", "(& items)",
+		"Returns a vector of its arguments", vector)
+	register("vec", "(list)",
+		"Returns a vector of the items of list", vec)
+	register("vector?", "(x)",
+		"Returns true if x is a vector", isVector)
+	register("sequential?", "(x)",
+		"Returns true if x is a list, vector or lazy seq", isSequentialValue)
+	registerAcceptingErrors("conj", "(coll & items)",
+		"Returns a new collection, with items added to the front of the list coll, the end of the vector coll, or the set coll. Items added to a hash map are (key value) pairs", conj)
+	register("empty?", "(list)",
+		"Returns true if list has no items. A string is a list of characters", isEmpty)
+	register("count", "(list)",
+		"Returns the number of items in list. (count nil) is 0", count)
+	registerAcceptingErrors("=", "(a b)",
+		"Returns true if a and b are equal. Lists, vectors and hash maps are equal if their items are", equals)
+	register("<", "(a b)",
+		"Returns true if a is less than b", lt)
+	register("<=", "(a b)",
+		"Returns true if a is less than or equal to b", lte)
+	register(">", "(a b)",
+		"Returns true if a is greater than b", gt)
+	register(">=", "(a b)",
+		"Returns true if a is greater than or equal to b", gte)
+	register("read-string", "(s) (s filename)",
+		"Reads the first form in the string s. filename is recorded in the positions of the forms read", readString)
+	register("slurp", "(filename)",
+		"Returns the contents of a file as a string", slurp)
+	registerAcceptingErrors("cons", "(x list)",
+		"Returns a new list, with x added to the front of list", cons)
+	register("concat", "(& lists)",
+		"Returns a list of the items of each of lists, in order", concat)
+	register("first", "(list)",
+		"Returns the first item of list, or nil if it's empty", first)
+	register("rest", "(list)",
+		"Returns a list of every item of list after the first", rest)
+	register("nth", "(list index)",
+		"Returns the item of list at index, counting from 0. The items of a string are its characters", nth)
+	register("map", "(f list)",
+		"Returns a list of the results of calling f on each item of list. The calls are made in parallel, in no particular order. If a call fails, no more are started, the calls already running are cancelled, and its error is returned straight away. If list is a lazy seq, returns a lazy seq, whose items are computed in order when they're needed", mapList)
+	register("make-lazy-seq", "(f)",
+		"Returns a lazy seq, whose items are the list returned by calling f the first time they're needed. The lazy-seq macro calls it", makeLazySeq)
+	register("seq?", "(x)",
+		"Returns true if x is a list or a lazy seq", isSeq)
+	register("iterate", "(f x)",
+		"Returns the infinite lazy seq x, (f x), (f (f x)) and so on", iterate)
+	register("repeat", "(x) (n x)",
+		"Returns a lazy seq of n xs, or infinitely many", repeat)
+	register("cycle", "(list)",
+		"Returns an infinite lazy seq, which repeats the items of list", cycle)
+	register("range", "() (end) (start end) (start end step)",
+		"Returns a lazy seq of the numbers from start (0 by default) up to but not including end, in steps of step. With no args, it counts up from 0 forever", rangeSeq)
+	register("take", "(n list)",
+		"Returns a lazy seq of the first n items of list", take)
+	register("drop", "(n list)",
+		"Returns a lazy seq of the items of list after the first n", drop)
+	register("take-while", "(pred list)",
+		"Returns a lazy seq of the items at the start of list for which pred returns true", takeWhile)
+	register("filter", "(pred list)",
+		"Returns a lazy seq of the items of list for which pred returns true", filter)
+	register("doall", "(list)",
+		"Computes every item of a lazy seq, and returns it", doall)
+	register("line-seq", "(filename)",
+		"Returns a lazy seq of the lines of a file, which are read when they're needed", lineSeq)
+	register("pmap", "(n f list)",
+		"Like map, but makes at most n calls at once", pmap)
+	register("promise", "()",
+		"Returns a promise, whose value is set later with deliver", promise)
+	registerAcceptingErrors("deliver", "(p value)",
+		"Sets the value of the promise p, and returns p. If p has already been delivered, returns nil instead", deliver)
+	register("realized?", "(p)",
+		"Returns true if the promise or future p has been delivered, or if the first item of the lazy seq p has been computed", isRealized)
+	register("chan", "() (size)",
+		"Returns a channel, which buffers up to size values (0 by default)", makeChannel)
+	register("close!", "(c)",
+		"Closes the channel c. Values already put on it can still be taken", closeChannel)
+	register("stacktrace", "(e)",
+		"Returns the call stack of an exception caught by catch*, as a list of strings, innermost call first", stacktrace)
+	register("source-position", "(form)",
+		"Returns (filename line column) for a list or symbol read from source code, or nil if it isn't known", sourcePosition)
+	register("set", "(list)",
+		"Returns a set of the items of list", set)
+	register("set?", "(x)",
+		"Returns true if x is a set", isSet)
+	register("disj", "(s & items)",
+		"Returns a copy of the set s, without items", disj)
+	register("set/union", "(& sets)",
+		"Returns a set of the items which are in any of sets", setUnion)
+	register("set/intersection", "(s & sets)",
+		"Returns a set of the items of s which are in every one of sets", setIntersection)
+	register("set/difference", "(s & sets)",
+		"Returns a set of the items of s which aren't in any of sets", setDifference)
+	register("set/subset?", "(a b)",
+		"Returns true if every item of the set a is in the set b", isSubset)
+	register("char", "(code-point)",
+		"Returns the character with the given code point", char)
+	register("char?", "(x)",
+		"Returns true if x is a character", isChar)
+	register("int", "(c)",
+		"Returns the code point of the character c", toInt)
+	register("string/codepoints", "(s)",
+		"Returns a list of the code points of the characters of the string s", stringCodepoints)
+	register("hash-map", "(& keys-and-values)",
+		"Returns a hash map of alternating keys and values", hashMap)
+	register("map?", "(x)",
+		"Returns true if x is a hash map", isHashMap)
+	register("assoc", "(m & keys-and-values)",
+		"Returns a copy of the hash map m, with alternating keys and values added to it. If m is a vector, the keys are indices", assoc)
+	register("dissoc", "(m & keys)",
+		"Returns a copy of the hash map m, without keys", dissoc)
+	register("get", "(m key)",
+		"Returns the value of key in the hash map m, or nil if it isn't there. If m is a vector, key is an index. If m is a set, returns key if it's in m", get)
+	register("contains?", "(m key)",
+		"Returns true if the hash map m contains key. If m is a vector, key is an index. If m is a set, key is an item", contains)
+	register("keys", "(m)",
+		"Returns a list of the keys of the hash map m", keys)
+	register("vals", "(m)",
+		"Returns a list of the values of the hash map m", vals)
+	register("keyword", "(name)",
+		"Returns the keyword with the given name", keyword)
+	register("keyword?", "(x)",
+		"Returns true if x is a keyword", isKeyword)
+	register("meta", "(x)",
+		"Returns the metadata of x, or nil if it has none", meta)
+	register("with-meta", "(x meta)",
+		"Returns a copy of x, with metadata meta. ^meta x is short for (with-meta x meta)", withMeta)
+	register("vary-meta", "(x f & args)",
+		"Returns a copy of x, whose metadata is (f (meta x) args...)", varyMeta)
+	register("error", "(message)",
+		"Returns an error value. Most builtins raise an exception if they're passed one", makeError)
+	registerAcceptingErrors("error?", "(x)",
+		"Returns true if x is an error value", isError)
+	registerAcceptingErrors("error-message", "(e)",
+		"Returns the message of the error value e", errorMessage)
+	register("gensym", "() (prefix)",
+		"Returns a new symbol, which can't clash with any other. Inside a quasiquote, x# is replaced with a new symbol, which is the same for every x# in the quasiquote", gensym)
+	registerMacro("ok->", "(x & forms)",
+		"Threads x through forms, like ->, but stops at the first form which returns an error value", okThread)
 }
 
 func ValidateNArgs(n int, args []types.MalType) error {
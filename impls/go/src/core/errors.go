@@ -0,0 +1,113 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/jamesroutley/mal/impls/go/src/types"
+)
+
+// makeError creates an error value with a message
+// > (error "not found")
+// #<error: not found>
+func makeError(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	message, ok := args[0].(*types.MalString)
+	if !ok {
+		return nil, fmt.Errorf("error takes a string message")
+	}
+	return &types.MalError{
+		Message: message.Value,
+	}, nil
+}
+
+// isError reports whether its argument is an error value
+func isError(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	_, ok := args[0].(*types.MalError)
+	return &types.MalBoolean{
+		Value: ok,
+	}, nil
+}
+
+// errorMessage returns the message of an error value
+// > (error-message (error "not found"))
+// "not found"
+func errorMessage(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	e, ok := args[0].(*types.MalError)
+	if !ok {
+		return nil, fmt.Errorf("error-message takes an error value")
+	}
+	return &types.MalString{
+		Value: e.Message,
+	}, nil
+}
+
+// okThread implements the ok-> macro, which threads a value through a series
+// of forms, stopping at the first one which returns an error value. Each form
+// is called with the value as its first argument. A form which is just a
+// symbol is called with the value as its only argument.
+// > (ok-> 1 (+ 2) (- 1))
+// 2
+// > (ok-> (error "oops") (+ 2) (- 1))
+// #<error: oops>
+//
+// (ok-> x (f a) g) expands to
+// (let* (v x) (if (error? v) v (let* (v (f v a)) (if (error? v) v (g v)))))
+// where v is a symbol which can't clash with any in the forms.
+func okThread(args ...types.MalType) (types.MalType, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("ok-> takes at least one arg")
+	}
+	value := Gensym("ok->value")
+
+	// Build the expansion from the inside out, starting with the last form
+	var expansion types.MalType = value
+	for i := len(args) - 1; i >= 1; i-- {
+		call, err := threadFirst(value, args[i])
+		if err != nil {
+			return nil, err
+		}
+		expansion = checkOK(value, call, expansion)
+	}
+	return checkOK(value, args[0], expansion), nil
+}
+
+// checkOK builds (let* (value form) (if (error? value) value then))
+func checkOK(value *types.MalSymbol, form types.MalType, then types.MalType) types.MalType {
+	// The innermost form doesn't need checking
+	if then == value {
+		return form
+	}
+	return types.NewList(
+		&types.MalSymbol{Value: "let*"},
+		types.NewList(value, form),
+		types.NewList(
+			&types.MalSymbol{Value: "if"},
+			types.NewList(&types.MalSymbol{Value: "error?"}, value),
+			value,
+			then,
+		),
+	)
+}
+
+// threadFirst inserts value as the first argument of form
+func threadFirst(value types.MalType, form types.MalType) (types.MalType, error) {
+	switch form := form.(type) {
+	case *types.MalSymbol:
+		return types.NewList(form, value), nil
+	case *types.MalList:
+		if form.Len() == 0 {
+			return nil, fmt.Errorf("ok->: can't thread through ()")
+		}
+		items := append([]types.MalType{form.Items()[0], value}, form.Items()[1:]...)
+		return types.NewListAt(form.Pos, items...), nil
+	}
+	return nil, fmt.Errorf("ok->: can't thread through %s", form)
+}
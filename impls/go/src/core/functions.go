@@ -3,8 +3,8 @@ package core
 import (
 	"fmt"
 	"io/ioutil"
-	"log"
 	"reflect"
+	"unicode/utf8"
 
 	"github.com/jamesroutley/mal/impls/go/src/printer"
 	"github.com/jamesroutley/mal/impls/go/src/reader"
@@ -17,9 +17,7 @@ func prn(args ...types.MalType) (types.MalType, error) {
 }
 
 func list(args ...types.MalType) (types.MalType, error) {
-	return &types.MalList{
-		Items: args,
-	}, nil
+	return types.NewList(args...), nil
 }
 
 func isList(args ...types.MalType) (types.MalType, error) {
@@ -30,13 +28,21 @@ func isList(args ...types.MalType) (types.MalType, error) {
 }
 
 func isEmpty(args ...types.MalType) (types.MalType, error) {
-	list, ok := args[0].(*types.MalList)
-	if !ok {
-		return nil, fmt.Errorf("first argument to empty? isn't a list")
+	switch args[0].(type) {
+	case *types.MalSet, *types.MalString:
+	default:
+		if !isSequential(args[0]) {
+			return nil, fmt.Errorf("first argument to empty? isn't a list")
+		}
+	}
+	// Lazy seqs are only realised as far as their first item
+	_, _, ok, err := types.Next(args[0])
+	if err != nil {
+		return nil, err
 	}
 
 	return &types.MalBoolean{
-		Value: len(list.Items) == 0,
+		Value: !ok,
 	}, nil
 }
 
@@ -46,13 +52,29 @@ func count(args ...types.MalType) (types.MalType, error) {
 			Value: 0,
 		}, nil
 	}
-	list, ok := args[0].(*types.MalList)
-	if !ok {
+	switch seq := args[0].(type) {
+	case *types.MalList:
+		return &types.MalInt{Value: seq.Len()}, nil
+	case *types.MalVector:
+		return &types.MalInt{Value: seq.Len()}, nil
+	case *types.MalHashMap:
+		return &types.MalInt{Value: seq.Len()}, nil
+	case *types.MalSet:
+		return &types.MalInt{Value: seq.Len()}, nil
+	// Strings are counted in characters, not bytes
+	case *types.MalString:
+		return &types.MalInt{Value: utf8.RuneCountInString(seq.Value)}, nil
+	case *types.MalLazySeq:
+	default:
 		return nil, fmt.Errorf("first argument to count isn't a list")
 	}
+	items, err := types.Items(args[0])
+	if err != nil {
+		return nil, err
+	}
 
 	return &types.MalInt{
-		Value: len(list.Items),
+		Value: len(items),
 	}, nil
 }
 
@@ -61,54 +83,150 @@ func equals(args ...types.MalType) (types.MalType, error) {
 		return nil, fmt.Errorf("equals requires 2 args - got %d", len(args))
 	}
 
+	equal, err := equalsInternal(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
 	return &types.MalBoolean{
-		Value: equalsInternal(args[0], args[1]),
+		Value: equal,
 	}, nil
 }
 
-func equalsInternal(aa types.MalType, bb types.MalType) bool {
+// equalsInternal reports whether two values are equal. Lists, vectors and
+// lazy seqs are equal to each other if they have equal items. It only fails
+// if realising a lazy seq fails.
+func equalsInternal(aa types.MalType, bb types.MalType) (bool, error) {
+	_, aList := aa.(*types.MalList)
+	_, bList := bb.(*types.MalList)
+	if !(aList && bList) && isSequential(aa) && isSequential(bb) {
+		return seqEquals(aa, bb)
+	}
+
 	if reflect.TypeOf(aa) != reflect.TypeOf(bb) {
-		return false
+		return false, nil
 	}
 
 	switch a := aa.(type) {
 	case *types.MalList:
 		b := bb.(*types.MalList)
-		if len(a.Items) != len(b.Items) {
-			return false
+		if a.Len() != b.Len() {
+			return false, nil
 		}
 
-		for i := range a.Items {
-			if !equalsInternal(a.Items[i], b.Items[i]) {
-				return false
+		// Walk the lists, rather than calling Items, which would copy any
+		// cells built by cons
+		aItems, bItems := a.Iter(), b.Iter()
+		for {
+			aItem, ok := aItems.Next()
+			if !ok {
+				break
+			}
+			bItem, _ := bItems.Next()
+			if equal, err := equalsInternal(aItem, bItem); !equal || err != nil {
+				return false, err
 			}
 		}
 
 	case *types.MalInt:
 		b := bb.(*types.MalInt)
-		return a.Value == b.Value
+		return a.Value == b.Value, nil
 
 	case *types.MalBoolean:
 		b := bb.(*types.MalBoolean)
-		return a.Value == b.Value
+		return a.Value == b.Value, nil
 
 	case *types.MalSymbol:
 		b := bb.(*types.MalSymbol)
-		return a.Value == b.Value
+		return a.Value == b.Value, nil
 
 	case *types.MalString:
 		b := bb.(*types.MalString)
-		return a.Value == b.Value
+		return a.Value == b.Value, nil
+
+	case *types.MalChar:
+		b := bb.(*types.MalChar)
+		return a.Value == b.Value, nil
 
 	case *types.MalNil:
 		// Nils don't have values, so they're always equal
-		return true
+		return true, nil
 
+	case *types.MalKeyword:
+		b := bb.(*types.MalKeyword)
+		return a.Value == b.Value, nil
+
+	case *types.MalHashMap:
+		b := bb.(*types.MalHashMap)
+		if a.Len() != b.Len() {
+			return false, nil
+		}
+		for i, key := range a.Keys() {
+			value, ok := b.Get(key)
+			if !ok {
+				return false, nil
+			}
+			if equal, err := equalsInternal(a.Values()[i], value); !equal || err != nil {
+				return false, err
+			}
+		}
+
+	// Sets contain items which are = to each other, so two sets are equal if
+	// every item of one is in the other
+	case *types.MalSet:
+		b := bb.(*types.MalSet)
+		if a.Len() != b.Len() {
+			return false, nil
+		}
+		for _, item := range a.Items() {
+			if !b.Contains(item) {
+				return false, nil
+			}
+		}
+
+	case *types.MalError:
+		b := bb.(*types.MalError)
+		return a.Message == b.Message, nil
+
+	// Exceptions caught by catch* are equal if they have the same message,
+	// like error values
+	case *types.MalException:
+		b := bb.(*types.MalException)
+		return a == b || a.Err.Error() == b.Err.Error(), nil
+
+	// Functions, channels and promises are only equal to themselves
+	case *types.MalFunction, *types.MalChannel, *types.MalPromise:
+		return aa == bb, nil
+
+	// Other values, like the analyser's internal forms, aren't equal to
+	// anything
 	default:
-		log.Fatalf("equals unimplemented for type %T", a)
+		return false, nil
 	}
 
-	return true
+	return true, nil
+}
+
+// seqEquals compares two sequences item by item, so a lazy seq is only
+// realised until the first difference. That means an infinite sequence can
+// be compared with a finite one.
+func seqEquals(a types.MalType, b types.MalType) (bool, error) {
+	for {
+		aFirst, aRest, aOK, err := types.Next(a)
+		if err != nil {
+			return false, err
+		}
+		bFirst, bRest, bOK, err := types.Next(b)
+		if err != nil {
+			return false, err
+		}
+		if !aOK || !bOK {
+			return aOK == bOK, nil
+		}
+		if equal, err := equalsInternal(aFirst, bFirst); !equal || err != nil {
+			return false, err
+		}
+		a, b = aRest, bRest
+	}
 }
 
 func lt(args ...types.MalType) (types.MalType, error) {
@@ -167,13 +285,48 @@ func gte(args ...types.MalType) (types.MalType, error) {
 	}, nil
 }
 
+// readString reads a form from a string. An optional second argument gives
+// the name of the file the string came from, which is recorded in the
+// positions of the forms read.
 func readString(args ...types.MalType) (types.MalType, error) {
 	arg, ok := args[0].(*types.MalString)
 	if !ok {
 		return nil, fmt.Errorf("read-string takes a string")
 	}
+	if len(args) < 2 {
+		return reader.ReadStr(arg.Value)
+	}
 
-	return reader.ReadStr(arg.Value)
+	filename, ok := args[1].(*types.MalString)
+	if !ok {
+		return nil, fmt.Errorf("read-string takes a string filename as its second argument")
+	}
+	return reader.ReadStrWithFilename(arg.Value, filename.Value)
+}
+
+// sourcePosition returns where a list or symbol was read from, as a list of
+// filename (nil if it wasn't read from a file), line and column. It returns
+// nil if the position isn't known.
+// > (source-position (quote (a b)))
+// (nil 1 25)
+func sourcePosition(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	pos := types.PositionOf(args[0])
+	if pos == nil {
+		return &types.MalNil{}, nil
+	}
+
+	var filename types.MalType = &types.MalNil{}
+	if pos.File != "" {
+		filename = &types.MalString{Value: pos.File}
+	}
+	return types.NewList(
+		filename,
+		&types.MalInt{Value: pos.Line},
+		&types.MalInt{Value: pos.Column},
+	), nil
 }
 
 func slurp(args ...types.MalType) (types.MalType, error) {
@@ -192,37 +345,198 @@ func slurp(args ...types.MalType) (types.MalType, error) {
 	}, nil
 }
 
-// cons prepends arg1 onto the list at arg2
+// cons prepends arg1 onto the list at arg2. The new list shares the old
+// one's items, rather than copying them. Consing onto a vector returns a
+// list. Consing onto a lazy seq returns a lazy seq, without realising any of
+// it.
 // >(cons 1 (quote (2 3)))
 // (1 2 3)
 func cons(args ...types.MalType) (types.MalType, error) {
-	list, ok := args[1].(*types.MalList)
-	if !ok {
-		return nil, fmt.Errorf("cons takes a list as its second argument")
+	if seq, ok := args[1].(*types.MalLazySeq); ok {
+		return types.Cons(args[0], seq), nil
 	}
-	items := append([]types.MalType{args[0]}, list.Items...)
-	return &types.MalList{
-		Items: items,
-	}, nil
+	switch list := args[1].(type) {
+	case *types.MalList:
+		return list.Cons(args[0]), nil
+	case *types.MalVector:
+		// The new list shares the slice of the vector's items, which the
+		// vector caches, so consing onto the same vector again is O(1)
+		return types.NewList(list.Items()...).Cons(args[0]), nil
+	}
+	return nil, fmt.Errorf("cons takes a list as its second argument")
 }
 
-// concat takes a number of lists and concatenates them together
+// concat takes a number of lists (or vectors) and concatenates them together
+// into a list. If any of them is a lazy seq, the result is a lazy seq too.
+// If the last one is a list, it's shared, rather than copied, so only the
+// items before it are.
 // > (concat (list 1 2) (list 3 4))
 // (1 2 3 4)
 func concat(args ...types.MalType) (types.MalType, error) {
 	var allItems []types.MalType
 
-	for _, arg := range args {
-		list, ok := arg.(*types.MalList)
+	for i, arg := range args {
+		if _, ok := arg.(*types.MalLazySeq); ok {
+			for _, arg := range args[i:] {
+				if !isSequential(arg) {
+					return nil, fmt.Errorf("concat takes lists as arguments")
+				}
+			}
+			seqs := append([]types.MalType{types.NewList(allItems...)}, args[i:]...)
+			return concatSeq(seqs), nil
+		}
+		if last, ok := arg.(*types.MalList); ok && i == len(args)-1 {
+			for j := len(allItems) - 1; j >= 0; j-- {
+				last = last.Cons(allItems[j])
+			}
+			return last, nil
+		}
+		items, ok := sequentialItems(arg)
 		if !ok {
 			return nil, fmt.Errorf("concat takes lists as arguments")
 		}
-		allItems = append(allItems, list.Items...)
+		allItems = append(allItems, items...)
 	}
 
-	return &types.MalList{
-		Items: allItems,
-	}, nil
+	return types.NewList(allItems...), nil
+}
+
+// first returns the first item of a list, or nil if the list is empty or nil
+// > (first (list 1 2 3))
+// 1
+func first(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	if !isSequence(args[0]) {
+		return nil, fmt.Errorf("first takes a list")
+	}
+	first, _, ok, err := types.Next(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return &types.MalNil{}, nil
+	}
+	return first, nil
+}
+
+// rest returns a list of every item of a list after the first. The rest of
+// an empty list, or nil, is an empty list. The rest of a lazy seq is lazy.
+// The rest of a vector, set or string is a list.
+// > (rest (list 1 2 3))
+// (2 3)
+func rest(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	switch list := args[0].(type) {
+	case *types.MalNil:
+		return &types.MalList{}, nil
+	case *types.MalList:
+		return list.Rest(), nil
+	case *types.MalVector, *types.MalSet, *types.MalString:
+		items, err := types.Items(list)
+		if err != nil {
+			return nil, err
+		}
+		if len(items) == 0 {
+			return &types.MalList{}, nil
+		}
+		return types.NewList(items[1:]...), nil
+	case *types.MalLazySeq:
+		_, rest, ok, err := types.Next(list)
+		if err != nil {
+			return nil, err
+		}
+		if _, isNil := rest.(*types.MalNil); !ok || isNil {
+			return &types.MalList{}, nil
+		}
+		return rest, nil
+	}
+	return nil, fmt.Errorf("rest takes a list")
+}
+
+// nth returns the item at an index in a list, vector or string, counting from 0
+// > (nth (list 1 2 3) 1)
+// 2
+func nth(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(2, args); err != nil {
+		return nil, err
+	}
+	index, ok := args[1].(*types.MalInt)
+	if !ok {
+		return nil, fmt.Errorf("nth takes an index")
+	}
+	if seq, ok := args[0].(*types.MalLazySeq); ok {
+		return nthSeq(seq, index.Value)
+	}
+	if vector, ok := args[0].(*types.MalVector); ok {
+		item, ok := vector.Nth(index.Value)
+		if !ok {
+			return nil, fmt.Errorf("nth: index %d out of range for a vector of %d items", index.Value, vector.Len())
+		}
+		return item, nil
+	}
+	if s, ok := args[0].(*types.MalString); ok {
+		chars := []rune(s.Value)
+		if index.Value < 0 || index.Value >= len(chars) {
+			return nil, fmt.Errorf("nth: index %d out of range for a string of %d characters", index.Value, len(chars))
+		}
+		return &types.MalChar{Value: chars[index.Value]}, nil
+	}
+	list, ok := args[0].(*types.MalList)
+	if !ok {
+		return nil, fmt.Errorf("nth takes a list")
+	}
+	if index.Value < 0 || index.Value >= list.Len() {
+		return nil, fmt.Errorf("nth: index %d out of range for a list of %d items", index.Value, list.Len())
+	}
+	return list.Nth(index.Value), nil
+}
+
+// nthSeq returns the item at an index in a lazy seq, realising it up to
+// that item
+func nthSeq(seq types.MalType, index int) (types.MalType, error) {
+	if index < 0 {
+		return nil, fmt.Errorf("nth: index %d out of range", index)
+	}
+	for i := 0; ; i++ {
+		first, rest, ok, err := types.Next(seq)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("nth: index %d out of range for a sequence of %d items", index, i)
+		}
+		if i == index {
+			return first, nil
+		}
+		seq = rest
+	}
+}
+
+// stacktrace returns the mal call stack of an exception caught by catch*, as a
+// list of strings, innermost call first
+// > (try* (count 1) (catch* e (stacktrace e)))
+// ("count: (count 1) at 1:7")
+func stacktrace(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	exception, ok := args[0].(*types.MalException)
+	if !ok {
+		return nil, fmt.Errorf("stacktrace takes an exception")
+	}
+
+	frames := types.StackTrace(exception.Err)
+	items := make([]types.MalType, len(frames))
+	for i, frame := range frames {
+		items[i] = &types.MalString{
+			Value: frame.String(),
+		}
+	}
+	return types.NewList(items...), nil
 }
 
 // func list(args ...types.MalType) (types.MalType, error) {
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jamesroutley/mal/impls/go/src/types"
+)
+
+// futureCall calls a function with no arguments on a new goroutine, and
+// returns a promise which is delivered with its result. The future macro
+// wraps its body in a function and passes it to future-call.
+// > (deref (future-call (fn* () (+ 1 2))))
+// 3
+func (r *Runtime) futureCall(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	function, ok := args[0].(*types.MalFunction)
+	if !ok {
+		return nil, fmt.Errorf("future-call takes a function")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.ctx.Err(); err != nil {
+		return nil, fmt.Errorf("future-call: interpreter was shut down: %w", err)
+	}
+
+	future := types.NewPromise()
+	r.futures.Add(1)
+	go func() {
+		defer r.futures.Done()
+		future.Deliver(function.Func())
+	}()
+	return future, nil
+}
+
+// deref waits for a promise or future to be delivered, and returns its
+// value. If the future failed, deref returns its error. With three
+// arguments, deref gives up after a timeout in milliseconds, and returns its
+// third argument instead.
+// > (deref (promise) 10 "timed out")
+// "timed out"
+func (r *Runtime) deref(args ...types.MalType) (types.MalType, error) {
+	if len(args) != 1 && len(args) != 3 {
+		return nil, fmt.Errorf("deref takes 1 or 3 args, got %d", len(args))
+	}
+	p, ok := args[0].(*types.MalPromise)
+	if !ok {
+		return nil, fmt.Errorf("deref takes a promise or future")
+	}
+
+	// A nil channel is never ready, so with no timeout we wait forever
+	var timeout <-chan time.Time
+	if len(args) == 3 {
+		ms, ok := args[1].(*types.MalInt)
+		if !ok {
+			return nil, fmt.Errorf("deref takes a timeout in milliseconds as its second argument")
+		}
+		timer := time.NewTimer(time.Duration(ms.Value) * time.Millisecond)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-p.Done():
+		value, err := p.Result()
+		if err != nil {
+			return nil, copyError(err)
+		}
+		return value, nil
+	case <-timeout:
+		return args[2], nil
+	case <-r.ctx.Done():
+		return nil, fmt.Errorf("deref: interpreter was shut down: %w", r.ctx.Err())
+	}
+}
+
+// copyError copies an error's stack trace, so the error can be returned by
+// more than one deref. Otherwise, each caller would add its own frames to the
+// same trace.
+func copyError(err error) error {
+	evalErr, ok := err.(*types.EvalError)
+	if !ok {
+		return err
+	}
+	return &types.EvalError{
+		Err:   evalErr.Err,
+		Stack: append([]*types.StackFrame(nil), evalErr.Stack...),
+	}
+}
+
+// promise creates a promise, which can be delivered with deliver
+func promise(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(0, args); err != nil {
+		return nil, err
+	}
+	return types.NewPromise(), nil
+}
+
+// deliver sets the value of a promise, and returns the promise. Promises can
+// only be delivered once: if it's already been delivered, deliver returns
+// nil, and the value doesn't change.
+// > (let* (p (promise)) (do (deliver p 1) (deref p)))
+// 1
+func deliver(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(2, args); err != nil {
+		return nil, err
+	}
+	p, ok := args[0].(*types.MalPromise)
+	if !ok {
+		return nil, fmt.Errorf("deliver takes a promise")
+	}
+	if !p.Deliver(args[1], nil) {
+		return &types.MalNil{}, nil
+	}
+	return p, nil
+}
+
+// isRealized reports whether a promise or future has been delivered, or
+// whether the first item of a lazy seq has been computed
+func isRealized(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	if seq, ok := args[0].(*types.MalLazySeq); ok {
+		return &types.MalBoolean{Value: seq.Realized()}, nil
+	}
+	p, ok := args[0].(*types.MalPromise)
+	if !ok {
+		return nil, fmt.Errorf("realized? takes a promise, future or lazy seq")
+	}
+	select {
+	case <-p.Done():
+		return &types.MalBoolean{Value: true}, nil
+	default:
+		return &types.MalBoolean{Value: false}, nil
+	}
+}
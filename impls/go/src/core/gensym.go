@@ -0,0 +1,36 @@
+package core
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/jamesroutley/mal/impls/go/src/types"
+)
+
+// gensym returns a new symbol, whose name starts with an optional prefix
+// > (gensym "x")
+// x__1__
+func gensym(args ...types.MalType) (types.MalType, error) {
+	if len(args) == 0 {
+		return Gensym("G"), nil
+	}
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	prefix, ok := args[0].(*types.MalString)
+	if !ok {
+		return nil, fmt.Errorf("gensym takes a string prefix")
+	}
+	return Gensym(prefix.Value), nil
+}
+
+var gensymCounter int64
+
+// Gensym returns a new symbol, which won't clash with any symbol in code a
+// macro is passed
+func Gensym(prefix string) *types.MalSymbol {
+	n := atomic.AddInt64(&gensymCounter, 1)
+	return &types.MalSymbol{
+		Value: fmt.Sprintf("%s__%d__", prefix, n),
+	}
+}
@@ -0,0 +1,210 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/jamesroutley/mal/impls/go/src/types"
+)
+
+// hashMap creates a hash map from alternating keys and values
+// > (hash-map :a 1 "b" 2)
+// {:a 1 "b" 2}
+func hashMap(args ...types.MalType) (types.MalType, error) {
+	return types.NewHashMap(args...)
+}
+
+func isHashMap(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	_, ok := args[0].(*types.MalHashMap)
+	return &types.MalBoolean{
+		Value: ok,
+	}, nil
+}
+
+// assoc returns a copy of a hash map, with alternating keys and values added.
+// Given a vector, it replaces the items at indices instead.
+// > (assoc {:a 1} :b 2)
+// {:a 1 :b 2}
+func assoc(args ...types.MalType) (types.MalType, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("assoc takes a hash map")
+	}
+	if v, ok := args[0].(*types.MalVector); ok {
+		return assocVector(v, args[1:])
+	}
+	m, ok := args[0].(*types.MalHashMap)
+	if !ok {
+		return nil, fmt.Errorf("assoc takes a hash map")
+	}
+	return m.Assoc(args[1:]...)
+}
+
+// dissoc returns a copy of a hash map, without some keys
+// > (dissoc {:a 1 :b 2} :a)
+// {:b 2}
+func dissoc(args ...types.MalType) (types.MalType, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("dissoc takes a hash map")
+	}
+	m, ok := args[0].(*types.MalHashMap)
+	if !ok {
+		return nil, fmt.Errorf("dissoc takes a hash map")
+	}
+	return m.Dissoc(args[1:]...)
+}
+
+// get returns the value of a key in a hash map, or nil if it isn't there.
+// Getting a key from nil returns nil. The keys of a vector are its indices,
+// and getting an item of a set returns the item.
+// > (get {:a 1} :a)
+// 1
+func get(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(2, args); err != nil {
+		return nil, err
+	}
+	switch m := args[0].(type) {
+	case *types.MalNil:
+		return &types.MalNil{}, nil
+	case *types.MalHashMap:
+		if value, ok := m.Get(args[1]); ok {
+			return value, nil
+		}
+		return &types.MalNil{}, nil
+	case *types.MalVector:
+		if value, ok := vectorGet(m, args[1]); ok {
+			return value, nil
+		}
+		return &types.MalNil{}, nil
+	case *types.MalSet:
+		if m.Contains(args[1]) {
+			return args[1], nil
+		}
+		return &types.MalNil{}, nil
+	}
+	return nil, fmt.Errorf("get takes a hash map")
+}
+
+func contains(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(2, args); err != nil {
+		return nil, err
+	}
+	switch m := args[0].(type) {
+	case *types.MalHashMap:
+		_, ok := m.Get(args[1])
+		return &types.MalBoolean{
+			Value: ok,
+		}, nil
+	case *types.MalVector:
+		_, ok := vectorGet(m, args[1])
+		return &types.MalBoolean{
+			Value: ok,
+		}, nil
+	case *types.MalSet:
+		return &types.MalBoolean{
+			Value: m.Contains(args[1]),
+		}, nil
+	}
+	return nil, fmt.Errorf("contains? takes a hash map")
+}
+
+// vectorGet returns the item of a vector at index, and whether there is one
+func vectorGet(v *types.MalVector, index types.MalType) (types.MalType, bool) {
+	i, ok := index.(*types.MalInt)
+	if !ok {
+		return nil, false
+	}
+	return v.Nth(i.Value)
+}
+
+// keys returns a list of a hash map's keys
+func keys(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	m, ok := args[0].(*types.MalHashMap)
+	if !ok {
+		return nil, fmt.Errorf("keys takes a hash map")
+	}
+	return types.NewList(m.Keys()...), nil
+}
+
+// vals returns a list of a hash map's values
+func vals(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	m, ok := args[0].(*types.MalHashMap)
+	if !ok {
+		return nil, fmt.Errorf("vals takes a hash map")
+	}
+	return types.NewList(m.Values()...), nil
+}
+
+// keyword creates a keyword from a string
+// > (keyword "a")
+// :a
+func keyword(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	switch name := args[0].(type) {
+	case *types.MalKeyword:
+		return name, nil
+	case *types.MalString:
+		return &types.MalKeyword{
+			Value: name.Value,
+		}, nil
+	}
+	return nil, fmt.Errorf("keyword takes a string")
+}
+
+func isKeyword(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	_, ok := args[0].(*types.MalKeyword)
+	return &types.MalBoolean{
+		Value: ok,
+	}, nil
+}
+
+// meta returns a value's metadata, or nil if it has none
+// > (meta (with-meta (list 1) {:a 1}))
+// {:a 1}
+func meta(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	return types.Meta(args[0]), nil
+}
+
+// withMeta returns a copy of a value, with new metadata. ^meta form is
+// shorthand for (with-meta form meta).
+func withMeta(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(2, args); err != nil {
+		return nil, err
+	}
+	return types.WithMeta(args[0], args[1])
+}
+
+// varyMeta returns a copy of a value, whose metadata is the result of calling
+// a function on its current metadata, and any other arguments
+// > (meta (vary-meta ^{:a 1} (list) assoc :b 2))
+// {:a 1 :b 2}
+func varyMeta(args ...types.MalType) (types.MalType, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("vary-meta takes a value and a function")
+	}
+	f, ok := args[1].(*types.MalFunction)
+	if !ok {
+		return nil, fmt.Errorf("vary-meta takes a function as its second argument")
+	}
+	fArgs := append([]types.MalType{types.Meta(args[0])}, args[2:]...)
+	newMeta, err := f.Func(fArgs...)
+	if err != nil {
+		return nil, err
+	}
+	return types.WithMeta(args[0], newMeta)
+}
@@ -0,0 +1,397 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/jamesroutley/mal/impls/go/src/types"
+)
+
+// These functions create and transform lazy seqs (see types.MalLazySeq). They
+// take any sequence (a list, lazy seq or nil), and only realise as much of
+// it as they need, so they work on infinite sequences.
+
+// makeLazySeq returns a lazy seq, whose items are the sequence returned by
+// calling a function with no arguments. The lazy-seq macro wraps its body in
+// a function and passes it to make-lazy-seq.
+// > (make-lazy-seq (fn* () (list 1 2)))
+// (1 2)
+func makeLazySeq(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	function, ok := args[0].(*types.MalFunction)
+	if !ok {
+		return nil, fmt.Errorf("make-lazy-seq takes a function")
+	}
+	return types.NewLazySeq(func() (types.MalType, error) {
+		return function.Func()
+	}), nil
+}
+
+func isSeq(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	return &types.MalBoolean{
+		Value: types.IsSeq(args[0]),
+	}, nil
+}
+
+// iterate returns the infinite sequence x, (f x), (f (f x)), ...
+// > (take 4 (iterate (fn* (a) (* a 2)) 1))
+// (1 2 4 8)
+func iterate(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(2, args); err != nil {
+		return nil, err
+	}
+	function, ok := args[0].(*types.MalFunction)
+	if !ok {
+		return nil, fmt.Errorf("iterate takes a function")
+	}
+	return iterateSeq(function, args[1]), nil
+}
+
+func iterateSeq(function *types.MalFunction, x types.MalType) *types.MalLazySeq {
+	return types.Cons(x, types.NewLazySeq(func() (types.MalType, error) {
+		next, err := function.Func(x)
+		if err != nil {
+			return nil, err
+		}
+		return iterateSeq(function, next), nil
+	}))
+}
+
+// repeat returns an infinite sequence of x, or, given a count, a sequence of
+// n xs
+// > (repeat 3 "a")
+// ("a" "a" "a")
+func repeat(args ...types.MalType) (types.MalType, error) {
+	switch len(args) {
+	case 1:
+		return repeatSeq(args[0]), nil
+	case 2:
+		n, ok := args[0].(*types.MalInt)
+		if !ok {
+			return nil, fmt.Errorf("repeat takes a count as its first argument")
+		}
+		return takeSeq(n.Value, repeatSeq(args[1])), nil
+	}
+	return nil, fmt.Errorf("repeat takes 1 or 2 args, got %d", len(args))
+}
+
+func repeatSeq(x types.MalType) *types.MalLazySeq {
+	return types.NewLazySeq(func() (types.MalType, error) {
+		return types.Cons(x, repeatSeq(x)), nil
+	})
+}
+
+// cycle returns an infinite sequence, which repeats the items of a sequence
+// over and over
+// > (take 5 (cycle (list 1 2)))
+// (1 2 1 2 1)
+func cycle(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	if !isSequence(args[0]) {
+		return nil, fmt.Errorf("cycle takes a list")
+	}
+	return cycleSeq(args[0], args[0]), nil
+}
+
+// cycleSeq returns the items of seq, followed by the items of all, over and
+// over
+func cycleSeq(seq types.MalType, all types.MalType) *types.MalLazySeq {
+	return types.NewLazySeq(func() (types.MalType, error) {
+		first, rest, ok, err := types.Next(seq)
+		if err != nil || (!ok && seq == all) {
+			// cycling an empty sequence gives an empty sequence
+			return nil, err
+		}
+		if !ok {
+			return cycleSeq(all, all), nil
+		}
+		return types.Cons(first, cycleSeq(rest, all)), nil
+	})
+}
+
+// rangeSeq returns a sequence of numbers. With no arguments, it counts up from
+// 0 forever. Otherwise, it counts from start (0 by default) up to, but not
+// including, end, in steps of step (1 by default).
+// > (range 1 10 3)
+// (1 4 7)
+func rangeSeq(args ...types.MalType) (types.MalType, error) {
+	if len(args) > 3 {
+		return nil, fmt.Errorf("range takes up to 3 args, got %d", len(args))
+	}
+	numbers, err := ArgsToMalInt(args)
+	if err != nil {
+		return nil, err
+	}
+	start, step := 0, 1
+	switch len(numbers) {
+	case 0:
+		return countFrom(0, 1, func(int) bool { return true }), nil
+	case 1:
+		return countFrom(start, step, endBefore(numbers[0].Value, step)), nil
+	case 3:
+		step = numbers[2].Value
+		if step == 0 {
+			return nil, fmt.Errorf("range: step can't be 0")
+		}
+	}
+	start = numbers[0].Value
+	return countFrom(start, step, endBefore(numbers[1].Value, step)), nil
+}
+
+// endBefore returns a function which reports whether a number comes before
+// end, when counting in steps of step
+func endBefore(end int, step int) func(int) bool {
+	if step < 0 {
+		return func(n int) bool { return n > end }
+	}
+	return func(n int) bool { return n < end }
+}
+
+func countFrom(n int, step int, more func(int) bool) *types.MalLazySeq {
+	return types.NewLazySeq(func() (types.MalType, error) {
+		if !more(n) {
+			return nil, nil
+		}
+		return types.Cons(&types.MalInt{Value: n}, countFrom(n+step, step, more)), nil
+	})
+}
+
+// take returns a sequence of the first n items of a sequence, or all of them
+// if there are fewer than n
+// > (take 3 (range))
+// (0 1 2)
+func take(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(2, args); err != nil {
+		return nil, err
+	}
+	n, ok := args[0].(*types.MalInt)
+	if !ok {
+		return nil, fmt.Errorf("take takes a count as its first argument")
+	}
+	if !isSequence(args[1]) {
+		return nil, fmt.Errorf("take takes a list")
+	}
+	return takeSeq(n.Value, args[1]), nil
+}
+
+func takeSeq(n int, seq types.MalType) *types.MalLazySeq {
+	return types.NewLazySeq(func() (types.MalType, error) {
+		if n <= 0 {
+			return nil, nil
+		}
+		first, rest, ok, err := types.Next(seq)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return types.Cons(first, takeSeq(n-1, rest)), nil
+	})
+}
+
+// drop returns a sequence of every item of a sequence after the first n
+// > (take 3 (drop 2 (range)))
+// (2 3 4)
+func drop(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(2, args); err != nil {
+		return nil, err
+	}
+	n, ok := args[0].(*types.MalInt)
+	if !ok {
+		return nil, fmt.Errorf("drop takes a count as its first argument")
+	}
+	seq := args[1]
+	if !isSequence(seq) {
+		return nil, fmt.Errorf("drop takes a list")
+	}
+	return types.NewLazySeq(func() (types.MalType, error) {
+		for i := 0; i < n.Value; i++ {
+			_, rest, ok, err := types.Next(seq)
+			if err != nil || !ok {
+				return nil, err
+			}
+			seq = rest
+		}
+		return seq, nil
+	}), nil
+}
+
+// takeWhile returns a sequence of the items at the start of a sequence which
+// pred returns true for
+// > (take-while (fn* (a) (< a 3)) (range))
+// (0 1 2)
+func takeWhile(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(2, args); err != nil {
+		return nil, err
+	}
+	pred, ok := args[0].(*types.MalFunction)
+	if !ok {
+		return nil, fmt.Errorf("take-while takes a function")
+	}
+	if !isSequence(args[1]) {
+		return nil, fmt.Errorf("take-while takes a list")
+	}
+	return takeWhileSeq(pred, args[1]), nil
+}
+
+func takeWhileSeq(pred *types.MalFunction, seq types.MalType) *types.MalLazySeq {
+	return types.NewLazySeq(func() (types.MalType, error) {
+		first, rest, ok, err := types.Next(seq)
+		if err != nil || !ok {
+			return nil, err
+		}
+		keep, err := pred.Func(first)
+		if err != nil || !isTruthy(keep) {
+			return nil, err
+		}
+		return types.Cons(first, takeWhileSeq(pred, rest)), nil
+	})
+}
+
+// filter returns a sequence of the items of a sequence which pred returns
+// true for
+// > (take 3 (filter (fn* (a) (= a (* 2 (/ a 2)))) (range)))
+// (0 2 4)
+func filter(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(2, args); err != nil {
+		return nil, err
+	}
+	pred, ok := args[0].(*types.MalFunction)
+	if !ok {
+		return nil, fmt.Errorf("filter takes a function")
+	}
+	if !isSequence(args[1]) {
+		return nil, fmt.Errorf("filter takes a list")
+	}
+	return filterSeq(pred, args[1]), nil
+}
+
+func filterSeq(pred *types.MalFunction, seq types.MalType) *types.MalLazySeq {
+	return types.NewLazySeq(func() (types.MalType, error) {
+		// Items which are filtered out are skipped here, rather than by
+		// returning another lazy seq, so long runs of them don't build up a
+		// chain of lazy seqs
+		for {
+			first, rest, ok, err := types.Next(seq)
+			if err != nil || !ok {
+				return nil, err
+			}
+			keep, err := pred.Func(first)
+			if err != nil {
+				return nil, err
+			}
+			if isTruthy(keep) {
+				return types.Cons(first, filterSeq(pred, rest)), nil
+			}
+			seq = rest
+		}
+	})
+}
+
+// mapSeq lazily calls a function on each item of a sequence. map uses it for
+// lazy seqs, so, unlike lists, they're mapped in order, one item at a time.
+func mapSeq(function *types.MalFunction, seq types.MalType) *types.MalLazySeq {
+	return types.NewLazySeq(func() (types.MalType, error) {
+		first, rest, ok, err := types.Next(seq)
+		if err != nil || !ok {
+			return nil, err
+		}
+		result, err := function.Func(first)
+		if err != nil {
+			return nil, err
+		}
+		return types.Cons(result, mapSeq(function, rest)), nil
+	})
+}
+
+// concatSeq lazily concatenates sequences. concat uses it if any of them is
+// lazy.
+func concatSeq(seqs []types.MalType) *types.MalLazySeq {
+	return types.NewLazySeq(func() (types.MalType, error) {
+		for len(seqs) > 0 {
+			first, rest, ok, err := types.Next(seqs[0])
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				remaining := append([]types.MalType{rest}, seqs[1:]...)
+				return types.Cons(first, concatSeq(remaining)), nil
+			}
+			seqs = seqs[1:]
+		}
+		return nil, nil
+	})
+}
+
+// doall realises every item of a sequence, and returns it
+// > (doall (map prn (take 2 (range))))
+// 0
+// 1
+// (nil nil)
+func doall(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	if !isSequence(args[0]) {
+		return nil, fmt.Errorf("doall takes a list")
+	}
+	if _, err := types.Items(args[0]); err != nil {
+		return nil, err
+	}
+	return args[0], nil
+}
+
+// lineSeq returns a lazy seq of the lines of a file, which are read as
+// they're needed, so a file which is too big to slurp can be processed a line
+// at a time. The file is closed once the last line has been read.
+// > (take 1 (line-seq "notes.txt"))
+// ("the first line of notes.txt")
+func lineSeq(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	filename, ok := args[0].(*types.MalString)
+	if !ok {
+		return nil, fmt.Errorf("line-seq takes a filename")
+	}
+	file, err := os.Open(filename.Value)
+	if err != nil {
+		return nil, err
+	}
+	return scanLines(file, bufio.NewScanner(file)), nil
+}
+
+func scanLines(file *os.File, scanner *bufio.Scanner) *types.MalLazySeq {
+	return types.NewLazySeq(func() (types.MalType, error) {
+		if scanner.Scan() {
+			return types.Cons(&types.MalString{Value: scanner.Text()}, scanLines(file, scanner)), nil
+		}
+		file.Close()
+		return nil, scanner.Err()
+	})
+}
+
+// isSequence reports whether t is a list, vector, set, lazy seq or nil
+func isSequence(t types.MalType) bool {
+	switch t.(type) {
+	case *types.MalNil, *types.MalVector, *types.MalSet, *types.MalString:
+		return true
+	}
+	return types.IsSeq(t)
+}
+
+func isTruthy(t types.MalType) bool {
+	switch t := t.(type) {
+	case *types.MalNil:
+		return false
+	case *types.MalBoolean:
+		return t.Value
+	}
+	return true
+}
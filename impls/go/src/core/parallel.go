@@ -0,0 +1,141 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/jamesroutley/mal/impls/go/src/types"
+)
+
+// mapList applies a function to each item of a list, in parallel. The
+// results are in the same order as the items, but the order the function is
+// called in isn't specified. Mapping over a lazy seq returns a lazy seq,
+// whose items are computed in order, when they're needed.
+// > (map (fn* (a) (* a a)) (list 1 2 3))
+// (1 4 9)
+func mapList(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(2, args); err != nil {
+		return nil, err
+	}
+	if seq, ok := args[1].(*types.MalLazySeq); ok {
+		function, ok := args[0].(*types.MalFunction)
+		if !ok {
+			return nil, fmt.Errorf("map takes a function")
+		}
+		return mapSeq(function, seq), nil
+	}
+	return parallelMap("map", args[0], args[1], runtime.GOMAXPROCS(0))
+}
+
+// pmap is like map, but takes the maximum number of function calls to run at
+// once as its first argument
+// > (pmap 2 (fn* (a) (* a a)) (list 1 2 3))
+// (1 4 9)
+func pmap(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(3, args); err != nil {
+		return nil, err
+	}
+	workers, ok := args[0].(*types.MalInt)
+	if !ok || workers.Value < 1 {
+		return nil, fmt.Errorf("pmap takes a positive number of workers as its first argument")
+	}
+	return parallelMap("pmap", args[1], args[2], workers.Value)
+}
+
+// parallelMap calls f on each item of list, using up to workers goroutines.
+// If a call returns an error, no more calls are started, the calls in
+// progress are cancelled, and the error is returned straight away, without
+// waiting for them to stop. A cancelled mal function stops the next time it
+// makes a call or recurs. Builtins can't be cancelled, so a call blocked in
+// one (e.g. take!) carries on in the background until it returns.
+func parallelMap(name string, f types.MalType, list types.MalType, workers int) (types.MalType, error) {
+	function, ok := f.(*types.MalFunction)
+	if !ok {
+		return nil, fmt.Errorf("%s takes a function", name)
+	}
+	var items []types.MalType
+	switch l := list.(type) {
+	case *types.MalNil:
+	case *types.MalList:
+		items = l.Items()
+	case *types.MalVector:
+		items = l.Items()
+	case *types.MalSet:
+		items = l.Items()
+	case *types.MalString:
+		items = types.Chars(l.Value)
+	default:
+		return nil, fmt.Errorf("%s takes a list", name)
+	}
+
+	call := function.Func
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if function.CallContext != nil {
+		call = func(args ...types.MalType) (types.MalType, error) {
+			return function.CallContext(ctx, args...)
+		}
+	}
+
+	results := make([]types.MalType, len(items))
+	indices := make(chan int)
+	// failed is closed when a call returns an error, after firstErr is set
+	failed := make(chan struct{})
+	finished := make(chan struct{})
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+
+	if workers > len(items) {
+		workers = len(items)
+	}
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				result, err := call(items[i])
+				if err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+						close(failed)
+					})
+					continue
+				}
+				results[i] = result
+			}
+		}()
+	}
+
+send:
+	for i := range items {
+		select {
+		case indices <- i:
+		case <-failed:
+			break send
+		}
+	}
+	close(indices)
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-failed:
+		return nil, firstErr
+	case <-finished:
+	}
+	// A call may have failed just before the last one finished
+	select {
+	case <-failed:
+		return nil, firstErr
+	default:
+	}
+	return types.NewList(results...), nil
+}
@@ -0,0 +1,77 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Runtime tracks the futures started by an interpreter, so they can be
+// stopped when the interpreter is shut down, rather than left running.
+// Builtins which wait (e.g. for a promise, or a channel) stop waiting when
+// it's shut down, too.
+type Runtime struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// mu stops futures being started while the runtime is shutting down
+	mu      sync.Mutex
+	futures sync.WaitGroup
+}
+
+func NewRuntime() *Runtime {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Runtime{
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Context is cancelled when the runtime is shut down. Interpreters should
+// stop running code once it's cancelled (see environment.NewEnvWithContext).
+func (r *Runtime) Context() context.Context {
+	return r.ctx
+}
+
+// Namespace returns the builtins which belong to the runtime. They're
+// defined alongside the ones in the package level Namespace.
+func (r *Runtime) Namespace() []*NamespaceItem {
+	return []*NamespaceItem{
+		newNamespaceItem("future-call", "(f)",
+			"Calls the function f on a new goroutine, and returns a future, which is delivered with its result. (future body) is short for (future-call (fn* () body))",
+			rejectErrors("future-call", r.futureCall)),
+		newNamespaceItem("deref", "(p) (p timeout-ms timeout-value)",
+			"Waits for the promise or future p to be delivered, and returns its value. Returns timeout-value if it isn't delivered within timeout-ms milliseconds",
+			rejectErrors("deref", r.deref)),
+		newNamespaceItem("put!", "(c value)",
+			"Puts value on the channel c, waiting until there's room. Returns false if c is closed",
+			r.put),
+		newNamespaceItem("take!", "(c)",
+			"Takes a value from the channel c, waiting until there is one. Returns nil if c is closed",
+			rejectErrors("take!", r.take)),
+		newNamespaceItem("alts!", "(ops) (ops timeout-ms)",
+			"Makes the first of a list of operations which is ready, and returns (result channel). Each operation is a channel to take from, or a list (channel value) to put on. Returns nil if none are ready within timeout-ms milliseconds",
+			rejectErrors("alts!", r.alts)),
+	}
+}
+
+// Shutdown cancels the runtime's context, which stops any futures which are
+// running, and waits up to timeout for them to finish. It reports whether
+// they all finished in time.
+func (r *Runtime) Shutdown(timeout time.Duration) bool {
+	r.mu.Lock()
+	r.cancel()
+	r.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		r.futures.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,163 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/jamesroutley/mal/impls/go/src/types"
+)
+
+// set creates a set of the items of a sequence
+// > (set (list 1 2 1))
+// #{1 2}
+func set(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	if s, ok := args[0].(*types.MalSet); ok {
+		return s, nil
+	}
+	if !isSequence(args[0]) {
+		return nil, fmt.Errorf("set takes a list")
+	}
+	items, err := types.Items(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return types.NewSet(items...)
+}
+
+func isSet(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	_, ok := args[0].(*types.MalSet)
+	return &types.MalBoolean{
+		Value: ok,
+	}, nil
+}
+
+// disj returns a copy of a set, without some items
+// > (disj #{1 2 3} 2)
+// #{1 3}
+func disj(args ...types.MalType) (types.MalType, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("disj takes a set")
+	}
+	s, ok := args[0].(*types.MalSet)
+	if !ok {
+		return nil, fmt.Errorf("disj takes a set")
+	}
+	return s.Disj(args[1:]...)
+}
+
+// toSets checks every arg is a set (or nil, which is an empty set)
+func toSets(name string, args []types.MalType) ([]*types.MalSet, error) {
+	sets := make([]*types.MalSet, len(args))
+	for i, arg := range args {
+		switch s := arg.(type) {
+		case *types.MalSet:
+			sets[i] = s
+		case *types.MalNil:
+			sets[i] = &types.MalSet{}
+		default:
+			return nil, fmt.Errorf("%s takes sets", name)
+		}
+	}
+	return sets, nil
+}
+
+// setUnion returns a set of the items which are in any of its arguments
+// > (set/union #{1 2} #{2 3})
+// #{1 2 3}
+func setUnion(args ...types.MalType) (types.MalType, error) {
+	sets, err := toSets("set/union", args)
+	if err != nil {
+		return nil, err
+	}
+	union := &types.MalSet{}
+	for _, s := range sets {
+		if union, err = union.Conj(s.Items()...); err != nil {
+			return nil, err
+		}
+	}
+	return union, nil
+}
+
+// setIntersection returns a set of the items of its first argument which are
+// in every other argument too
+// > (set/intersection #{1 2 3} #{2 3 4} #{3 2})
+// #{2 3}
+func setIntersection(args ...types.MalType) (types.MalType, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("set/intersection takes at least one set")
+	}
+	sets, err := toSets("set/intersection", args)
+	if err != nil {
+		return nil, err
+	}
+	return filterSet(sets[0], func(item types.MalType) bool {
+		for _, s := range sets[1:] {
+			if !s.Contains(item) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// setDifference returns a set of the items of its first argument which
+// aren't in any other argument
+// > (set/difference #{1 2 3} #{2} #{3})
+// #{1}
+func setDifference(args ...types.MalType) (types.MalType, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("set/difference takes at least one set")
+	}
+	sets, err := toSets("set/difference", args)
+	if err != nil {
+		return nil, err
+	}
+	return filterSet(sets[0], func(item types.MalType) bool {
+		for _, s := range sets[1:] {
+			if s.Contains(item) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// filterSet returns a set of the items of s which keep returns true for, in
+// the same order
+func filterSet(s *types.MalSet, keep func(item types.MalType) bool) (types.MalType, error) {
+	var items []types.MalType
+	for _, item := range s.Items() {
+		if keep(item) {
+			items = append(items, item)
+		}
+	}
+	return types.NewSet(items...)
+}
+
+// isSubset returns true if every item of its first argument is in its second
+// > (set/subset? #{1} #{1 2})
+// true
+func isSubset(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(2, args); err != nil {
+		return nil, err
+	}
+	sets, err := toSets("set/subset?", args)
+	if err != nil {
+		return nil, err
+	}
+	subset := sets[0].Len() <= sets[1].Len()
+	for _, item := range sets[0].Items() {
+		if !subset {
+			break
+		}
+		subset = sets[1].Contains(item)
+	}
+	return &types.MalBoolean{
+		Value: subset,
+	}, nil
+}
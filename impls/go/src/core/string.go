@@ -0,0 +1,72 @@
+package core
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/jamesroutley/mal/impls/go/src/types"
+)
+
+// char returns the character with a code point
+// > (char 233)
+// \é
+func char(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	switch arg := args[0].(type) {
+	case *types.MalChar:
+		return arg, nil
+	case *types.MalInt:
+		if arg.Value > utf8.MaxRune || !utf8.ValidRune(rune(arg.Value)) {
+			return nil, fmt.Errorf("char: %d isn't a valid code point", arg.Value)
+		}
+		return &types.MalChar{Value: rune(arg.Value)}, nil
+	}
+	return nil, fmt.Errorf("char takes a code point")
+}
+
+func isChar(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	_, ok := args[0].(*types.MalChar)
+	return &types.MalBoolean{
+		Value: ok,
+	}, nil
+}
+
+// toInt returns the code point of a character
+// > (int \a)
+// 97
+func toInt(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	switch arg := args[0].(type) {
+	case *types.MalInt:
+		return arg, nil
+	case *types.MalChar:
+		return &types.MalInt{Value: int(arg.Value)}, nil
+	}
+	return nil, fmt.Errorf("int takes a character")
+}
+
+// stringCodepoints returns a list of the code points of the characters in a
+// string
+// > (string/codepoints "hé")
+// (104 233)
+func stringCodepoints(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	s, ok := args[0].(*types.MalString)
+	if !ok {
+		return nil, fmt.Errorf("string/codepoints takes a string")
+	}
+	var codepoints []types.MalType
+	for _, r := range s.Value {
+		codepoints = append(codepoints, &types.MalInt{Value: int(r)})
+	}
+	return types.NewList(codepoints...), nil
+}
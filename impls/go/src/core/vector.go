@@ -0,0 +1,139 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/jamesroutley/mal/impls/go/src/types"
+)
+
+// vector creates a vector of its arguments
+// > (vector 1 2 3)
+// [1 2 3]
+func vector(args ...types.MalType) (types.MalType, error) {
+	return types.NewVector(args...), nil
+}
+
+// vec creates a vector of the items of a sequence
+// > (vec (list 1 2 3))
+// [1 2 3]
+func vec(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	if v, ok := args[0].(*types.MalVector); ok {
+		return v, nil
+	}
+	if !isSequence(args[0]) {
+		return nil, fmt.Errorf("vec takes a list")
+	}
+	items, err := types.Items(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return types.NewVector(items...), nil
+}
+
+func isVector(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	_, ok := args[0].(*types.MalVector)
+	return &types.MalBoolean{
+		Value: ok,
+	}, nil
+}
+
+func isSequentialValue(args ...types.MalType) (types.MalType, error) {
+	if err := ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	return &types.MalBoolean{
+		Value: isSequential(args[0]),
+	}, nil
+}
+
+// isSequential reports whether t is a list, vector or lazy seq
+func isSequential(t types.MalType) bool {
+	switch t.(type) {
+	case *types.MalList, *types.MalVector, *types.MalLazySeq:
+		return true
+	}
+	return false
+}
+
+// sequentialItems returns the items of a list or vector
+func sequentialItems(t types.MalType) ([]types.MalType, bool) {
+	switch t := t.(type) {
+	case *types.MalList:
+		return t.Items(), true
+	case *types.MalVector:
+		return t.Items(), true
+	}
+	return nil, false
+}
+
+// conj adds items to a collection, wherever it's cheapest to: the front of a
+// list, or the end of a vector. Neither copies the collection. Items conjed
+// onto a hash map are (key value) pairs. Conjing onto a set adds any items
+// which aren't already in it. Conjing onto nil creates a list.
+// > (conj (list 1 2) 3 4)
+// (4 3 1 2)
+// > (conj (vector 1 2) 3 4)
+// [1 2 3 4]
+func conj(args ...types.MalType) (types.MalType, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("conj takes a collection")
+	}
+	items := args[1:]
+	switch coll := args[0].(type) {
+	case *types.MalNil:
+		list := &types.MalList{}
+		for _, item := range items {
+			list = list.Cons(item)
+		}
+		return list, nil
+	case *types.MalList:
+		for _, item := range items {
+			coll = coll.Cons(item)
+		}
+		return coll, nil
+	case *types.MalVector:
+		for _, item := range items {
+			coll = coll.Conj(item)
+		}
+		return coll, nil
+	case *types.MalSet:
+		return coll.Conj(items...)
+	case *types.MalHashMap:
+		var pairs []types.MalType
+		for _, item := range items {
+			pair, ok := sequentialItems(item)
+			if !ok || len(pair) != 2 {
+				return nil, fmt.Errorf("conj: %s isn't a (key value) pair", item)
+			}
+			pairs = append(pairs, pair...)
+		}
+		return coll.Assoc(pairs...)
+	}
+	return nil, fmt.Errorf("conj takes a list, vector, set or hash map")
+}
+
+// assocVector returns a copy of a vector, with the items at alternating
+// indices and values replaced. An index can be the vector's length, which
+// adds an item to the end.
+func assocVector(v *types.MalVector, pairs []types.MalType) (types.MalType, error) {
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("assoc takes alternating indices and values")
+	}
+	for i := 0; i < len(pairs); i += 2 {
+		index, ok := pairs[i].(*types.MalInt)
+		if !ok {
+			return nil, fmt.Errorf("assoc: %s isn't a vector index", pairs[i])
+		}
+		var err error
+		if v, err = v.Assoc(index.Value, pairs[i+1]); err != nil {
+			return nil, fmt.Errorf("assoc: %w", err)
+		}
+	}
+	return v, nil
+}
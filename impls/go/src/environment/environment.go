@@ -1,41 +1,183 @@
 package environment
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
 
 	"github.com/jamesroutley/mal/impls/go/src/types"
 )
 
+// Env maps symbols to values. Variables live either in data, or in Slots.
+//
+// Slots hold variables bound by fn* parameters and let*, whose positions can
+// be worked out before the code runs. Names[i] is the name of the variable in
+// Slots[i]. Code that's been analysed ahead of time looks these up directly
+// by index with Lookup; everything else finds them by name, just like
+// variables in data. data holds everything else, including globals and
+// anything defined at runtime with def!.
+//
+// Envs can be shared between goroutines (e.g. by map, which calls functions
+// in parallel), so data is guarded by a read-write lock: lookups share it,
+// and Set adds variables in place. Most envs are call frames, which never
+// have any data, so lookups only take the lock once something has been
+// added to data. Slots aren't guarded: a frame's slots are only written
+// while its let* bindings are being evaluated, or by def! inside the frame,
+// which both happen on the goroutine which created it.
 type Env struct {
 	Outer *Env
-	Data  map[string]types.MalType
+	Names []string
+	Slots []types.MalType
+
+	// data is nil until the first call to Set, after which hasData is true
+	data    map[string]types.MalType
+	hasData atomic.Bool
+	mu      sync.RWMutex
+
+	// ctx is cancelled when the interpreter is shut down. It's shared by
+	// every env created from the root env, except call frames, which can be
+	// given a context of their own (see NewFrameWithContext). done is
+	// ctx.Done(), which is cheaper to check.
+	ctx  context.Context
+	done <-chan struct{}
 }
 
 func NewEnv() *Env {
 	return &Env{
 		Outer: nil,
-		Data:  map[string]types.MalType{},
 	}
 }
 
-func NewChildEnv(parent *Env, binds []*types.MalSymbol, exprs []types.MalType) *Env {
-	env := &Env{
-		Outer: parent,
-		Data:  map[string]types.MalType{},
+// NewEnvWithContext creates a root env, whose code stops running once ctx is
+// cancelled. Envs created from it share ctx, and report that it's been
+// cancelled with Err.
+func NewEnvWithContext(ctx context.Context) *Env {
+	return &Env{
+		ctx:  ctx,
+		done: ctx.Done(),
 	}
+}
+
+func NewChildEnv(parent *Env, binds []*types.MalSymbol, exprs []types.MalType) *Env {
 	if len(binds) != len(exprs) {
 		// TODO: return this?
 		log.Fatal("can't create env - num binds != num exprs")
 	}
+	names := make([]string, len(binds))
 	for i := range binds {
-		env.Set(binds[i].Value, exprs[i])
+		names[i] = binds[i].Value
+	}
+	return NewFrame(parent, names, exprs)
+}
+
+// NewFrame creates a child env with a slot for each of names. The slots are
+// initialised from values, which may be shorter than names, or nil. names is
+// shared with the caller, and mustn't be modified.
+func NewFrame(parent *Env, names []string, values []types.MalType) *Env {
+	var ctx context.Context
+	if parent != nil {
+		ctx = parent.ctx
+	}
+	return NewFrameWithContext(ctx, parent, names, values)
+}
+
+// NewFrameWithContext is like NewFrame, but code run in the frame stops once
+// ctx is cancelled, rather than the parent's context. It's used for function
+// calls, which run with their caller's context, rather than the context of
+// the env they were defined in.
+func NewFrameWithContext(ctx context.Context, parent *Env, names []string, values []types.MalType) *Env {
+	slots := make([]types.MalType, len(names))
+	copy(slots, values)
+	env := &Env{
+		Outer: parent,
+		Names: names,
+		Slots: slots,
+	}
+	if ctx != nil {
+		env.ctx, env.done = ctx, ctx.Done()
 	}
 	return env
 }
 
+// Context returns the context the env's code runs with, which is cancelled
+// when it should stop
+func (e *Env) Context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
+}
+
+// Err returns an error if the interpreter the env belongs to has been shut
+// down. Evaluators check it on each function call, so code which never
+// finishes can be stopped.
+func (e *Env) Err() error {
+	select {
+	case <-e.done:
+		return fmt.Errorf("interpreter was shut down: %w", e.ctx.Err())
+	default:
+		return nil
+	}
+}
+
 func (e *Env) Set(key string, value types.MalType) {
-	e.Data[key] = value
+	for i, name := range e.Names {
+		if name == key {
+			e.Slots[i] = value
+			return
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.data == nil {
+		e.data = map[string]types.MalType{}
+		e.hasData.Store(true)
+	}
+	e.data[key] = value
+}
+
+// SetSlot sets the value of the variable in Slots[index]
+func (e *Env) SetSlot(index int, value types.MalType) {
+	e.Slots[index] = value
+}
+
+// Lookup returns the value of the variable in Slots[index] of the env depth
+// levels above e. name is the name of the variable. If the slot doesn't hold
+// a variable called name, it's looked up by name instead - this means code
+// which has been moved into an unexpected env (e.g. by a macro which wasn't
+// defined when the code was analysed) still runs correctly.
+func (e *Env) Lookup(depth int, index int, name string) (types.MalType, error) {
+	env := e
+	for i := 0; i < depth && env != nil; i++ {
+		env = env.Outer
+	}
+	if env != nil && index < len(env.Names) && env.Names[index] == name {
+		if value := env.Slots[index]; value != nil {
+			return value, nil
+		}
+	}
+	return e.Get(name)
+}
+
+// get looks up key in this env only, without checking outer envs
+func (e *Env) get(key string) (types.MalType, bool) {
+	for i, name := range e.Names {
+		// A nil slot belongs to a let* binding which hasn't been evaluated
+		// yet
+		if name == key && e.Slots[i] != nil {
+			return e.Slots[i], true
+		}
+	}
+	if !e.hasData.Load() {
+		return nil, false
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	value, ok := e.data[key]
+	return value, ok
 }
 
 // TODO: would be nice to switch this to return `comma ok`, rather than an error
@@ -43,23 +185,58 @@ func (e *Env) Find(key string) (types.EnvType, error) {
 	if e == nil {
 		return nil, fmt.Errorf("`%s` is undefined", key)
 	}
-	if _, ok := e.Data[key]; ok {
+	if _, ok := e.get(key); ok {
 		return e, nil
 	}
 	return e.Outer.Find(key)
 }
 
 func (e *Env) Get(key string) (types.MalType, error) {
-	env, err := e.Find(key)
-	if err != nil {
-		return nil, err
+	if value, ok := e.Resolve(key); ok {
+		return value, nil
+	}
+	return nil, fmt.Errorf("`%s` is undefined", key)
+}
+
+// Resolve is like Get, but reports whether key is defined rather than
+// returning an error. It's cheaper than Get when key often isn't defined.
+func (e *Env) Resolve(key string) (types.MalType, bool) {
+	for env := e; env != nil; env = env.Outer {
+		if value, ok := env.get(key); ok {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// Symbols returns the names of the variables defined in e and the envs it's
+// nested in, sorted
+func (e *Env) Symbols() []string {
+	seen := map[string]bool{}
+	for env := e; env != nil; env = env.Outer {
+		for i, name := range env.Names {
+			if env.Slots[i] != nil {
+				seen[name] = true
+			}
+		}
+		env.mu.RLock()
+		for name := range env.data {
+			seen[name] = true
+		}
+		env.mu.RUnlock()
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
 	}
-	return env.(*Env).Data[key], nil
+	sort.Strings(names)
+	return names
 }
 
 func (e *Env) ChildEnv() *Env {
 	return &Env{
 		Outer: e,
-		Data:  map[string]types.MalType{},
+		ctx:   e.ctx,
+		done:  e.done,
 	}
 }
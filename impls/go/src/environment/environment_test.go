@@ -0,0 +1,129 @@
+package environment
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/jamesroutley/mal/impls/go/src/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// These tests are most useful when run with -race
+
+const (
+	goroutines = 16
+	iterations = 200
+)
+
+func TestConcurrentSetAndGet(t *testing.T) {
+	env := NewEnv()
+	env.Set("shared", &types.MalInt{Value: -1})
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		g := g
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				env.Set(fmt.Sprintf("var-%d-%d", g, i), &types.MalInt{Value: i})
+				env.Set("shared", &types.MalInt{Value: i})
+
+				value, err := env.Get(fmt.Sprintf("var-%d-%d", g, i))
+				if assert.NoError(t, err) {
+					assert.Equal(t, i, value.(*types.MalInt).Value)
+				}
+				_, err = env.Get("shared")
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// No writes were lost
+	for g := 0; g < goroutines; g++ {
+		for i := 0; i < iterations; i++ {
+			_, err := env.Get(fmt.Sprintf("var-%d-%d", g, i))
+			require.NoError(t, err)
+		}
+	}
+}
+
+func TestConcurrentReadsThroughChildEnvs(t *testing.T) {
+	root := NewEnv()
+	root.Set("global", &types.MalInt{Value: 0})
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		g := g
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				// Each goroutine makes its own frames, like a function call
+				// does, and reads globals through them while other
+				// goroutines define new ones
+				frame := NewFrame(root, []string{"a"}, []types.MalType{&types.MalInt{Value: i}})
+				frame.Set("local", &types.MalInt{Value: g})
+				root.Set(fmt.Sprintf("global-%d", g), &types.MalInt{Value: i})
+
+				value, err := frame.Lookup(0, 0, "a")
+				if assert.NoError(t, err) {
+					assert.Equal(t, i, value.(*types.MalInt).Value)
+				}
+				value, err = frame.Get("local")
+				if assert.NoError(t, err) {
+					assert.Equal(t, g, value.(*types.MalInt).Value)
+				}
+				_, err = frame.Get("global")
+				assert.NoError(t, err)
+				// Another goroutine may or may not have defined this yet
+				frame.Resolve(fmt.Sprintf("global-%d", (g+1)%goroutines))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSymbols(t *testing.T) {
+	outer := NewEnv()
+	outer.Set("b", &types.MalInt{Value: 1})
+	outer.Set("a", &types.MalInt{Value: 2})
+	// c's slot hasn't been set yet, so it isn't defined
+	inner := NewFrame(outer, []string{"b", "c", "d"}, []types.MalType{&types.MalInt{Value: 3}, nil, &types.MalInt{Value: 4}})
+	inner.ChildEnv().Set("e", &types.MalInt{Value: 5})
+
+	assert.Equal(t, []string{"a", "b", "d"}, inner.Symbols())
+	assert.Equal(t, []string{"a", "b"}, outer.Symbols())
+}
+
+// BenchmarkSet defines b.N variables in one env, like loading a file full of
+// def!s does. Each Set should take the same time, however many variables the
+// env already has.
+func BenchmarkSet(b *testing.B) {
+	env := NewEnv()
+	names := make([]string, b.N)
+	for i := range names {
+		names[i] = fmt.Sprintf("var-%d", i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		env.Set(names[i], &types.MalInt{Value: i})
+	}
+}
+
+func BenchmarkGetGlobal(b *testing.B) {
+	root := NewEnv()
+	for i := 0; i < 1000; i++ {
+		root.Set(fmt.Sprintf("var-%d", i), &types.MalInt{Value: i})
+	}
+	frame := NewFrame(NewFrame(root, []string{"a"}, nil), []string{"b"}, nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := frame.Get("var-500"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
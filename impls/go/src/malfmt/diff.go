@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// diffLine is a line of a diff: an unchanged line (' '), or one which was
+// removed ('-') or added ('+')
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// unifiedDiff returns the changes from a to b, in the unified format `diff
+// -u` prints, or "" if there aren't any
+func unifiedDiff(aName, bName string, a, b []byte) string {
+	lines := diffLines(splitLines(string(a)), splitLines(string(b)))
+
+	var out strings.Builder
+	// aLine and bLine count the lines of a and b before lines[i]
+	aLine, bLine := 0, 0
+	for i := 0; i < len(lines); {
+		if lines[i].op == ' ' {
+			aLine++
+			bLine++
+			i++
+			continue
+		}
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", aName, bName)
+		}
+
+		// A hunk starts a few lines before the change, and ends a few lines
+		// after the last change which is close enough to be part of it
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(lines) && j <= end+2*diffContext; j++ {
+			if lines[j].op != ' ' {
+				end = j
+			}
+		}
+		end += diffContext + 1
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		hunk := lines[start:end]
+		aStart, bStart := aLine-(i-start)+1, bLine-(i-start)+1
+		aCount, bCount := 0, 0
+		for _, line := range hunk {
+			if line.op != '+' {
+				aCount++
+			}
+			if line.op != '-' {
+				bCount++
+			}
+		}
+		// An empty range starts at the line before it
+		if aCount == 0 {
+			aStart--
+		}
+		if bCount == 0 {
+			bStart--
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+		for _, line := range hunk {
+			fmt.Fprintf(&out, "%c%s\n", line.op, line.text)
+		}
+
+		for _, line := range lines[i:end] {
+			if line.op != '+' {
+				aLine++
+			}
+			if line.op != '-' {
+				bLine++
+			}
+		}
+		i = end
+	}
+	return out.String()
+}
+
+// splitLines splits s into lines. If s doesn't end with a newline, its last
+// line is marked, like diff marks it, so it's different from the same line
+// with a newline.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.Split(s, "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += "\n\\ No newline at end of file"
+	return lines
+}
+
+// diffLines returns the shortest list of lines to remove from a and add to
+// b to turn a into b, interleaved with the lines which stay the same. It
+// finds the longest common subsequence of the lines which differ, after
+// skipping any lines at the start and end which are the same.
+func diffLines(a, b []string) []diffLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var lines []diffLine
+	for _, line := range a[:prefix] {
+		lines = append(lines, diffLine{' ', line})
+	}
+
+	aMid, bMid := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	// common[i][j] is the length of the longest common subsequence of
+	// aMid[i:] and bMid[j:]
+	common := make([][]int32, len(aMid)+1)
+	for i := range common {
+		common[i] = make([]int32, len(bMid)+1)
+	}
+	for i := len(aMid) - 1; i >= 0; i-- {
+		for j := len(bMid) - 1; j >= 0; j-- {
+			switch {
+			case aMid[i] == bMid[j]:
+				common[i][j] = common[i+1][j+1] + 1
+			case common[i+1][j] >= common[i][j+1]:
+				common[i][j] = common[i+1][j]
+			default:
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(aMid) || j < len(bMid) {
+		switch {
+		case i < len(aMid) && j < len(bMid) && aMid[i] == bMid[j]:
+			lines = append(lines, diffLine{' ', aMid[i]})
+			i++
+			j++
+		case j == len(bMid) || (i < len(aMid) && common[i+1][j] >= common[i][j+1]):
+			lines = append(lines, diffLine{'-', aMid[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', bMid[j]})
+			j++
+		}
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		lines = append(lines, diffLine{' ', line})
+	}
+	return lines
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jamesroutley/mal/impls/go/src/reader"
+)
+
+// Format reformats mal source code. It keeps the line breaks between forms
+// (but not the spaces, or commas), and reindents each line. The body of a
+// special form, or a macro like defn, is indented by 2 from its opening
+// bracket. The arguments of a call line up with the first one, or with the
+// function if the first argument's on a line of its own. The items of other
+// collections, including the bindings of let* and loop*, line up with the
+// first item:
+//
+//	(let* (a 1
+//	       b 2)
+//	  (+ a
+//	     b))
+//
+// Closing brackets go at the end of the last line of their collection. Runs
+// of blank lines are squashed into one. Comments are kept, and indented like
+// the forms around them.
+//
+// Formatting source which Format has already formatted doesn't change it.
+func Format(src []byte, filename string) ([]byte, error) {
+	forms, err := reader.ReadSyntax(bytes.NewReader(src), filename)
+	if err != nil {
+		return nil, err
+	}
+	f := &formatter{}
+	for i, form := range forms {
+		switch {
+		case i == 0:
+		case form.IsComment() && !form.NewLine:
+			// A comment at the end of a line stays there
+			f.write(" ")
+		case form.BlankLine:
+			f.newline(0, true)
+		default:
+			f.newline(0, false)
+		}
+		f.form(form, false)
+	}
+	if len(forms) > 0 {
+		f.write("\n")
+	}
+	return []byte(f.out.String()), nil
+}
+
+// blockForms are special forms and macros whose bodies are indented by 2,
+// rather than lined up with their first argument. So are any forms whose
+// names start with def.
+var blockForms = map[string]bool{
+	"fn*":         true,
+	"let*":        true,
+	"loop*":       true,
+	"do":          true,
+	"if":          true,
+	"try*":        true,
+	"catch*":      true,
+	"quasiquote":  true,
+	"fn":          true,
+	"let":         true,
+	"loop":        true,
+	"if-let":      true,
+	"when":        true,
+	"when-not":    true,
+	"cond":        true,
+	"future":      true,
+	"go":          true,
+	"lazy-seq":    true,
+	"doto":        true,
+	"future-call": true,
+}
+
+// bindingForms take a list of bindings as their first argument, which is
+// formatted like data, rather than as a call
+var bindingForms = map[string]bool{
+	"let*":   true,
+	"loop*":  true,
+	"let":    true,
+	"loop":   true,
+	"if-let": true,
+}
+
+type formatter struct {
+	out strings.Builder
+	// column is where the next character written to out goes, counting from 0
+	column int
+}
+
+func (f *formatter) write(s string) {
+	f.out.WriteString(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		f.column = utf8.RuneCountInString(s[i+1:])
+	} else {
+		f.column += utf8.RuneCountInString(s)
+	}
+}
+
+// newline starts a new line, indented to column, after a blank line if blank
+// is true
+func (f *formatter) newline(column int, blank bool) {
+	if blank {
+		f.write("\n")
+	}
+	f.write("\n" + strings.Repeat(" ", column))
+}
+
+// form writes s, starting at the current column. data is true if s is a
+// list which should be laid out like data, rather than as a call.
+func (f *formatter) form(s *reader.Syntax, data bool) {
+	switch {
+	case s.IsComment():
+		f.write(strings.TrimRightFunc(s.Text, unicode.IsSpace))
+	case s.IsReaderMacro():
+		f.write(s.Text)
+		for i, item := range s.Items {
+			if i > 0 {
+				f.write(" ")
+			}
+			f.form(item, false)
+		}
+	case s.IsCollection():
+		f.collection(s, data)
+	default:
+		f.write(s.Text)
+	}
+}
+
+// collection writes a collection, indenting any items which start on a new
+// line
+func (f *formatter) collection(s *reader.Syntax, data bool) {
+	open := f.column
+	f.write(s.Text)
+	// indent is the column items on new lines start at. By default, they
+	// line up with the first item.
+	indent := f.column
+	head := ""
+	if s.Text == "(" && !data && len(s.Items) > 0 && isName(s.Items[0]) {
+		head = s.Items[0].Text
+		switch {
+		case blockForms[head] || strings.HasPrefix(head, "def"):
+			indent = open + 2
+		case len(s.Items) > 1 && !s.Items[1].NewLine && !s.Items[1].IsComment():
+			// Line up with the first argument, which is worked out when
+			// it's written
+			indent = -1
+		}
+	}
+
+	for i, item := range s.Items {
+		switch {
+		case i == 0:
+		case s.Items[i-1].IsComment() || item.NewLine:
+			// A comment runs to the end of the line, so the next item has to
+			// go on a new one
+			f.newline(indent, item.BlankLine)
+		default:
+			f.write(" ")
+		}
+		if indent == -1 && i == 1 {
+			indent = f.column
+		}
+		f.form(item, i == 1 && bindingForms[head])
+	}
+	if len(s.Items) > 0 && s.Items[len(s.Items)-1].IsComment() {
+		f.newline(indent, false)
+	}
+	f.write(reader.ClosingBracket(s.Text))
+}
+
+// isName reports whether s is a symbol or keyword, which is called when
+// it's at the start of a list
+func isName(s *reader.Syntax) bool {
+	if s.IsComment() || s.IsCollection() || s.IsReaderMacro() {
+		return false
+	}
+	c, _ := utf8.DecodeRuneInString(s.Text)
+	switch {
+	case c == '"' || c == '\\' || unicode.IsDigit(c):
+		return false
+	case (c == '-' || c == '+') && len(s.Text) > 1:
+		// Negative numbers
+		next, _ := utf8.DecodeRuneInString(s.Text[1:])
+		return !unicode.IsDigit(next)
+	}
+	return true
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jamesroutley/mal/impls/go/src/printer"
+	"github.com/jamesroutley/mal/impls/go/src/reader"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormat(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "spaces and commas",
+			input:    "(  +  1,  2 )  \n( (fn* (a) a)   1)",
+			expected: "(+ 1 2)\n((fn* (a) a) 1)\n",
+		},
+		{
+			name:     "top level forms go on their own lines",
+			input:    "(def! a 1) (def! b 2)",
+			expected: "(def! a 1)\n(def! b 2)\n",
+		},
+		{
+			name: "special forms are indented by 2",
+			input: `(def! f
+(fn* (a)
+        (if a
+  1
+              2)))`,
+			expected: `(def! f
+  (fn* (a)
+    (if a
+      1
+      2)))
+`,
+		},
+		{
+			name: "arguments line up with the first one",
+			input: `(+ 1
+2
+  (* 3
+4))`,
+			expected: `(+ 1
+   2
+   (* 3
+      4))
+`,
+		},
+		{
+			name: "arguments line up with the function if the first is on a new line",
+			input: `(list
+        1
+   2)`,
+			expected: `(list
+ 1
+ 2)
+`,
+		},
+		{
+			name: "data lines up with the first item",
+			input: `[1
+  2]
+{:a 1
+    :b 2}
+#{1
+2}
+(1
+  2)`,
+			expected: `[1
+ 2]
+{:a 1
+ :b 2}
+#{1
+  2}
+(1
+ 2)
+`,
+		},
+		{
+			name: "bindings are data",
+			input: `(let* (a 1
+  b 2)
+(+ a b))`,
+			expected: `(let* (a 1
+       b 2)
+  (+ a b))
+`,
+		},
+		{
+			name: "comments",
+			input: `;; a comment
+(def! a   ; trailing comment
+  ;; inside a form
+  1)
+(list 1 ; last item
+)
+(;; first item
+   a b)`,
+			expected: `;; a comment
+(def! a ; trailing comment
+  ;; inside a form
+  1)
+(list 1 ; last item
+      )
+(;; first item
+ a b)
+`,
+		},
+		{
+			name:     "blank lines are squashed",
+			input:    "\n\n(a)\n\n\n\n(b\n\n\n c)\n\n",
+			expected: "(a)\n\n(b\n\n c)\n",
+		},
+		{
+			name: "closing brackets go at the end of the last line",
+			input: `(def! a
+  (list 1
+  )
+)`,
+			expected: `(def! a
+  (list 1))
+`,
+		},
+		{
+			name:     "reader macros",
+			input:    "( quote  'a ) `(a ~b ~@ c @d)\n^{:a 1}   [1]",
+			expected: "(quote 'a)\n`(a ~b ~@c @d)\n^{:a 1} [1]\n",
+		},
+		{
+			name: "atoms are kept as they are",
+			input: `(list \( \newline "a string
+  over two lines" -1 :k)`,
+			expected: `(list \( \newline "a string
+  over two lines" -1 :k)
+`,
+		},
+		{
+			name:     "empty input",
+			input:    "  \n",
+			expected: "",
+		},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := Format([]byte(tc.input), "test.mal")
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, string(actual))
+
+			again, err := Format(actual, "test.mal")
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, string(again), "formatting again changed it")
+		})
+	}
+}
+
+func TestFormatErrors(t *testing.T) {
+	for input, expected := range map[string]string{
+		"(a":       "test.mal:1:1: unclosed (",
+		"(a]":      "test.mal:1:3: ] closes ( at test.mal:1:1",
+		"a)":       "test.mal:1:2: unexpected )",
+		"'":        "test.mal:1:1: ' isn't followed by a form",
+		"(')":      "test.mal:1:3: unexpected )",
+		"' ; c\na": "test.mal:1:1: ' is followed by a comment, rather than a form",
+		`"abc`:     "test.mal:1:1: unclosed string",
+	} {
+		_, err := Format([]byte(input), "test.mal")
+		assert.EqualError(t, err, expected, input)
+	}
+}
+
+// malFiles returns the mal source files in the repo
+func malFiles(t *testing.T) []string {
+	var files []string
+	for _, pattern := range []string{
+		"../step8_macros/*.mal",
+		"../../../tests/*.mal",
+		"../../../tests/lib/*.mal",
+		"../../../../examples/*.mal",
+	} {
+		matches, err := filepath.Glob(pattern)
+		require.NoError(t, err)
+		files = append(files, matches...)
+	}
+	require.NotEmpty(t, files)
+	return files
+}
+
+// TestFormatFiles checks that formatting the repo's mal files doesn't change
+// what they mean, and that formatting them twice gives the same result
+func TestFormatFiles(t *testing.T) {
+	for _, path := range malFiles(t) {
+		src, err := os.ReadFile(path)
+		require.NoError(t, err)
+		formatted, err := Format(src, path)
+		if err != nil {
+			// Some tests are of source code which doesn't parse
+			t.Logf("skipping %s: %s", path, err)
+			continue
+		}
+
+		again, err := Format(formatted, path)
+		require.NoError(t, err, path)
+		assert.Equal(t, string(formatted), string(again), path)
+
+		before, err := reader.ReadAll(string(src), path)
+		if err != nil {
+			continue
+		}
+		after, err := reader.ReadAll(string(formatted), path)
+		require.NoError(t, err, path)
+		require.Equal(t, len(before), len(after), path)
+		for i := range before {
+			assert.Equal(t, printer.PrStr(before[i]), printer.PrStr(after[i]), path)
+		}
+	}
+}
+
+func TestPreludeIsFormatted(t *testing.T) {
+	src, err := os.ReadFile("../step8_macros/prelude.mal")
+	require.NoError(t, err)
+	formatted, err := Format(src, "prelude.mal")
+	require.NoError(t, err)
+	assert.Equal(t, string(src), string(formatted))
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	var a, b string
+	for i := 1; i <= 12; i++ {
+		line := string(rune('a'+i-1)) + "\n"
+		a += line
+		switch i {
+		case 2:
+			b += "B\n"
+		case 11:
+		default:
+			b += line
+		}
+	}
+	assert.Equal(t, `--- a
++++ b
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -8,5 +8,4 @@
+ h
+ i
+ j
+-k
+ l
+`, unifiedDiff("a", "b", []byte(a), []byte(b)))
+
+	assert.Equal(t, "", unifiedDiff("a", "b", []byte(a), []byte(a)))
+	assert.Equal(t, `--- a
++++ b
+@@ -1,1 +1,1 @@
+-x
+\ No newline at end of file
++x
+`, unifiedDiff("a", "b", []byte("x"), []byte("x\n")))
+}
@@ -0,0 +1,110 @@
+// malfmt formats mal source code (see Format).
+//
+// Usage:
+//
+//	malfmt [-w] [-d] [path ...]
+//
+// With no paths, it formats standard input. Directories are searched for
+// .mal files. By default, the formatted source is printed.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	write = flag.Bool("w", false, "write the result to the file, rather than printing it")
+	diff  = flag.Bool("d", false, "print a diff of the changes, rather than the result")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: malfmt [flags] [path ...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "malfmt: can't use -w with standard input")
+			os.Exit(2)
+		}
+		if err := formatFile("<standard input>", os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		return
+	}
+
+	failed := false
+	for _, root := range flag.Args() {
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// Files named on the command line are formatted whatever they're
+			// called, but only .mal files are formatted in directories
+			if entry.IsDir() || (path != root && !strings.HasSuffix(path, ".mal")) {
+				return nil
+			}
+			if err := formatFile(path, nil, os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				failed = true
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(2)
+	}
+}
+
+// formatFile formats the file at path, and prints it to out, writes it
+// back, or prints a diff, depending on the flags. The source is read from in,
+// or from the file if in is nil.
+func formatFile(path string, in io.Reader, out io.Writer) error {
+	var src []byte
+	var err error
+	if in == nil {
+		src, err = os.ReadFile(path)
+	} else {
+		src, err = io.ReadAll(in)
+	}
+	if err != nil {
+		return err
+	}
+
+	formatted, err := Format(src, path)
+	if err != nil {
+		return err
+	}
+
+	changed := !bytes.Equal(src, formatted)
+	if *diff && changed {
+		fmt.Fprint(out, unifiedDiff(path+".orig", path, src, formatted))
+	}
+	if *write && changed {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, formatted, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	if !*diff && !*write {
+		_, err = out.Write(formatted)
+	}
+	return err
+}
@@ -0,0 +1,262 @@
+package printer
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jamesroutley/mal/impls/go/src/types"
+)
+
+// Options configure Pretty
+type Options struct {
+	// Width is the number of columns Pretty tries to fit each line into
+	Width int
+	// Indent is how far the arguments of a call (a list which starts with a
+	// symbol) are indented, relative to its opening bracket, when it doesn't
+	// fit on one line. The items of other collections line up with the first
+	// item.
+	Indent int
+	// Length is the number of items of each collection which are printed,
+	// followed by ... if there are more, or -1 to print them all
+	Length int
+	// Level is how deeply nested collections can be before they're printed as
+	// #, or -1 to print them all
+	Level int
+}
+
+// DefaultOptions prints everything, in 80 columns
+var DefaultOptions = Options{
+	Width:  80,
+	Indent: 2,
+	Length: -1,
+	Level:  -1,
+}
+
+// Pretty prints t over as many lines as it needs to fit into opts.Width.
+// Collections which fit on the rest of the line are printed on it, like
+// PrStr prints them. Collections which don't are broken up, one item per
+// line, or one key and value per line for a hash map.
+//
+// A lazy seq which contains itself, or whose rest is a seq it's already part
+// of, is printed as #<cycle> at the point it repeats, rather than forever. A
+// lazy seq which fails to be realised is printed as far as it got, followed
+// by the error.
+func Pretty(t types.MalType, opts Options) string {
+	s, _ := PrettyPrint(t, opts)
+	return s
+}
+
+// PrettyPrint is like Pretty, but if realising a lazy seq fails, it returns
+// the error, along with the rest of what was printed
+func PrettyPrint(t types.MalType, opts Options) (string, error) {
+	p := &prettyPrinter{
+		opts:     opts,
+		visiting: map[types.MalType]bool{},
+	}
+	n := p.node(t, 0)
+	p.layout(n)
+	return p.out.String(), p.err
+}
+
+// prettyNode is a value which is ready to be laid out. Atoms just have text.
+// Collections have their brackets and items, and their text is what they'd
+// look like on one line.
+type prettyNode struct {
+	text        string
+	open, close string
+	items       []*prettyNode
+	// call is true for a list which starts with a symbol, whose arguments
+	// are indented by Options.Indent
+	call bool
+	// pairs is true for a hash map, whose items alternate between keys and
+	// values
+	pairs bool
+	// more is true if the collection has been cut short by Options.Length
+	more bool
+}
+
+const (
+	cycleText     = "#<cycle>"
+	truncatedText = "..."
+)
+
+func atom(text string) *prettyNode {
+	return &prettyNode{text: text}
+}
+
+type prettyPrinter struct {
+	opts Options
+	// visiting holds the collections which contain the one being printed
+	visiting map[types.MalType]bool
+	out      strings.Builder
+	// column is where the next character written to out goes, counting from 0
+	column int
+	// err is the first error returned by realising a lazy seq
+	err error
+}
+
+// node converts t, which is nested depth collections deep, to a prettyNode
+func (p *prettyPrinter) node(t types.MalType, depth int) *prettyNode {
+	var n *prettyNode
+	switch t := t.(type) {
+	case *types.MalList:
+		n = &prettyNode{open: "(", close: ")"}
+		if t.Len() > 0 {
+			_, n.call = t.First().(*types.MalSymbol)
+		}
+	case *types.MalLazySeq:
+		n = &prettyNode{open: "(", close: ")"}
+	case *types.MalVector:
+		n = &prettyNode{open: "[", close: "]"}
+	case *types.MalSet:
+		n = &prettyNode{open: "#{", close: "}"}
+	case *types.MalHashMap:
+		n = &prettyNode{open: "{", close: "}", pairs: true}
+	default:
+		return atom(t.String())
+	}
+
+	if p.visiting[t] {
+		return atom(cycleText)
+	}
+	if p.opts.Level >= 0 && depth >= p.opts.Level {
+		return atom("#")
+	}
+	p.visiting[t] = true
+	defer delete(p.visiting, t)
+
+	if m, ok := t.(*types.MalHashMap); ok {
+		keys, values := m.Keys(), m.Values()
+		for i := range keys {
+			if p.opts.Length >= 0 && i >= p.opts.Length {
+				n.more = true
+				break
+			}
+			n.items = append(n.items, p.node(keys[i], depth+1), p.node(values[i], depth+1))
+		}
+	} else {
+		p.seqItems(n, t, depth)
+	}
+	n.text = n.flat()
+	return n
+}
+
+// seqItems adds the items of seq to n. Lazy seqs are only realised as far as
+// the items which are printed.
+func (p *prettyPrinter) seqItems(n *prettyNode, seq types.MalType, depth int) {
+	// seen holds the lazy seqs this one's been walked through, so a seq
+	// which loops back on itself can be spotted
+	seen := map[types.MalType]bool{}
+	for i := 0; ; i++ {
+		if lazy, ok := seq.(*types.MalLazySeq); ok {
+			if seen[lazy] {
+				n.items = append(n.items, atom(cycleText))
+				return
+			}
+			seen[lazy] = true
+		}
+		first, rest, ok, err := types.Next(seq)
+		if err != nil {
+			if p.err == nil {
+				p.err = err
+			}
+			n.items = append(n.items, atom((&types.MalError{Message: err.Error()}).String()))
+			return
+		}
+		if !ok {
+			return
+		}
+		if p.opts.Length >= 0 && i >= p.opts.Length {
+			n.more = true
+			return
+		}
+		n.items = append(n.items, p.node(first, depth+1))
+		seq = rest
+	}
+}
+
+// flat returns what n looks like on one line
+func (n *prettyNode) flat() string {
+	if n.open == "" {
+		return n.text
+	}
+	texts := make([]string, 0, len(n.items)+1)
+	for _, item := range n.items {
+		texts = append(texts, item.text)
+	}
+	if n.more {
+		texts = append(texts, truncatedText)
+	}
+	return n.open + strings.Join(texts, " ") + n.close
+}
+
+func (p *prettyPrinter) write(s string) {
+	p.out.WriteString(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		p.column = utf8.RuneCountInString(s[i+1:])
+	} else {
+		p.column += utf8.RuneCountInString(s)
+	}
+}
+
+// newline starts a new line, indented to column
+func (p *prettyPrinter) newline(column int) {
+	p.write("\n" + strings.Repeat(" ", column))
+}
+
+// layout writes n, starting at the current column
+func (p *prettyPrinter) layout(n *prettyNode) {
+	if n.open == "" || len(n.items) == 0 || p.column+utf8.RuneCountInString(n.text) <= p.opts.Width {
+		p.write(n.text)
+		return
+	}
+
+	start := p.column
+	p.write(n.open)
+	// Items line up with the first one, unless this is a call
+	itemColumn := p.column
+	items := n.items
+	switch {
+	case n.pairs:
+		p.layoutPairs(items, itemColumn)
+	case n.call:
+		// The function and its first argument go on the first line, and the
+		// rest of the arguments are indented underneath
+		itemColumn = start + p.opts.Indent
+		for i, item := range items {
+			switch i {
+			case 0:
+			case 1:
+				p.write(" ")
+			default:
+				p.newline(itemColumn)
+			}
+			p.layout(item)
+		}
+	default:
+		for i, item := range items {
+			if i > 0 {
+				p.newline(itemColumn)
+			}
+			p.layout(item)
+		}
+	}
+	if n.more {
+		p.newline(itemColumn)
+		p.write(truncatedText)
+	}
+	p.write(n.close)
+}
+
+// layoutPairs writes the keys and values of a hash map, one pair per line.
+// Each value goes on the same line as its key, after it.
+func (p *prettyPrinter) layoutPairs(items []*prettyNode, column int) {
+	for i := 0; i < len(items); i += 2 {
+		if i > 0 {
+			p.newline(column)
+		}
+		p.layout(items[i])
+		p.write(" ")
+		p.layout(items[i+1])
+	}
+}
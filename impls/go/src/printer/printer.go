@@ -1,7 +1,34 @@
 package printer
 
-import "github.com/jamesroutley/mal/impls/go/src/types"
+import (
+	"math"
 
+	"github.com/jamesroutley/mal/impls/go/src/types"
+)
+
+// flatOptions print everything on one line
+var flatOptions = Options{
+	Width:  math.MaxInt,
+	Length: -1,
+	Level:  -1,
+}
+
+// PrStr prints t on one line. It's like t.String(), except that it doesn't
+// loop forever on a lazy seq which contains itself.
 func PrStr(t types.MalType) string {
+	switch t.(type) {
+	case *types.MalList, *types.MalLazySeq, *types.MalVector, *types.MalSet, *types.MalHashMap:
+		return Pretty(t, flatOptions)
+	}
 	return t.String()
 }
+
+// Print is like PrStr, but if realising a lazy seq fails, it returns the
+// error, rather than printing it as part of the seq
+func Print(t types.MalType) (string, error) {
+	switch t.(type) {
+	case *types.MalList, *types.MalLazySeq, *types.MalVector, *types.MalSet, *types.MalHashMap:
+		return PrettyPrint(t, flatOptions)
+	}
+	return t.String(), nil
+}
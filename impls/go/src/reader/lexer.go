@@ -0,0 +1,217 @@
+package reader
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"unicode"
+
+	"github.com/jamesroutley/mal/impls/go/src/types"
+)
+
+// token is a single token read by the lexer, and where it starts
+type token struct {
+	Value string
+	Pos   *types.Position
+}
+
+// lexer splits mal source code into tokens, reading from an io.Reader one
+// rune at a time. It produces the same tokens as Tokenize, except that it
+// drops comments, unless keepComments is set.
+type lexer struct {
+	r        *bufio.Reader
+	filename string
+	line     int
+	column   int
+	// keepComments makes the lexer return comments as tokens, which run from
+	// the ; to the end of the line
+	keepComments bool
+}
+
+func newLexer(r io.Reader, filename string) *lexer {
+	return &lexer{
+		r:        bufio.NewReader(r),
+		filename: filename,
+		line:     1,
+		column:   1,
+	}
+}
+
+// specialChars are tokens on their own. `~` is also the start of `~@`. `#{`,
+// which opens a set, is a token too.
+const specialChars = "[]{}()'`~^@"
+
+// symbolTerminators end a symbol (or number, or keyword) token
+const symbolTerminators = "[]{}('\"`,;)"
+
+func isSeparator(c rune) bool {
+	return unicode.IsSpace(c) || c == ','
+}
+
+// next returns the next token. It returns io.EOF when there are no tokens
+// left.
+func (l *lexer) next() (*token, error) {
+	if err := l.skipSeparators(); err != nil {
+		return nil, err
+	}
+
+	pos := &types.Position{
+		File:   l.filename,
+		Line:   l.line,
+		Column: l.column,
+	}
+	c, err := l.readRune()
+	if err != nil {
+		return nil, err
+	}
+
+	var value strings.Builder
+	value.WriteRune(c)
+
+	switch {
+	case c == '~':
+		if next, err := l.peekRune(); err == nil && next == '@' {
+			l.readRune()
+			value.WriteRune(next)
+		}
+
+	case strings.ContainsRune(specialChars, c):
+		// Special characters are tokens on their own
+
+	// Comments are only returned by lexers which keep them. They run to the
+	// end of the line.
+	case c == ';':
+		for {
+			c, err := l.peekRune()
+			if err == io.EOF || (err == nil && c == '\n') {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			l.readRune()
+			value.WriteRune(c)
+		}
+
+	case c == '#':
+		if next, err := l.peekRune(); err == nil && next == '{' {
+			l.readRune()
+			value.WriteRune(next)
+			break
+		}
+		if err := l.readSymbol(&value); err != nil {
+			return nil, err
+		}
+
+	// A character is a backslash followed by any character, even one which
+	// would usually end a token (e.g. \( or \ ), and then the rest of its
+	// name, e.g. \newline
+	case c == '\\':
+		next, err := l.readRune()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		value.WriteRune(next)
+		if err := l.readSymbol(&value); err != nil {
+			return nil, err
+		}
+
+	// Strings run to the next unescaped double quote. Unterminated strings
+	// run to the end of the input, and are rejected by ReadAtom.
+	case c == '"':
+		escaped := false
+		for {
+			c, err := l.readRune()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			value.WriteRune(c)
+			if c == '"' && !escaped {
+				break
+			}
+			escaped = c == '\\' && !escaped
+		}
+
+	default:
+		if err := l.readSymbol(&value); err != nil {
+			return nil, err
+		}
+	}
+
+	return &token{
+		Value: value.String(),
+		Pos:   pos,
+	}, nil
+}
+
+// readSymbol reads the rest of a symbol (or number, or keyword) token into
+// value
+func (l *lexer) readSymbol(value *strings.Builder) error {
+	for {
+		c, err := l.peekRune()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if isSeparator(c) || strings.ContainsRune(symbolTerminators, c) {
+			return nil
+		}
+		l.readRune()
+		value.WriteRune(c)
+	}
+}
+
+// skipSeparators consumes whitespace, commas and comments (unless the lexer
+// keeps them)
+func (l *lexer) skipSeparators() error {
+	inComment := false
+	for {
+		c, err := l.peekRune()
+		if err != nil {
+			return err
+		}
+		switch {
+		case c == '\n':
+			inComment = false
+		case c == ';' && l.keepComments:
+			return nil
+		case c == ';':
+			inComment = true
+		case !inComment && !isSeparator(c):
+			return nil
+		}
+		l.readRune()
+	}
+}
+
+// readRune consumes the next rune, keeping track of the line and column
+func (l *lexer) readRune() (rune, error) {
+	c, _, err := l.r.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	if c == '\n' {
+		l.line++
+		l.column = 1
+	} else {
+		l.column++
+	}
+	return c, nil
+}
+
+// peekRune returns the next rune without consuming it
+func (l *lexer) peekRune() (rune, error) {
+	c, _, err := l.r.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	return c, l.r.UnreadRune()
+}
@@ -2,6 +2,7 @@ package reader
 
 import (
 	"fmt"
+	"io"
 	"regexp"
 	"strconv"
 	"strings"
@@ -9,9 +10,16 @@ import (
 	"github.com/jamesroutley/mal/impls/go/src/types"
 )
 
+// Reader supplies tokens to the parsing functions below. Tokens either come
+// from a slice produced by Tokenize, or on demand from a lexer.
 type Reader struct {
 	Tokens   []string
 	Position int
+
+	lexer *lexer
+	// peeked is the next token from lexer, if Peek has been called since the
+	// last call to Next
+	peeked *token
 }
 
 func NewReader(tokens []string) *Reader {
@@ -21,31 +29,132 @@ func NewReader(tokens []string) *Reader {
 	}
 }
 
+func newLexerReader(r io.Reader, filename string) *Reader {
+	return &Reader{
+		lexer: newLexer(r, filename),
+	}
+}
+
+// peekToken returns the next token from the lexer without consuming it
+func (r *Reader) peekToken() (*token, error) {
+	if r.peeked == nil {
+		tok, err := r.lexer.next()
+		if err != nil {
+			return nil, err
+		}
+		r.peeked = tok
+	}
+	return r.peeked, nil
+}
+
+// pos returns the position of the token that Peek would return, or nil if
+// it isn't known
+func (r *Reader) pos() *types.Position {
+	if r.lexer == nil {
+		return nil
+	}
+	tok, err := r.peekToken()
+	if err != nil {
+		return nil
+	}
+	return tok.Pos
+}
+
 func (r *Reader) Peek() (string, error) {
+	if r.lexer != nil {
+		tok, err := r.peekToken()
+		if err != nil {
+			return "", err
+		}
+		return tok.Value, nil
+	}
 	if r.Position == len(r.Tokens) {
-		return "", fmt.Errorf("EOF")
+		return "", io.EOF
 	}
 	return strings.Trim(r.Tokens[r.Position], " ,\n\t"), nil
 }
 
 func (r *Reader) Next() (string, error) {
+	if r.lexer != nil {
+		tok, err := r.peekToken()
+		if err != nil {
+			return "", err
+		}
+		r.peeked = nil
+		return tok.Value, nil
+	}
 	if r.Position == len(r.Tokens) {
-		return "", fmt.Errorf("EOF")
+		return "", io.EOF
 	}
 	current := strings.Trim(r.Tokens[r.Position], " ,\n\t")
 	r.Position++
 	return current, nil
 }
 
+// StreamReader reads forms one at a time from an io.Reader, without needing
+// the whole input up front.
+type StreamReader struct {
+	reader *Reader
+}
+
+func NewStreamReader(r io.Reader) *StreamReader {
+	return NewStreamReaderWithFilename(r, "")
+}
+
+// NewStreamReaderWithFilename returns a StreamReader which records filename in
+// the positions of the lists and symbols it reads
+func NewStreamReaderWithFilename(r io.Reader, filename string) *StreamReader {
+	return &StreamReader{
+		reader: newLexerReader(r, filename),
+	}
+}
+
+// Next reads the next form. It returns io.EOF once there are no forms left,
+// and io.ErrUnexpectedEOF if the input ends part way through a form.
+func (s *StreamReader) Next() (types.MalType, error) {
+	if _, err := s.reader.Peek(); err != nil {
+		return nil, err
+	}
+	form, err := ReadForm(s.reader)
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return form, err
+}
+
 func ReadStr(s string) (types.MalType, error) {
-	tokens := Tokenize(s)
-	reader := NewReader(tokens)
-	return ReadForm(reader)
+	return ReadStrWithFilename(s, "")
+}
+
+// ReadStrWithFilename reads the first form in s, recording filename in the
+// positions of the lists and symbols it reads
+func ReadStrWithFilename(s string, filename string) (types.MalType, error) {
+	return ReadForm(newLexerReader(strings.NewReader(s), filename))
+}
+
+// ReadAll reads every form in s
+func ReadAll(s string, filename string) ([]types.MalType, error) {
+	reader := NewStreamReaderWithFilename(strings.NewReader(s), filename)
+	var forms []types.MalType
+	for {
+		form, err := reader.Next()
+		if err == io.EOF {
+			return forms, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		forms = append(forms, form)
+	}
 }
 
+var tokenRegexp = regexp.MustCompile(`[\s,]*(~@|#\{|\\.[^\s\[\]{}('"` + "`" + `,;)]*|[\[\]{}()'` + "`" + `~^@]|"(?:\\.|[^\\"])*"?|;.*|[^\s\[\]{}('"` + "`" + `,;)]*)`)
+
+// Tokenize splits s into tokens using a regular expression. The reader now
+// uses the lexer in lexer.go instead, which doesn't need the whole input in
+// memory; Tokenize is kept for DebugTokens and for comparison.
 func Tokenize(s string) []string {
-	re := regexp.MustCompile(`[\s,]*(~@|[\[\]{}()'` + "`" + `~^@]|"(?:\\.|[^\\"])*"?|;.*|[^\s\[\]{}('"` + "`" + `,;)]*)`)
-	return re.FindAllString(s, -1)
+	return tokenRegexp.FindAllString(s, -1)
 }
 
 func ReadForm(reader *Reader) (types.MalType, error) {
@@ -55,18 +164,104 @@ func ReadForm(reader *Reader) (types.MalType, error) {
 	}
 	switch token {
 	case "(":
+		pos := reader.pos()
 		// Increment the position pointer
 		_, err = reader.Next()
 		if err != nil {
 			return nil, err
 		}
-		return ReadList(reader)
+		list, err := ReadList(reader)
+		if err != nil {
+			return nil, err
+		}
+		list.(*types.MalList).Pos = pos
+		return list, nil
+	case "[":
+		_, err = reader.Next()
+		if err != nil {
+			return nil, err
+		}
+		return ReadVector(reader)
+	case "{":
+		_, err = reader.Next()
+		if err != nil {
+			return nil, err
+		}
+		return ReadHashMap(reader)
+	case "#{":
+		_, err = reader.Next()
+		if err != nil {
+			return nil, err
+		}
+		return ReadSet(reader)
+	case "^":
+		return readWithMeta(reader)
 	default:
 		return ReadAtom(reader)
 	}
 }
 
 func ReadList(reader *Reader) (types.MalType, error) {
+	items, err := readItems(reader, ")")
+	if err != nil {
+		return nil, err
+	}
+	return types.NewList(items...), nil
+}
+
+// ReadVector reads the items of a vector, after its opening [
+func ReadVector(reader *Reader) (types.MalType, error) {
+	items, err := readItems(reader, "]")
+	if err != nil {
+		return nil, err
+	}
+	return types.NewVector(items...), nil
+}
+
+// ReadHashMap reads the keys and values of a hash map, after its opening {
+func ReadHashMap(reader *Reader) (types.MalType, error) {
+	items, err := readItems(reader, "}")
+	if err != nil {
+		return nil, err
+	}
+	return types.NewHashMap(items...)
+}
+
+// ReadSet reads the items of a set, after its opening #{
+func ReadSet(reader *Reader) (types.MalType, error) {
+	items, err := readItems(reader, "}")
+	if err != nil {
+		return nil, err
+	}
+	return types.NewSet(items...)
+}
+
+// readWithMeta reads ^meta form, which is shorthand for
+// (with-meta form meta)
+func readWithMeta(reader *Reader) (types.MalType, error) {
+	pos := reader.pos()
+	// Skip the ^
+	if _, err := reader.Next(); err != nil {
+		return nil, err
+	}
+	meta, err := ReadForm(reader)
+	if err != nil {
+		return nil, err
+	}
+	form, err := ReadForm(reader)
+	if err != nil {
+		return nil, err
+	}
+	return types.NewListAt(
+		pos,
+		&types.MalSymbol{Value: "with-meta", Pos: pos},
+		form,
+		meta,
+	), nil
+}
+
+// readItems reads forms until it reaches the token close, which it skips
+func readItems(reader *Reader, close string) ([]types.MalType, error) {
 	var items []types.MalType
 	for {
 		// TODO: error case when we hit file without closing bracket
@@ -74,15 +269,13 @@ func ReadList(reader *Reader) (types.MalType, error) {
 		if err != nil {
 			return nil, err
 		}
-		if tok == ")" {
+		if tok == close {
 			// Increment the position pointer
 			_, err := reader.Next()
 			if err != nil {
 				return nil, err
 			}
-			return &types.MalList{
-				Items: items,
-			}, nil
+			return items, nil
 		}
 		item, err := ReadForm(reader)
 		if err != nil {
@@ -93,6 +286,7 @@ func ReadList(reader *Reader) (types.MalType, error) {
 }
 
 func ReadAtom(reader *Reader) (types.MalType, error) {
+	pos := reader.pos()
 	token, err := reader.Next()
 	if err != nil {
 		return nil, err
@@ -115,6 +309,16 @@ func ReadAtom(reader *Reader) (types.MalType, error) {
 		return &types.MalNil{}, nil
 	}
 
+	if strings.HasPrefix(token, ":") && len(token) > 1 {
+		return &types.MalKeyword{
+			Value: token[1:],
+		}, nil
+	}
+
+	if strings.HasPrefix(token, `\`) {
+		return types.CharNamed(token[1:])
+	}
+
 	if strings.HasPrefix(token, `"`) {
 		if !strings.HasSuffix(token, `"`) {
 			return nil, fmt.Errorf("unclosed string")
@@ -127,6 +331,7 @@ func ReadAtom(reader *Reader) (types.MalType, error) {
 
 	return &types.MalSymbol{
 		Value: token,
+		Pos:   pos,
 	}, nil
 }
 
@@ -137,8 +342,8 @@ func DebugType(m types.MalType) {
 func debugType(m types.MalType, indent int) string {
 	switch tok := m.(type) {
 	case *types.MalList:
-		itemStrings := make([]string, len(tok.Items))
-		for i, item := range tok.Items {
+		itemStrings := make([]string, tok.Len())
+		for i, item := range tok.Items() {
 			itemStrings[i] = debugType(item, 0)
 		}
 		return fmt.Sprintf("(%s)", strings.Join(itemStrings, " "))
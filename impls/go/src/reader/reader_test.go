@@ -0,0 +1,155 @@
+package reader
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/jamesroutley/mal/impls/go/src/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const benchmarkForm = `
+;; fib, from impls/tests/perf1.mal
+(def! fib (fn* (N)
+  (if (= N 0)
+    1
+    (if (= N 1)
+      1
+      (+ (fib (- N 1)) (fib (- N 2)))))))
+(prn "a string, with \"escapes\"" ~@(a b) [1 2] {"c" 3} #{4 #{5}} value# [\a \( \newline \é])
+`
+
+// benchmarkSource is roughly the size of a large file loaded with slurp
+var benchmarkSource = strings.Repeat(benchmarkForm, 1000)
+
+func TestLexerMatchesTokenize(t *testing.T) {
+	var expected []string
+	for _, tok := range Tokenize(benchmarkForm) {
+		tok = strings.Trim(tok, " ,\n\t")
+		if tok == "" || strings.HasPrefix(tok, ";") {
+			continue
+		}
+		expected = append(expected, tok)
+	}
+
+	var actual []string
+	lexer := newLexer(strings.NewReader(benchmarkForm), "")
+	for {
+		tok, err := lexer.next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		actual = append(actual, tok.Value)
+	}
+
+	assert.Equal(t, expected, actual)
+}
+
+func TestStreamReader(t *testing.T) {
+	reader := NewStreamReaderWithFilename(strings.NewReader("(a b)\n  c ; comment\n(d"), "test.mal")
+
+	form, err := reader.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "(a b)", form.String())
+
+	form, err = reader.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "c", form.String())
+	assert.Equal(t, "test.mal:2:3", types.PositionOf(form).String())
+
+	_, err = reader.Next()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestReadHashMapsAndMetadata(t *testing.T) {
+	for input, expected := range map[string]string{
+		`{:a 1 "b" (c)}`:    `{:a 1 "b" (c)}`,
+		"{}":                "{}",
+		"^{:a 1} (f)":       "(with-meta (f) {:a 1})",
+		"(:a ^:b c)":        "(:a (with-meta c :b))",
+		"{:a {:b :c}}":      "{:a {:b :c}}",
+		"(a {:b (c {})} d)": "(a {:b (c {})} d)",
+		"[1 [2] {:a []}]":   "[1 [2] {:a []}]",
+		"#{1 #{2} [3] 1}":   "#{1 #{2} [3]}",
+	} {
+		form, err := ReadStr(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, expected, form.String(), input)
+	}
+
+	_, err := ReadStr("{:a}")
+	assert.Error(t, err)
+}
+
+func TestReadChars(t *testing.T) {
+	for input, expected := range map[string]rune{
+		`\a`:       'a',
+		`\é`:       'é',
+		`\(`:       '(',
+		`\\`:       '\\',
+		`\newline`: '\n',
+		`\space`:   ' ',
+		`\u00e9`:   'é',
+	} {
+		form, err := ReadStr(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, &types.MalChar{Value: expected}, form, input)
+	}
+
+	form, err := ReadStr(`(\a \b \) \newline)`)
+	require.NoError(t, err)
+	assert.Equal(t, `(\a \b \) \newline)`, form.String())
+
+	_, err = ReadStr(`\ab`)
+	assert.EqualError(t, err, `unknown character \ab`)
+}
+
+func TestReadSyntax(t *testing.T) {
+	forms, err := ReadSyntax(strings.NewReader("; top\n(a 'b ; end\n\n  c)\n"), "test.mal")
+	require.NoError(t, err)
+	require.Len(t, forms, 2)
+	assert.Equal(t, "; top", forms[0].Text)
+	assert.True(t, forms[0].IsComment())
+
+	list := forms[1]
+	assert.True(t, list.IsCollection())
+	assert.Equal(t, "test.mal:2:1", list.Pos.String())
+	require.Len(t, list.Items, 4)
+	quoted := list.Items[1]
+	assert.True(t, quoted.IsReaderMacro())
+	assert.Equal(t, "b", quoted.Items[0].Text)
+	comment := list.Items[2]
+	assert.Equal(t, "; end", comment.Text)
+	assert.False(t, comment.NewLine)
+	c := list.Items[3]
+	assert.True(t, c.NewLine)
+	assert.True(t, c.BlankLine)
+}
+
+func BenchmarkTokenize(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		Tokenize(benchmarkSource)
+	}
+}
+
+func BenchmarkLexer(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		lexer := newLexer(strings.NewReader(benchmarkSource), "")
+		for {
+			if _, err := lexer.next(); err != nil {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkStreamReader(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := ReadAll(benchmarkSource, ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
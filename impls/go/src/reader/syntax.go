@@ -0,0 +1,198 @@
+package reader
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jamesroutley/mal/impls/go/src/types"
+)
+
+// Syntax is a form as it was written in source code. Unlike the values
+// ReadForm returns, it keeps comments, reader macros like 'x and ^meta x, the
+// text of each atom, and where the line breaks were, so the source can be
+// written back out (e.g. by malfmt) without changing what it means.
+type Syntax struct {
+	// Text is the text of an atom or comment, the opening bracket of a
+	// collection ("(", "[", "{" or "#{"), or a reader macro ("'", "`", "~",
+	// "~@", "@" or "^")
+	Text string
+	// Items are the forms in a collection, including comments, or the form a
+	// reader macro applies to. ^ applies to two forms: the metadata, and the
+	// form it's added to.
+	Items []*Syntax
+	Pos   *types.Position
+	// NewLine is true if the form starts on a new line: there's a line break
+	// between it and whatever came before it
+	NewLine bool
+	// BlankLine is true if there's at least one blank line before the form
+	BlankLine bool
+}
+
+// readerMacros are tokens which apply to the form after them
+var readerMacros = map[string]int{
+	"'":  1,
+	"`":  1,
+	"~":  1,
+	"~@": 1,
+	"@":  1,
+	"^":  2,
+}
+
+// closingBrackets maps the opening bracket of each kind of collection to its
+// closing bracket
+var closingBrackets = map[string]string{
+	"(":  ")",
+	"[":  "]",
+	"{":  "}",
+	"#{": "}",
+}
+
+// IsComment reports whether s is a comment
+func (s *Syntax) IsComment() bool {
+	return strings.HasPrefix(s.Text, ";")
+}
+
+// IsCollection reports whether s is a list, vector, hash map or set
+func (s *Syntax) IsCollection() bool {
+	_, ok := closingBrackets[s.Text]
+	return ok
+}
+
+// IsReaderMacro reports whether s is a reader macro, like 'x
+func (s *Syntax) IsReaderMacro() bool {
+	_, ok := readerMacros[s.Text]
+	return ok
+}
+
+// ClosingBracket returns the bracket which closes a collection which starts
+// with open
+func ClosingBracket(open string) string {
+	return closingBrackets[open]
+}
+
+// syntaxReader parses tokens, including comments, into Syntax
+type syntaxReader struct {
+	lexer  *lexer
+	peeked *token
+	// line is the line the last token read ended on
+	line int
+}
+
+// ReadSyntax reads every form in r, along with the comments between them
+func ReadSyntax(r io.Reader, filename string) ([]*Syntax, error) {
+	lexer := newLexer(r, filename)
+	lexer.keepComments = true
+	reader := &syntaxReader{lexer: lexer}
+	var forms []*Syntax
+	for {
+		_, err := reader.peek()
+		if err == io.EOF {
+			return forms, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		form, err := reader.form()
+		if err != nil {
+			return nil, err
+		}
+		forms = append(forms, form)
+	}
+}
+
+func isClosingBracket(s string) bool {
+	return s == ")" || s == "]" || s == "}"
+}
+
+func (r *syntaxReader) peek() (*token, error) {
+	if r.peeked == nil {
+		tok, err := r.lexer.next()
+		if err != nil {
+			return nil, err
+		}
+		r.peeked = tok
+	}
+	return r.peeked, nil
+}
+
+// next consumes the next token, and returns it as a Syntax, recording
+// whether it started on a new line
+func (r *syntaxReader) next() (*Syntax, error) {
+	tok, err := r.peek()
+	if err != nil {
+		return nil, err
+	}
+	r.peeked = nil
+	s := &Syntax{
+		Text:      tok.Value,
+		Pos:       tok.Pos,
+		NewLine:   tok.Pos.Line > r.line,
+		BlankLine: tok.Pos.Line > r.line+1,
+	}
+	// Strings can run over several lines
+	r.line = tok.Pos.Line + strings.Count(tok.Value, "\n")
+	return s, nil
+}
+
+// form reads a form, or a comment
+func (r *syntaxReader) form() (*Syntax, error) {
+	s, err := r.next()
+	if err != nil {
+		return nil, err
+	}
+	// Collections consume their own closing brackets, so this one's out of
+	// place (e.g. it follows a reader macro)
+	if isClosingBracket(s.Text) {
+		return nil, fmt.Errorf("%s: unexpected %s", s.Pos, s.Text)
+	}
+	if n, ok := readerMacros[s.Text]; ok {
+		for i := 0; i < n; i++ {
+			item, err := r.form()
+			if err == io.EOF {
+				return nil, fmt.Errorf("%s: %s isn't followed by a form", s.Pos, s.Text)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if item.IsComment() {
+				return nil, fmt.Errorf("%s: %s is followed by a comment, rather than a form", s.Pos, s.Text)
+			}
+			s.Items = append(s.Items, item)
+		}
+		return s, nil
+	}
+	if close, ok := closingBrackets[s.Text]; ok {
+		return s, r.items(s, close)
+	}
+	if strings.HasPrefix(s.Text, `"`) && (len(s.Text) == 1 || !strings.HasSuffix(s.Text, `"`)) {
+		return nil, fmt.Errorf("%s: unclosed string", s.Pos)
+	}
+	return s, nil
+}
+
+// items reads the items of a collection, up to and including its closing
+// bracket
+func (r *syntaxReader) items(coll *Syntax, close string) error {
+	for {
+		tok, err := r.peek()
+		if err == io.EOF {
+			return fmt.Errorf("%s: unclosed %s", coll.Pos, coll.Text)
+		}
+		if err != nil {
+			return err
+		}
+		if isClosingBracket(tok.Value) {
+			if tok.Value != close {
+				return fmt.Errorf("%s: %s closes %s at %s", tok.Pos, tok.Value, coll.Text, coll.Pos)
+			}
+			_, err := r.next()
+			return err
+		}
+		item, err := r.form()
+		if err != nil {
+			return err
+		}
+		coll.Items = append(coll.Items, item)
+	}
+}
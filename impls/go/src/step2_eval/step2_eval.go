@@ -48,7 +48,7 @@ func Read(s string) (types.MalType, error) {
 func Eval(ast types.MalType, env *environment.Env) (types.MalType, error) {
 	switch tok := ast.(type) {
 	case *types.MalList:
-		if len(tok.Items) == 0 {
+		if tok.Len() == 0 {
 			return ast, nil
 		}
 
@@ -66,12 +66,12 @@ func Eval(ast types.MalType, env *environment.Env) (types.MalType, error) {
 
 		reader.DebugType(evaluatedList)
 
-		function, ok := evaluatedList.Items[0].(*types.MalFunction)
+		function, ok := evaluatedList.Items()[0].(*types.MalFunction)
 		if !ok {
 			return nil, fmt.Errorf("First item in list isn't a function")
 		}
 
-		return function.Func(evaluatedList.Items[1:len(evaluatedList.Items)]...)
+		return function.Func(evaluatedList.Items()[1:evaluatedList.Len()]...)
 	}
 	return evalAST(ast, env)
 }
@@ -102,17 +102,15 @@ func evalAST(ast types.MalType, env *environment.Env) (types.MalType, error) {
 		}
 		return value, nil
 	case *types.MalList:
-		items := make([]types.MalType, len(tok.Items))
-		for i, item := range tok.Items {
+		items := make([]types.MalType, tok.Len())
+		for i, item := range tok.Items() {
 			evaluated, err := Eval(item, env)
 			if err != nil {
 				return nil, err
 			}
 			items[i] = evaluated
 		}
-		return &types.MalList{
-			Items: items,
-		}, nil
+		return types.NewList(items...), nil
 	}
 	return ast, nil
 }
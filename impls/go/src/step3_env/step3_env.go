@@ -68,7 +68,7 @@ func Eval(ast types.MalType, env *environment.Env) (types.MalType, error) {
 	if !ok {
 		return evalAST(ast, env)
 	}
-	if len(list.Items) == 0 {
+	if list.Len() == 0 {
 		return ast, nil
 	}
 
@@ -82,11 +82,11 @@ func Eval(ast types.MalType, env *environment.Env) (types.MalType, error) {
 		return nil, fmt.Errorf("list did not evaluate to a list")
 	}
 
-	function, ok := evaluatedList.Items[0].(*types.MalFunction)
+	function, ok := evaluatedList.Items()[0].(*types.MalFunction)
 	if !ok {
 		return nil, fmt.Errorf("first item in list isn't a function")
 	}
-	return function.Func(evaluatedList.Items[1:]...)
+	return function.Func(evaluatedList.Items()[1:]...)
 }
 
 // Print prints the AST as a human readable string. It's not inteded for debugging
@@ -120,17 +120,15 @@ func evalAST(ast types.MalType, env *environment.Env) (types.MalType, error) {
 		}
 		return value, nil
 	case *types.MalList:
-		items := make([]types.MalType, len(tok.Items))
-		for i, item := range tok.Items {
+		items := make([]types.MalType, tok.Len())
+		for i, item := range tok.Items() {
 			evaluated, err := Eval(item, env)
 			if err != nil {
 				return nil, err
 			}
 			items[i] = evaluated
 		}
-		return &types.MalList{
-			Items: items,
-		}, nil
+		return types.NewList(items...), nil
 	}
 	return ast, nil
 }
@@ -140,7 +138,7 @@ func isSpecialForm(ast types.MalType) (operator *types.MalSymbol, args []types.M
 	if !ok {
 		return nil, nil, false
 	}
-	items := tok.Items
+	items := tok.Items()
 	if len(items) == 0 {
 		return nil, nil, false
 	}
@@ -185,17 +183,17 @@ func evalSpecialForm(
 		if !ok {
 			return nil, fmt.Errorf("let*: first arg isn't a list")
 		}
-		if len(bindingList.Items)%2 != 0 {
+		if bindingList.Len()%2 != 0 {
 			return nil, fmt.Errorf("let*: first arg doesn't have an even number of items")
 		}
 
 		childEnv := env.ChildEnv()
-		for i := 0; i < len(bindingList.Items); i += 2 {
-			key, ok := bindingList.Items[i].(*types.MalSymbol)
+		for i := 0; i < bindingList.Len(); i += 2 {
+			key, ok := bindingList.Items()[i].(*types.MalSymbol)
 			if !ok {
 				return nil, fmt.Errorf("let*: binding list: arg %d isn't a symbol", i)
 			}
-			value, err := Eval(bindingList.Items[i+1], childEnv)
+			value, err := Eval(bindingList.Items()[i+1], childEnv)
 			if err != nil {
 				return nil, err
 			}
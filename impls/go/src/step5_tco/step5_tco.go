@@ -94,7 +94,7 @@ top:
 	if !ok {
 		return evalAST(ast, env)
 	}
-	if len(list.Items) == 0 {
+	if list.Len() == 0 {
 		return ast, nil
 	}
 
@@ -110,19 +110,19 @@ top:
 		return nil, fmt.Errorf("list did not evaluate to a list")
 	}
 
-	function, ok := evaluatedList.Items[0].(*types.MalFunction)
+	function, ok := evaluatedList.Items()[0].(*types.MalFunction)
 	if !ok {
 		return nil, fmt.Errorf("first item in list isn't a function")
 	}
 
 	if !function.TailCallOptimised {
-		return function.Func(evaluatedList.Items[1:]...)
+		return function.Func(evaluatedList.Items()[1:]...)
 	}
 
 	// Function is tail call optimised.
 	// Construct the correct environment it should be run in
 	childEnv := environment.NewChildEnv(
-		function.Env.(*environment.Env), function.Params, evaluatedList.Items[1:],
+		function.Env.(*environment.Env), function.Params, evaluatedList.Items()[1:],
 	)
 
 	ast = function.AST
@@ -161,17 +161,15 @@ func evalAST(ast types.MalType, env *environment.Env) (types.MalType, error) {
 		}
 		return value, nil
 	case *types.MalList:
-		items := make([]types.MalType, len(tok.Items))
-		for i, item := range tok.Items {
+		items := make([]types.MalType, tok.Len())
+		for i, item := range tok.Items() {
 			evaluated, err := Eval(item, env)
 			if err != nil {
 				return nil, err
 			}
 			items[i] = evaluated
 		}
-		return &types.MalList{
-			Items: items,
-		}, nil
+		return types.NewList(items...), nil
 	}
 	return ast, nil
 }
@@ -181,7 +179,7 @@ func isTCOSpecialForm(ast types.MalType) (operator *types.MalSymbol, args []type
 	if !ok {
 		return nil, nil, false
 	}
-	items := tok.Items
+	items := tok.Items()
 	if len(items) == 0 {
 		return nil, nil, false
 	}
@@ -204,7 +202,7 @@ func isSpecialForm(ast types.MalType) (operator *types.MalSymbol, args []types.M
 	if !ok {
 		return nil, nil, false
 	}
-	items := tok.Items
+	items := tok.Items()
 	if len(items) == 0 {
 		return nil, nil, false
 	}
@@ -247,17 +245,17 @@ func evalTCOSpecialForm(
 		if !ok {
 			return nil, nil, fmt.Errorf("let*: first arg isn't a list")
 		}
-		if len(bindingList.Items)%2 != 0 {
+		if bindingList.Len()%2 != 0 {
 			return nil, nil, fmt.Errorf("let*: first arg doesn't have an even number of items")
 		}
 
 		childEnv := env.ChildEnv()
-		for i := 0; i < len(bindingList.Items); i += 2 {
-			key, ok := bindingList.Items[i].(*types.MalSymbol)
+		for i := 0; i < bindingList.Len(); i += 2 {
+			key, ok := bindingList.Items()[i].(*types.MalSymbol)
 			if !ok {
 				return nil, nil, fmt.Errorf("let*: binding list: arg %d isn't a symbol", i)
 			}
-			value, err := Eval(bindingList.Items[i+1], childEnv)
+			value, err := Eval(bindingList.Items()[i+1], childEnv)
 			if err != nil {
 				return nil, nil, err
 			}
@@ -354,8 +352,8 @@ func evalSpecialForm(
 			return nil, fmt.Errorf("fn* statements must have a list as the first arg")
 		}
 		// Cast it from a list of MalType to a list of MalSymbol
-		binds := make([]*types.MalSymbol, len(arguments.Items))
-		for i, a := range arguments.Items {
+		binds := make([]*types.MalSymbol, arguments.Len())
+		for i, a := range arguments.Items() {
 			bind, ok := a.(*types.MalSymbol)
 			if !ok {
 				// TODO: improve this - say which argument isn't a symbol
@@ -120,7 +120,7 @@ top:
 	if !ok {
 		return evalAST(ast, env)
 	}
-	if len(list.Items) == 0 {
+	if list.Len() == 0 {
 		return ast, nil
 	}
 
@@ -180,19 +180,19 @@ top:
 		return nil, fmt.Errorf("list did not evaluate to a list")
 	}
 
-	function, ok := evaluatedList.Items[0].(*types.MalFunction)
+	function, ok := evaluatedList.Items()[0].(*types.MalFunction)
 	if !ok {
 		return nil, fmt.Errorf("first item in list isn't a function")
 	}
 
 	if !function.TailCallOptimised {
-		return function.Func(evaluatedList.Items[1:]...)
+		return function.Func(evaluatedList.Items()[1:]...)
 	}
 
 	// Function is tail call optimised.
 	// Construct the correct environment it should be run in
 	childEnv := environment.NewChildEnv(
-		function.Env.(*environment.Env), function.Params, evaluatedList.Items[1:],
+		function.Env.(*environment.Env), function.Params, evaluatedList.Items()[1:],
 	)
 
 	ast = function.AST
@@ -231,17 +231,15 @@ func evalAST(ast types.MalType, env *environment.Env) (types.MalType, error) {
 		}
 		return value, nil
 	case *types.MalList:
-		items := make([]types.MalType, len(tok.Items))
-		for i, item := range tok.Items {
+		items := make([]types.MalType, tok.Len())
+		for i, item := range tok.Items() {
 			evaluated, err := Eval(item, env)
 			if err != nil {
 				return nil, err
 			}
 			items[i] = evaluated
 		}
-		return &types.MalList{
-			Items: items,
-		}, nil
+		return types.NewList(items...), nil
 	}
 	return ast, nil
 }
@@ -251,7 +249,7 @@ func isTCOSpecialForm(ast types.MalType) (operator *types.MalSymbol, args []type
 	if !ok {
 		return nil, nil, false
 	}
-	items := tok.Items
+	items := tok.Items()
 	if len(items) == 0 {
 		return nil, nil, false
 	}
@@ -274,7 +272,7 @@ func isSpecialForm(ast types.MalType) (operator *types.MalSymbol, args []types.M
 	if !ok {
 		return nil, nil, false
 	}
-	items := tok.Items
+	items := tok.Items()
 	if len(items) == 0 {
 		return nil, nil, false
 	}
@@ -317,17 +315,17 @@ func evalTCOSpecialForm(
 		if !ok {
 			return nil, nil, fmt.Errorf("let*: first arg isn't a list")
 		}
-		if len(bindingList.Items)%2 != 0 {
+		if bindingList.Len()%2 != 0 {
 			return nil, nil, fmt.Errorf("let*: first arg doesn't have an even number of items")
 		}
 
 		childEnv := env.ChildEnv()
-		for i := 0; i < len(bindingList.Items); i += 2 {
-			key, ok := bindingList.Items[i].(*types.MalSymbol)
+		for i := 0; i < bindingList.Len(); i += 2 {
+			key, ok := bindingList.Items()[i].(*types.MalSymbol)
 			if !ok {
 				return nil, nil, fmt.Errorf("let*: binding list: arg %d isn't a symbol", i)
 			}
-			value, err := Eval(bindingList.Items[i+1], childEnv)
+			value, err := Eval(bindingList.Items()[i+1], childEnv)
 			if err != nil {
 				return nil, nil, err
 			}
@@ -431,8 +429,8 @@ func evalSpecialForm(
 			return nil, fmt.Errorf("fn* statements must have a list as the first arg")
 		}
 		// Cast it from a list of MalType to a list of MalSymbol
-		binds := make([]*types.MalSymbol, len(arguments.Items))
-		for i, a := range arguments.Items {
+		binds := make([]*types.MalSymbol, arguments.Len())
+		for i, a := range arguments.Items() {
 			bind, ok := a.(*types.MalSymbol)
 			if !ok {
 				// TODO: improve this - say which argument isn't a symbol
@@ -521,16 +519,14 @@ func quasiquote(ast types.MalType) (types.MalType, error) {
 		// evaluate to themselves, so there's no difference caused by quoting
 		// them. However, there's also no harm harm in doing so.
 		// This return statements returns the AST version of (quote <ast>)
-		return &types.MalList{
-			Items: []types.MalType{
-				&types.MalSymbol{Value: "quote"},
-				ast,
-			},
-		}, nil
+		return types.NewList(
+			&types.MalSymbol{Value: "quote"},
+			ast,
+		), nil
 	}
 
 	// Okay - ast is a list
-	items := list.Items
+	items := list.Items()
 
 	// If the list has no items, return it unmodified
 	if len(items) == 0 {
@@ -540,7 +536,7 @@ func quasiquote(ast types.MalType) (types.MalType, error) {
 	// If the first item in the list is the function `unquote`, return the
 	// first argument without quoting it.
 	if symbol, ok := items[0].(*types.MalSymbol); ok && symbol.Value == "unquote" {
-		return list.Items[1], nil
+		return list.Items()[1], nil
 	}
 
 	// Okay - ast is a list, than hasn't been unquoted
@@ -551,13 +547,11 @@ func quasiquote(ast types.MalType) (types.MalType, error) {
 
 		// TODO: implement `splice-unquote` functionality
 		if args, ok := isSpliceUnquoteForm(element); ok {
-			quasiquoted = &types.MalList{
-				Items: []types.MalType{
-					&types.MalSymbol{Value: "concat"},
-					args[0],
-					quasiquoted,
-				},
-			}
+			quasiquoted = types.NewList(
+				&types.MalSymbol{Value: "concat"},
+				args[0],
+				quasiquoted,
+			)
 			continue
 		}
 
@@ -566,13 +560,11 @@ func quasiquote(ast types.MalType) (types.MalType, error) {
 			return nil, err
 		}
 
-		quasiquoted = &types.MalList{
-			Items: []types.MalType{
-				&types.MalSymbol{Value: "cons"},
-				quasiqutoedElement,
-				quasiquoted,
-			},
-		}
+		quasiquoted = types.NewList(
+			&types.MalSymbol{Value: "cons"},
+			quasiqutoedElement,
+			quasiquoted,
+		)
 
 	}
 	return quasiquoted, nil
@@ -583,7 +575,7 @@ func isSpliceUnquoteForm(ast types.MalType) (spliceUnquoteArgs []types.MalType,
 	if !ok {
 		return nil, false
 	}
-	items := list.Items
+	items := list.Items()
 	if len(items) == 0 {
 		return nil, false
 	}
@@ -602,7 +594,7 @@ func isMacroCall(ast types.MalType, env *environment.Env) bool {
 	if !ok {
 		return false
 	}
-	items := list.Items
+	items := list.Items()
 	if len(items) == 0 {
 		return false
 	}
@@ -634,7 +626,7 @@ func macroExpand(ast types.MalType, env *environment.Env) (types.MalType, error)
 		// happened
 		list := ast.(*types.MalList)
 		// Again, we've already checked this - skip ok checking
-		macroName := list.Items[0].(*types.MalSymbol)
+		macroName := list.Items()[0].(*types.MalSymbol)
 
 		macroNameValue, err := env.Get(macroName.Value)
 		if err != nil {
@@ -643,7 +635,7 @@ func macroExpand(ast types.MalType, env *environment.Env) (types.MalType, error)
 		}
 		macroFunc := macroNameValue.(*types.MalFunction)
 
-		newAst, err := macroFunc.Func(list.Items[1:]...)
+		newAst, err := macroFunc.Func(list.Items()[1:]...)
 		if err != nil {
 			return nil, err
 		}
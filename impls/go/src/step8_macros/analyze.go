@@ -0,0 +1,569 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jamesroutley/mal/impls/go/src/environment"
+	"github.com/jamesroutley/mal/impls/go/src/types"
+)
+
+// This file implements an analysis pass, which runs over the body of a fn*
+// when the function is created. It expands macros, and works out where each
+// local variable will live at runtime, so Eval can look them up by index
+// rather than searching each env's variables by name.
+//
+// Every fn* call, let* and catch* creates an env (a "frame"), whose variables
+// live in slots (see environment.Env). A local variable's address is the
+// number of frames between where it's used and where it's bound (its depth),
+// and its slot in that frame (its index). Anything which isn't bound locally
+// is a global, and is looked up by name as usual.
+//
+// The analysed body is still mostly made of lists, but contains four new
+// kinds of node:
+//
+// - localSymbol replaces symbols which refer to local variables
+// - letBindings replaces the binding list of a let*
+// - lambda replaces nested fn* forms, which are analysed along with the
+//   function that contains them
+// - recurForm replaces recur forms (see loop.go)
+
+// localSymbol is a reference to a local variable, found at Index in the frame
+// Depth frames above the one it's evaluated in.
+type localSymbol struct {
+	Name  string
+	Depth int
+	Index int
+	Pos   *types.Position
+}
+
+func (s *localSymbol) String() string {
+	return s.Name
+}
+
+// letBindings is an analysed let* binding list. The let* creates a frame
+// whose slots are named by Names, and evaluates each of Values in turn,
+// storing the result in slot Indices[i].
+type letBindings struct {
+	Names   []string
+	Indices []int
+	Values  []types.MalType
+}
+
+func (b *letBindings) String() string {
+	itemStrings := make([]string, 0, 2*len(b.Values))
+	for i, value := range b.Values {
+		itemStrings = append(itemStrings, b.Names[b.Indices[i]], value.String())
+	}
+	return fmt.Sprintf("(%s)", strings.Join(itemStrings, " "))
+}
+
+// lambda is an analysed fn*. Evaluating it creates a function.
+type lambda struct {
+	// Arities holds the fn*'s parameter lists, and the body each one runs.
+	// Most functions have one. A multi-arity fn*, e.g.
+	// (fn* ((a) a) ((a b) (+ a b))), has one for each number of args it
+	// takes.
+	Arities []*arity
+	// Form is the fn* form the lambda was analysed from
+	Form *types.MalList
+}
+
+func (l *lambda) String() string {
+	return l.Form.String()
+}
+
+// Arglists returns a list of the fn*'s parameter lists, as they were written
+func (l *lambda) Arglists() *types.MalList {
+	arglists := make([]types.MalType, len(l.Arities))
+	for i, a := range l.Arities {
+		arglists[i] = a.Arglist
+	}
+	return types.NewList(arglists...)
+}
+
+// selectArity returns the index of the arity a call with numArgs args runs.
+// An arity which takes exactly numArgs args is preferred over a variadic
+// one.
+func (l *lambda) selectArity(numArgs int) (int, bool) {
+	variadic := -1
+	for i, a := range l.Arities {
+		switch {
+		case !a.Variadic && len(a.Names) == numArgs:
+			return i, true
+		case a.Variadic && numArgs >= len(a.Names)-1:
+			variadic = i
+		}
+	}
+	return variadic, variadic >= 0
+}
+
+// arity is one of a fn*'s parameter lists, and the body it runs
+type arity struct {
+	// Names are the names of the parameters, which are the names of the
+	// first slots of the frame the body runs in. If the arity is variadic,
+	// the last one holds a list of any remaining args.
+	Names    []string
+	Variadic bool
+	Body     types.MalType
+	// Arglist is the parameter list as it was written, before patterns were
+	// replaced with symbols
+	Arglist types.MalType
+}
+
+// scope tracks the local variables which will be in a frame at runtime
+type scope struct {
+	names []string
+	outer *scope
+	// function is true if the scope is a fn*'s parameters
+	function bool
+	// loop is the bindings of the loop* whose variables the scope holds, if
+	// it's a loop*'s scope
+	loop *letBindings
+}
+
+// declare adds a variable to the scope, and returns its slot index.
+// Redeclaring a variable reuses its slot.
+func (s *scope) declare(name string) int {
+	for i, n := range s.names {
+		if n == name {
+			return i
+		}
+	}
+	s.names = append(s.names, name)
+	return len(s.names) - 1
+}
+
+// resolve finds the address of a local variable. ok is false if the variable
+// isn't local.
+func (s *scope) resolve(name string) (depth int, index int, ok bool) {
+	for ; s != nil; s = s.outer {
+		for i, n := range s.names {
+			if n == name {
+				return depth, i, true
+			}
+		}
+		depth++
+	}
+	return 0, 0, false
+}
+
+// recurTarget finds the loop* a recur refers to, which is the innermost one
+// it's inside, in the same function. depth is the number of frames between
+// them.
+func (s *scope) recurTarget() (bindings *letBindings, depth int, ok bool) {
+	for ; s != nil; s = s.outer {
+		if s.loop != nil {
+			return s.loop, depth, true
+		}
+		if s.function {
+			break
+		}
+		depth++
+	}
+	return nil, 0, false
+}
+
+// analyzeLambda analyses a fn* form, whose args are either a parameter list
+// and a body, or, for a multi-arity function, lists of a parameter list
+// followed by a body. sc is the scope the fn* appears in, or nil at the top
+// level, and env is the env it will be evaluated in, which is used to expand
+// macros.
+func analyzeLambda(args []types.MalType, sc *scope, env *environment.Env) (*lambda, error) {
+	form := types.NewList(args...).Cons(&types.MalSymbol{Value: "fn*"})
+	l := &lambda{
+		Form: form,
+	}
+
+	if !isMultiArity(args) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("fn* statements must have two arguments, got %d", len(args))
+		}
+		a, err := analyzeArity(args[0], args[1], sc, env)
+		if err != nil {
+			return nil, err
+		}
+		l.Arities = []*arity{a}
+		return l, nil
+	}
+
+	variadic := -1
+	for _, clause := range args {
+		// isMultiArity has checked each clause is a list, starting with a
+		// parameter list
+		items := clause.(*types.MalList).Items()
+		var body types.MalType = &types.MalNil{}
+		switch {
+		case len(items) == 2:
+			body = items[1]
+		case len(items) > 2:
+			body = types.NewList(items[1:]...).Cons(&types.MalSymbol{Value: "do"})
+		}
+		a, err := analyzeArity(items[0], body, sc, env)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range l.Arities {
+			if !a.Variadic && !other.Variadic && len(a.Names) == len(other.Names) {
+				return nil, fmt.Errorf("fn*: more than one parameter list takes %d args", len(a.Names))
+			}
+		}
+		if a.Variadic {
+			if variadic >= 0 {
+				return nil, fmt.Errorf("fn*: only one parameter list can have a & parameter")
+			}
+			variadic = len(l.Arities)
+		}
+		l.Arities = append(l.Arities, a)
+	}
+	// A call is run by the variadic arity if no other takes exactly as many
+	// args, so the others can't take more args than it needs
+	if variadic >= 0 {
+		for _, a := range l.Arities {
+			if !a.Variadic && len(a.Names) > len(l.Arities[variadic].Names)-1 {
+				return nil, fmt.Errorf("fn*: a parameter list can't take more args than the one with a & parameter")
+			}
+		}
+	}
+	return l, nil
+}
+
+// isMultiArity reports whether the args of a fn* define a multi-arity
+// function: each must be a list whose first item is a parameter list.
+//
+// Two args can also be a parameter list which starts with a pattern, and a
+// body which is a call, like (fn* ((a b)) ((id +) a b)). The arities of a
+// multi-arity function always take different numbers of args, so if two
+// would take the same number, they're a parameter list and a body.
+func isMultiArity(args []types.MalType) bool {
+	if len(args) == 0 {
+		return false
+	}
+	var params []*types.MalList
+	for _, arg := range args {
+		clause, ok := arg.(*types.MalList)
+		if !ok || clause.Len() == 0 {
+			return false
+		}
+		p, ok := clause.Items()[0].(*types.MalList)
+		if !ok {
+			return false
+		}
+		params = append(params, p)
+	}
+	if len(params) == 2 {
+		aFixed, aVariadic := paramCount(params[0])
+		bFixed, bVariadic := paramCount(params[1])
+		return aFixed != bFixed || aVariadic != bVariadic
+	}
+	return true
+}
+
+// paramCount returns the number of params before any &, and whether there
+// is a &
+func paramCount(params *types.MalList) (fixed int, variadic bool) {
+	for i, param := range params.Items() {
+		if symbol, ok := param.(*types.MalSymbol); ok && symbol.Value == "&" {
+			return i, true
+		}
+	}
+	return params.Len(), false
+}
+
+// analyzeArity analyses one of a fn*'s parameter lists, and its body
+func analyzeArity(
+	arguments types.MalType, body types.MalType, sc *scope, env *environment.Env,
+) (*arity, error) {
+	// arguments is the parameter list (e.g. `(a)` in `(fn* (a) (+ a 1))`)
+	argumentList, ok := arguments.(*types.MalList)
+	if !ok {
+		return nil, fmt.Errorf("fn* statements must have a list as the first arg")
+	}
+	// Parameters which are patterns are replaced by symbols, and destructured
+	// by a let* around the body
+	params, destructure, err := expandParams(argumentList.Items())
+	if err != nil {
+		return nil, err
+	}
+	if len(destructure) > 0 {
+		body = types.NewList(
+			&types.MalSymbol{Value: "let*"}, types.NewList(destructure...), body,
+		)
+	}
+
+	a := &arity{
+		Arglist: arguments,
+	}
+	fnScope := &scope{outer: sc, function: true}
+	for i, param := range params {
+		bind, ok := param.(*types.MalSymbol)
+		if !ok {
+			return nil, fmt.Errorf("fn* statements must have a list of symbols as the first arg, got %s", param)
+		}
+		// & is followed by the parameter which holds any remaining args
+		if bind.Value == "&" {
+			if i != len(params)-2 {
+				return nil, fmt.Errorf("fn*: & must be followed by exactly one parameter")
+			}
+			a.Variadic = true
+			continue
+		}
+		// Parameters always get their own slot, in order, even if they're
+		// repeated, because newCallFrame binds them that way
+		fnScope.names = append(fnScope.names, bind.Value)
+	}
+	a.Names = fnScope.names
+
+	a.Body, err = analyze(body, fnScope, env)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// analyze analyses a form which will be evaluated in scope sc
+func analyze(ast types.MalType, sc *scope, env *environment.Env) (types.MalType, error) {
+	switch tok := ast.(type) {
+	case *types.MalSymbol:
+		if depth, index, ok := sc.resolve(tok.Value); ok {
+			return &localSymbol{
+				Name:  tok.Value,
+				Depth: depth,
+				Index: index,
+				Pos:   tok.Pos,
+			}, nil
+		}
+		return ast, nil
+	case *types.MalList:
+		return analyzeList(tok, sc, env)
+	case *types.MalVector:
+		return analyzeVector(tok, sc, env)
+	case *types.MalSet:
+		return analyzeSet(tok, sc, env)
+	case *types.MalHashMap:
+		return analyzeHashMap(tok, sc, env)
+	}
+	return ast, nil
+}
+
+// vectorFunction builds a vector from its arguments
+var vectorFunction = &types.MalFunction{
+	Name: "vector",
+	Func: func(args ...types.MalType) (types.MalType, error) {
+		return types.NewVector(args...), nil
+	},
+}
+
+// analyzeVector turns a vector literal into a call to vectorFunction, which
+// evaluates its items when it's run
+func analyzeVector(v *types.MalVector, sc *scope, env *environment.Env) (types.MalType, error) {
+	items := make([]types.MalType, 0, v.Len()+1)
+	items = append(items, vectorFunction)
+	for _, item := range v.Items() {
+		analysed, err := analyze(item, sc, env)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, analysed)
+	}
+	return types.NewList(items...), nil
+}
+
+// setFunction builds a set from its arguments
+var setFunction = &types.MalFunction{
+	Name: "set",
+	Func: func(args ...types.MalType) (types.MalType, error) {
+		return types.NewSet(args...)
+	},
+}
+
+// analyzeSet turns a set literal into a call to setFunction, which evaluates
+// its items when it's run
+func analyzeSet(s *types.MalSet, sc *scope, env *environment.Env) (types.MalType, error) {
+	items := make([]types.MalType, 0, s.Len()+1)
+	items = append(items, setFunction)
+	for _, item := range s.Items() {
+		analysed, err := analyze(item, sc, env)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, analysed)
+	}
+	return types.NewList(items...), nil
+}
+
+// hashMapFunction builds a hash map from alternating keys and values
+var hashMapFunction = &types.MalFunction{
+	Name: "hash-map",
+	Func: func(args ...types.MalType) (types.MalType, error) {
+		return types.NewHashMap(args...)
+	},
+}
+
+// analyzeHashMap turns a hash map literal into a call to hashMapFunction,
+// which evaluates its keys and values when it's run
+func analyzeHashMap(m *types.MalHashMap, sc *scope, env *environment.Env) (types.MalType, error) {
+	items := make([]types.MalType, 0, 2*m.Len()+1)
+	items = append(items, hashMapFunction)
+	for i, key := range m.Keys() {
+		key, err := analyze(key, sc, env)
+		if err != nil {
+			return nil, err
+		}
+		value, err := analyze(m.Values()[i], sc, env)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, key, value)
+	}
+	return types.NewList(items...), nil
+}
+
+func analyzeList(list *types.MalList, sc *scope, env *environment.Env) (types.MalType, error) {
+	if list.Len() == 0 {
+		return list, nil
+	}
+
+	operator, ok := list.Items()[0].(*types.MalSymbol)
+	if !ok {
+		return analyzeItems(list, list.Items(), sc, env)
+	}
+
+	// Expand macros first, just like Eval does. A local variable with the
+	// same name as a macro shadows it.
+	if _, _, local := sc.resolve(operator.Value); !local && isMacroCall(list, env) {
+		expanded, err := macroExpand(list, env)
+		if err != nil {
+			return nil, err
+		}
+		return analyze(expanded, sc, env)
+	}
+
+	args := list.Items()[1:]
+	switch operator.Value {
+	// These forms don't evaluate their arguments, so there's nothing to
+	// analyse
+	case "quote", "quasiquoteexpand", "macroexpand", "macroexpand-1", "macroexpand-all":
+		return list, nil
+
+	case "quasiquote":
+		if len(args) != 1 {
+			return list, nil
+		}
+		expanded, err := quasiquote(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return analyze(expanded, sc, env)
+
+	// Only the value is evaluated. The symbol is defined by name at runtime.
+	// A docstring is added to the value by a call to docFunction, so the
+	// compilers only have to handle the two arg form.
+	case "def!", "defmacro!":
+		args, doc, err := splitDocstring(operator.Value, args)
+		if err != nil {
+			return nil, err
+		}
+		value, err := analyze(args[1], sc, env)
+		if err != nil {
+			return nil, err
+		}
+		if doc != nil {
+			value = types.NewList(docFunction, value, doc)
+		}
+		return withItems(list, list.Items()[0], args[0], value), nil
+
+	case "let*":
+		return analyzeLet(list, sc, env)
+
+	case "loop*":
+		return analyzeLoop(list, sc, env)
+
+	case "recur":
+		return analyzeRecur(list, sc, env)
+
+	case "fn*":
+		return analyzeLambda(args, sc, env)
+
+	// catch* binds the exception in a new frame
+	case "try*":
+		if len(args) != 2 {
+			return analyzeItems(list, list.Items(), sc, env)
+		}
+		body, err := analyze(args[0], sc, env)
+		if err != nil {
+			return nil, err
+		}
+		catch, ok := args[1].(*types.MalList)
+		if !ok || catch.Len() != 3 {
+			return list, nil
+		}
+		key, ok := catch.Items()[1].(*types.MalSymbol)
+		if !ok {
+			return list, nil
+		}
+		handler, err := analyze(catch.Items()[2], &scope{names: []string{key.Value}, outer: sc}, env)
+		if err != nil {
+			return nil, err
+		}
+		return withItems(list, list.Items()[0], body, withItems(catch, catch.Items()[0], key, handler)), nil
+	}
+
+	return analyzeItems(list, list.Items(), sc, env)
+}
+
+// analyzeLet analyses (let* (k1 v1 k2 v2 ...) body). Each value can refer to
+// the keys bound before it, and the body can refer to all of them.
+func analyzeLet(list *types.MalList, sc *scope, env *environment.Env) (types.MalType, error) {
+	args := list.Items()[1:]
+	if len(args) != 2 {
+		return nil, fmt.Errorf("let* takes 2 args")
+	}
+	bindingList, ok := args[0].(*types.MalList)
+	if !ok {
+		return nil, fmt.Errorf("let*: first arg isn't a list")
+	}
+	items, err := expandBindings(bindingList.Items())
+	if err != nil {
+		return nil, err
+	}
+
+	letScope := &scope{outer: sc}
+	bindings := &letBindings{}
+	for i := 0; i < len(items); i += 2 {
+		// expandBindings has checked this is a symbol
+		key := items[i].(*types.MalSymbol)
+		value, err := analyze(items[i+1], letScope, env)
+		if err != nil {
+			return nil, err
+		}
+		bindings.Values = append(bindings.Values, value)
+		bindings.Indices = append(bindings.Indices, letScope.declare(key.Value))
+	}
+	bindings.Names = letScope.names
+
+	body, err := analyze(args[1], letScope, env)
+	if err != nil {
+		return nil, err
+	}
+	return withItems(list, list.Items()[0], bindings, body), nil
+}
+
+// analyzeItems analyses each item of a list, returning a new list
+func analyzeItems(list *types.MalList, items []types.MalType, sc *scope, env *environment.Env) (types.MalType, error) {
+	analysed := make([]types.MalType, len(items))
+	for i, item := range items {
+		a, err := analyze(item, sc, env)
+		if err != nil {
+			return nil, err
+		}
+		analysed[i] = a
+	}
+	return withItems(list, analysed...), nil
+}
+
+// withItems returns a copy of list, containing items instead of its original
+// items. The copy keeps list's source position.
+func withItems(list *types.MalList, items ...types.MalType) *types.MalList {
+	return types.NewListAt(list.Pos, items...)
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jamesroutley/mal/impls/go/src/types"
+)
+
+// This file compiles analysed function bodies (see analyze.go) to bytecode,
+// which is run by the VM in vm.go. It's an alternative to compiling them to
+// closures, selected by setting *engine* to "vm".
+//
+// Each instruction is a one byte opcode, followed by its operands, which are
+// each two bytes, big endian. Operands are either indexes into the chunk's
+// constants, slot indexes, argument counts, or jump targets (which are
+// offsets from the start of the chunk).
+
+type opcode byte
+
+const (
+	// OpConst pushes constants[k]
+	OpConst opcode = iota
+	// OpGlobal pushes the value of the symbol constants[k], looked up by name
+	OpGlobal
+	// OpLocal pushes the value of the local variable constants[k], which is
+	// a *localSymbol
+	OpLocal
+	// OpClosure pushes a new function, created from the *vmLambda
+	// constants[k] and the current env
+	OpClosure
+	// OpDef sets the symbol constants[k] to the value on top of the stack,
+	// leaving it there
+	OpDef
+	// OpDefMacro is like OpDef, but marks the value as a macro
+	OpDefMacro
+	// OpPushFrame creates a child env with slots named by the *letBindings
+	// constants[k], and makes it the current env
+	OpPushFrame
+	// OpSetSlot pops a value and stores it in slot i of the current env
+	OpSetSlot
+	// OpPopFrame makes the current env's parent the current env
+	OpPopFrame
+	// OpJump jumps to target
+	OpJump
+	// OpJumpIfFalse pops a value, and jumps to target if it isn't truthy
+	OpJumpIfFalse
+	// OpPop discards the value on top of the stack
+	OpPop
+	// OpMacroCheck looks at the operator on top of the stack. If it's a
+	// macro (because it was defined after the function was compiled), the
+	// call, the list constants[k], fails (see lateMacroError).
+	OpMacroCheck
+	// OpCall pops n arguments and a function, calls the function, and pushes
+	// the result. constants[k] is the list which made the call.
+	OpCall
+	// OpTailCall is like OpCall, but replaces the current call with the new
+	// one, rather than returning to it
+	OpTailCall
+	// OpReturn pops a value and returns it from the current call
+	OpReturn
+	// OpTry starts a try* block. If an error is raised before the matching
+	// OpEndTry, the stack is reset, the exception is bound to the symbol
+	// constants[k] in a new child env, and execution jumps to target.
+	OpTry
+	// OpEndTry ends a try* block
+	OpEndTry
+	// OpMacroexpand pushes the value of constants[k], which is a
+	// (macroexpand form), (macroexpand-1 form) or (macroexpand-all form)
+	// special form
+	OpMacroexpand
+	// OpRecur pops the args of the *recurForm constants[k], rebinds the
+	// variables of its loop* to them in a new env, and jumps to target, the
+	// start of the loop*'s body
+	OpRecur
+	// OpSymbols pops the args of the (dir) or (apropos s) special form
+	// constants[k], and pushes the symbols it lists
+	OpSymbols
+)
+
+// opcodeInfo describes each opcode
+var opcodeInfo = [...]struct {
+	Name     string
+	Operands int
+}{
+	OpConst:       {"CONST", 1},
+	OpGlobal:      {"GLOBAL", 1},
+	OpLocal:       {"LOCAL", 1},
+	OpClosure:     {"CLOSURE", 1},
+	OpDef:         {"DEF", 1},
+	OpDefMacro:    {"DEFMACRO", 1},
+	OpPushFrame:   {"PUSH_FRAME", 1},
+	OpSetSlot:     {"SET_SLOT", 1},
+	OpPopFrame:    {"POP_FRAME", 0},
+	OpJump:        {"JUMP", 1},
+	OpJumpIfFalse: {"JUMP_IF_FALSE", 1},
+	OpPop:         {"POP", 0},
+	OpMacroCheck:  {"MACRO_CHECK", 1},
+	OpCall:        {"CALL", 2},
+	OpTailCall:    {"TAIL_CALL", 2},
+	OpReturn:      {"RETURN", 0},
+	OpTry:         {"TRY", 2},
+	OpEndTry:      {"END_TRY", 0},
+	OpMacroexpand: {"MACROEXPAND", 1},
+	OpRecur:       {"RECUR", 2},
+	OpSymbols:     {"SYMBOLS", 1},
+}
+
+// maxOperand is the largest value an operand can hold
+const maxOperand = 1<<16 - 1
+
+// chunk is a compiled function body
+type chunk struct {
+	Code      []byte
+	Constants []types.MalType
+	// Form is the body the chunk was compiled from
+	Form types.MalType
+}
+
+// vmBody is the body of a function compiled to bytecode. It's stored as the
+// function's AST.
+type vmBody struct {
+	// Chunks holds the chunk of each of the function's arities (see lambda)
+	Chunks []*chunk
+	// Lambda is the fn* the body belongs to
+	Lambda *lambda
+}
+
+func (b *vmBody) String() string {
+	return b.Lambda.String()
+}
+
+// vmLambda is an analysed fn*, whose body has been compiled to bytecode. It's
+// stored in the constants of the chunk which creates the function.
+type vmLambda struct {
+	Lambda *lambda
+	Chunks []*chunk
+}
+
+func (l *vmLambda) String() string {
+	return l.Lambda.String()
+}
+
+// bytecodeCompiler builds a single chunk
+type bytecodeCompiler struct {
+	chunk *chunk
+	err   error
+	// loopStart is the offset of the start of the body of the innermost
+	// loop* being compiled, which recur jumps to
+	loopStart int
+}
+
+// compileChunks compiles the body of each arity of an analysed fn* to
+// bytecode
+func compileChunks(l *lambda) ([]*chunk, error) {
+	chunks := make([]*chunk, len(l.Arities))
+	for i, a := range l.Arities {
+		c := &bytecodeCompiler{
+			chunk: &chunk{Form: a.Body},
+		}
+		c.emitForm(a.Body, true)
+		if c.err != nil {
+			return nil, c.err
+		}
+		chunks[i] = c.chunk
+	}
+	return chunks, nil
+}
+
+// emit appends an instruction, and returns its offset
+func (c *bytecodeCompiler) emit(op opcode, operands ...int) int {
+	offset := len(c.chunk.Code)
+	c.chunk.Code = append(c.chunk.Code, byte(op))
+	for _, operand := range operands {
+		if operand > maxOperand && c.err == nil {
+			c.err = fmt.Errorf("function is too big to compile to bytecode")
+		}
+		c.chunk.Code = append(c.chunk.Code, byte(operand>>8), byte(operand))
+	}
+	return offset
+}
+
+// constant adds a value to the chunk's constants, and returns its index
+func (c *bytecodeCompiler) constant(value types.MalType) int {
+	c.chunk.Constants = append(c.chunk.Constants, value)
+	return len(c.chunk.Constants) - 1
+}
+
+// patch sets the operand'th operand of the instruction at offset to the
+// offset of the next instruction to be emitted
+func (c *bytecodeCompiler) patch(offset int, operand int) {
+	target := len(c.chunk.Code)
+	if target > maxOperand && c.err == nil {
+		c.err = fmt.Errorf("function is too big to compile to bytecode")
+	}
+	i := offset + 1 + 2*operand
+	c.chunk.Code[i] = byte(target >> 8)
+	c.chunk.Code[i+1] = byte(target)
+}
+
+func (c *bytecodeCompiler) fail(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// emitForm compiles an analysed form. If tail is true, the code returns from
+// the function (or makes a tail call) with the form's value. Otherwise, it
+// leaves the value on the stack.
+func (c *bytecodeCompiler) emitForm(ast types.MalType, tail bool) {
+	switch tok := ast.(type) {
+	case *types.MalSymbol:
+		c.emit(OpGlobal, c.constant(tok))
+	case *localSymbol:
+		c.emit(OpLocal, c.constant(tok))
+	case *lambda:
+		nested, err := compileChunks(tok)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		c.emit(OpClosure, c.constant(&vmLambda{Lambda: tok, Chunks: nested}))
+	case *recurForm:
+		// recur is always in tail position of the loop* body, so there's
+		// nothing to do afterwards, even if the loop* isn't in tail position
+		for _, arg := range tok.Args {
+			c.emitForm(arg, false)
+		}
+		c.emit(OpRecur, c.constant(tok), c.loopStart)
+		return
+	case *types.MalList:
+		c.emitList(tok, tail)
+		return
+	default:
+		c.emit(OpConst, c.constant(ast))
+	}
+	if tail {
+		c.emit(OpReturn)
+	}
+}
+
+func (c *bytecodeCompiler) emitList(list *types.MalList, tail bool) {
+	if list.Len() == 0 {
+		c.emitConst(list, tail)
+		return
+	}
+
+	operator, ok := list.Items()[0].(*types.MalSymbol)
+	if !ok {
+		c.emitCall(list, tail)
+		return
+	}
+	args := list.Items()[1:]
+
+	switch operator.Value {
+	case "quote":
+		if len(args) != 1 {
+			c.fail(fmt.Errorf("quote takes 1 arg, got %d", len(args)))
+			return
+		}
+		c.emitConst(args[0], tail)
+
+	case "quasiquoteexpand":
+		if len(args) != 1 {
+			c.fail(fmt.Errorf("quasiquoteexpand takes 1 arg, got %d", len(args)))
+			return
+		}
+		expanded, err := quasiquote(args[0])
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		c.emitConst(expanded, tail)
+
+	case "macroexpand", "macroexpand-1", "macroexpand-all":
+		if len(args) != 1 {
+			c.fail(fmt.Errorf("%s takes 1 arg, got %d", operator.Value, len(args)))
+			return
+		}
+		c.emit(OpMacroexpand, c.constant(list))
+		c.emitReturn(tail)
+
+	case "dir", "apropos":
+		for _, arg := range args {
+			c.emitForm(arg, false)
+		}
+		c.emit(OpSymbols, c.constant(list))
+		c.emitReturn(tail)
+
+	case "def!", "defmacro!":
+		if len(args) != 2 {
+			c.fail(fmt.Errorf("%s takes 2 args", operator.Value))
+			return
+		}
+		key, ok := args[0].(*types.MalSymbol)
+		if !ok {
+			c.fail(fmt.Errorf("%s: first arg isn't a symbol", operator.Value))
+			return
+		}
+		c.emitForm(args[1], false)
+		if operator.Value == "def!" {
+			c.emit(OpDef, c.constant(key))
+		} else {
+			c.emit(OpDefMacro, c.constant(key))
+		}
+		c.emitReturn(tail)
+
+	case "let*":
+		// analyzeLet has already checked the let* is well formed
+		bindings := args[0].(*letBindings)
+		c.emit(OpPushFrame, c.constant(bindings))
+		for i, value := range bindings.Values {
+			c.emitForm(value, false)
+			c.emit(OpSetSlot, bindings.Indices[i])
+		}
+		c.emitForm(args[1], tail)
+		// Returning discards the env, so we only need to pop it if we carry
+		// on afterwards
+		if !tail {
+			c.emit(OpPopFrame)
+		}
+
+	case "loop*":
+		// analyzeLoop has already checked the loop* is well formed
+		bindings := args[0].(*letBindings)
+		c.emit(OpPushFrame, c.constant(bindings))
+		for i, value := range bindings.Values {
+			c.emitForm(value, false)
+			c.emit(OpSetSlot, bindings.Indices[i])
+		}
+		outerLoop := c.loopStart
+		c.loopStart = len(c.chunk.Code)
+		c.emitForm(args[1], tail)
+		c.loopStart = outerLoop
+		// recur replaces the loop*'s env, but its parent is the same, so it
+		// can be popped as usual
+		if !tail {
+			c.emit(OpPopFrame)
+		}
+
+	case "if":
+		if numArgs := len(args); numArgs != 2 && numArgs != 3 {
+			c.fail(fmt.Errorf("if statements must have two or three arguments, got %d", numArgs))
+			return
+		}
+		c.emitForm(args[0], false)
+		jumpToElse := c.emit(OpJumpIfFalse, 0)
+		c.emitForm(args[1], tail)
+		jumpToEnd := -1
+		if !tail {
+			jumpToEnd = c.emit(OpJump, 0)
+		}
+		c.patch(jumpToElse, 0)
+		if len(args) == 3 {
+			c.emitForm(args[2], tail)
+		} else {
+			c.emitConst(&types.MalNil{}, tail)
+		}
+		if jumpToEnd >= 0 {
+			c.patch(jumpToEnd, 0)
+		}
+
+	case "do":
+		if len(args) == 0 {
+			c.emitConst(&types.MalNil{}, tail)
+			return
+		}
+		for _, arg := range args[:len(args)-1] {
+			c.emitForm(arg, false)
+			c.emit(OpPop)
+		}
+		c.emitForm(args[len(args)-1], tail)
+
+	case "try*":
+		c.emitTry(args, tail)
+
+	default:
+		c.emitCall(list, tail)
+	}
+}
+
+func (c *bytecodeCompiler) emitTry(args []types.MalType, tail bool) {
+	if numArgs := len(args); numArgs != 1 && numArgs != 2 {
+		c.fail(fmt.Errorf("try* takes one or two args, got %d", numArgs))
+		return
+	}
+	if len(args) == 1 {
+		c.emitForm(args[0], tail)
+		return
+	}
+
+	catch, ok := args[1].(*types.MalList)
+	if !ok || catch.Len() != 3 {
+		c.fail(fmt.Errorf("try*: second arg must be a (catch* sym handler) form"))
+		return
+	}
+	if operator, ok := catch.Items()[0].(*types.MalSymbol); !ok || operator.Value != "catch*" {
+		c.fail(fmt.Errorf("try*: second arg must be a (catch* sym handler) form"))
+		return
+	}
+	key, ok := catch.Items()[1].(*types.MalSymbol)
+	if !ok {
+		c.fail(fmt.Errorf("catch*: first arg isn't a symbol"))
+		return
+	}
+
+	try := c.emit(OpTry, 0, c.constant(key))
+	// The body can't be in tail position, otherwise its errors would be
+	// raised after we'd stopped listening for them
+	c.emitForm(args[0], false)
+	c.emit(OpEndTry)
+	jumpToEnd := -1
+	if tail {
+		c.emit(OpReturn)
+	} else {
+		jumpToEnd = c.emit(OpJump, 0)
+	}
+
+	// The VM binds the exception in a new env before jumping here
+	c.patch(try, 0)
+	c.emitForm(catch.Items()[2], tail)
+	if !tail {
+		c.emit(OpPopFrame)
+		c.patch(jumpToEnd, 0)
+	}
+}
+
+// emitCall compiles a function call. The operator is evaluated first, so it
+// can be checked for macros before the arguments are evaluated.
+func (c *bytecodeCompiler) emitCall(list *types.MalList, tail bool) {
+	form := c.constant(list)
+	c.emitForm(list.Items()[0], false)
+	c.emit(OpMacroCheck, form)
+	for _, arg := range list.Items()[1:] {
+		c.emitForm(arg, false)
+	}
+	if tail {
+		c.emit(OpTailCall, list.Len()-1, form)
+	} else {
+		c.emit(OpCall, list.Len()-1, form)
+	}
+}
+
+func (c *bytecodeCompiler) emitConst(value types.MalType, tail bool) {
+	c.emit(OpConst, c.constant(value))
+	c.emitReturn(tail)
+}
+
+func (c *bytecodeCompiler) emitReturn(tail bool) {
+	if tail {
+		c.emit(OpReturn)
+	}
+}
+
+// readOperand decodes the operand starting at code[i]
+func readOperand(code []byte, i int) int {
+	return int(code[i])<<8 | int(code[i+1])
+}
+
+// disassemble returns a human readable listing of a chunk
+func disassemble(c *chunk) string {
+	var b strings.Builder
+	for ip := 0; ip < len(c.Code); {
+		op := opcode(c.Code[ip])
+		if int(op) >= len(opcodeInfo) {
+			fmt.Fprintf(&b, "%04d unknown opcode %d\n", ip, op)
+			ip++
+			continue
+		}
+		info := opcodeInfo[op]
+
+		operands := make([]int, info.Operands)
+		operandStrings := make([]string, info.Operands)
+		for i := range operands {
+			operands[i] = readOperand(c.Code, ip+1+2*i)
+			operandStrings[i] = fmt.Sprint(operands[i])
+		}
+		line := fmt.Sprintf("%04d %-14s %s", ip, info.Name, strings.Join(operandStrings, " "))
+		b.WriteString(strings.TrimRight(line, " "))
+
+		// Show the value of constant operands
+		switch op {
+		case OpConst, OpGlobal, OpLocal, OpClosure, OpDef, OpDefMacro, OpPushFrame, OpMacroexpand, OpMacroCheck, OpRecur, OpSymbols:
+			fmt.Fprintf(&b, "\t; %s", c.Constants[operands[0]])
+		case OpCall, OpTailCall:
+			fmt.Fprintf(&b, "\t; %s", c.Constants[operands[1]])
+		case OpTry:
+			fmt.Fprintf(&b, "\t; catch* %s", c.Constants[operands[1]])
+		}
+		b.WriteString("\n")
+
+		ip += 1 + 2*info.Operands
+	}
+	return b.String()
+}
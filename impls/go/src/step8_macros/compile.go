@@ -0,0 +1,612 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jamesroutley/mal/impls/go/src/environment"
+	"github.com/jamesroutley/mal/impls/go/src/types"
+)
+
+// This file compiles analysed function bodies (see analyze.go) into trees of
+// Go closures. Eval has to work out what kind of form it's looking at every
+// time it evaluates it; compiling a form does that work once, when the
+// function is created, and leaves a closure which just does the evaluation.
+//
+// Tail call optimisation works like it does in Eval. A call in tail position
+// doesn't call the function. Instead, the closure returns a tailCall, and
+// whoever is running the function body (apply, or Eval) loops round and runs
+// the called function's body in its place.
+
+// code is a compiled form. Running it evaluates the form in env. If the form
+// ends in a tail call, code returns the call to make rather than making it.
+type code func(env *environment.Env) (types.MalType, *tailCall, error)
+
+// tailCall is a call to a Lisp function, which hasn't been made yet
+type tailCall struct {
+	Function *types.MalFunction
+	Args     []types.MalType
+	// Form is the list which made the call
+	Form *types.MalList
+	// Loop is set instead of Function by recur. It's the frame to run the
+	// body of the loop* in next (see loop.go). The loop* always handles it,
+	// so it never reaches apply.
+	Loop *environment.Env
+}
+
+// compiledBody is the compiled body of a Lisp function. It's stored as the
+// function's AST.
+type compiledBody struct {
+	// Arities holds the code of the body of each of the function's arities
+	// (see lambda)
+	Arities []code
+	// Lambda is the fn* the body was compiled from
+	Lambda *lambda
+}
+
+func (b *compiledBody) String() string {
+	return b.Lambda.String()
+}
+
+// compileLambda compiles the body of an analysed fn*, and any fn* forms
+// nested inside it
+func compileLambda(l *lambda) (*compiledBody, error) {
+	body := &compiledBody{
+		Arities: make([]code, len(l.Arities)),
+		Lambda:  l,
+	}
+	for i, a := range l.Arities {
+		c, err := compile(a.Body, true)
+		if err != nil {
+			return nil, err
+		}
+		body.Arities[i] = c
+	}
+	return body, nil
+}
+
+// apply calls a function with args, running tail calls made by compiled
+// function bodies until there are none left. The calls stop once ctx, the
+// caller's context, is cancelled. form is the list which made the call, or
+// nil if the call was made from Go.
+func apply(ctx context.Context, function *types.MalFunction, args []types.MalType, form *types.MalList) (types.MalType, error) {
+	for {
+		if isBytecodeFunction(function) {
+			return runVM(ctx, function, args, form)
+		}
+
+		body, ok := function.AST.(*compiledBody)
+		if !function.TailCallOptimised || !ok {
+			result, err := function.Func(args...)
+			if err != nil {
+				return nil, withCallFrame(err, function, form)
+			}
+			return result, nil
+		}
+
+		env, arity, err := newCallFrame(ctx, function, args)
+		if err != nil {
+			return nil, withCallFrame(err, function, form)
+		}
+		result, call, err := body.Arities[arity](env)
+		if err != nil {
+			return nil, withCallFrame(err, function, form)
+		}
+		if call == nil {
+			return result, nil
+		}
+		function, args, form = call.Function, call.Args, call.Form
+	}
+}
+
+// newCallFrame creates the frame a call of function with args runs in, and
+// returns it, along with the index of the arity which runs the call. Each of
+// the arity's parameters is bound to an arg. If its parameters end with
+// `& rest`, rest is bound to a list of any args left over. The frame runs
+// with ctx, the caller's context, so a caller can stop the call.
+func newCallFrame(ctx context.Context, function *types.MalFunction, args []types.MalType) (*environment.Env, int, error) {
+	l := lambdaOf(function)
+	index, ok := l.selectArity(len(args))
+	if !ok {
+		return nil, 0, arityError(function, l, len(args))
+	}
+	a := l.Arities[index]
+	values := args
+	if a.Variadic {
+		fixed := len(a.Names) - 1
+		rest := append([]types.MalType(nil), args[fixed:]...)
+		values = append(args[:fixed:fixed], types.NewList(rest...))
+	}
+	env := environment.NewFrameWithContext(ctx, function.Env.(*environment.Env), a.Names, values)
+	return env, index, env.Err()
+}
+
+func arityError(function *types.MalFunction, l *lambda, numArgs int) error {
+	name := function.Name
+	if name == "" {
+		name = "anonymous function"
+	}
+	arglists := make([]string, len(l.Arities))
+	for i, a := range l.Arities {
+		arglists[i] = a.Arglist.String()
+	}
+	return fmt.Errorf("%s takes args %s, got %d", name, strings.Join(arglists, " or "), numArgs)
+}
+
+// lambdaOf returns the fn* a function was defined by, or nil if it's a
+// builtin
+func lambdaOf(function *types.MalFunction) *lambda {
+	switch body := function.AST.(type) {
+	case *compiledBody:
+		return body.Lambda
+	case *vmBody:
+		return body.Lambda
+	}
+	return nil
+}
+
+// withCallFrame adds the call of function by form to err's stack trace. Calls
+// made from Go don't have a form, and aren't added.
+func withCallFrame(err error, function *types.MalFunction, form *types.MalList) error {
+	if form == nil {
+		return err
+	}
+	return types.WithStackFrame(err, &types.StackFrame{
+		Name: function.Name,
+		Form: form,
+	})
+}
+
+// compile compiles an analysed form. tail is true if the form is in tail
+// position, in which case it may return a tail call.
+func compile(ast types.MalType, tail bool) (code, error) {
+	switch tok := ast.(type) {
+	case *types.MalSymbol:
+		name := tok.Value
+		return func(env *environment.Env) (types.MalType, *tailCall, error) {
+			value, err := env.Get(name)
+			return value, nil, err
+		}, nil
+
+	case *localSymbol:
+		depth, index, name := tok.Depth, tok.Index, tok.Name
+		return func(env *environment.Env) (types.MalType, *tailCall, error) {
+			value, err := env.Lookup(depth, index, name)
+			return value, nil, err
+		}, nil
+
+	case *lambda:
+		body, err := compileLambda(tok)
+		if err != nil {
+			return nil, err
+		}
+		return func(env *environment.Env) (types.MalType, *tailCall, error) {
+			return newFunction(tok, body, env), nil, nil
+		}, nil
+
+	case *recurForm:
+		return compileRecur(tok)
+
+	case *types.MalList:
+		return compileList(tok, tail)
+	}
+
+	// Everything else evaluates to itself
+	return constant(ast), nil
+}
+
+func constant(value types.MalType) code {
+	return func(env *environment.Env) (types.MalType, *tailCall, error) {
+		return value, nil, nil
+	}
+}
+
+func compileList(list *types.MalList, tail bool) (code, error) {
+	if list.Len() == 0 {
+		return constant(list), nil
+	}
+
+	operator, ok := list.Items()[0].(*types.MalSymbol)
+	if !ok {
+		return compileCall(list, tail)
+	}
+	args := list.Items()[1:]
+
+	switch operator.Value {
+	case "quote":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("quote takes 1 arg, got %d", len(args))
+		}
+		return constant(args[0]), nil
+
+	case "quasiquoteexpand":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("quasiquoteexpand takes 1 arg, got %d", len(args))
+		}
+		expanded, err := quasiquote(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return constant(expanded), nil
+
+	case "macroexpand", "macroexpand-1", "macroexpand-all":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s takes 1 arg, got %d", operator.Value, len(args))
+		}
+		return func(env *environment.Env) (types.MalType, *tailCall, error) {
+			expanded, err := evalMacroexpand(list, env)
+			return expanded, nil, err
+		}, nil
+
+	case "dir", "apropos":
+		operands := make([]code, len(args))
+		for i, arg := range args {
+			c, err := compile(arg, false)
+			if err != nil {
+				return nil, err
+			}
+			operands[i] = c
+		}
+		name := operator.Value
+		return func(env *environment.Env) (types.MalType, *tailCall, error) {
+			evaluated := make([]types.MalType, len(operands))
+			for i, operand := range operands {
+				v, _, err := operand(env)
+				if err != nil {
+					return nil, nil, err
+				}
+				evaluated[i] = v
+			}
+			value, err := evalSymbolsForm(name, evaluated, env)
+			return value, nil, err
+		}, nil
+
+	case "def!", "defmacro!":
+		return compileDef(operator.Value, args)
+
+	case "let*":
+		return compileLet(args, tail)
+
+	case "loop*":
+		return compileLoop(args, tail)
+
+	case "if":
+		return compileIf(args, tail)
+
+	case "do":
+		return compileDo(args, tail)
+
+	case "try*":
+		return compileTry(args, tail)
+	}
+
+	return compileCall(list, tail)
+}
+
+func compileDef(operator string, args []types.MalType) (code, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("%s takes 2 args", operator)
+	}
+	key, ok := args[0].(*types.MalSymbol)
+	if !ok {
+		return nil, fmt.Errorf("%s: first arg isn't a symbol", operator)
+	}
+	value, err := compile(args[1], false)
+	if err != nil {
+		return nil, err
+	}
+	isMacro := operator == "defmacro!"
+
+	return func(env *environment.Env) (types.MalType, *tailCall, error) {
+		v, _, err := value(env)
+		if err != nil {
+			return nil, nil, err
+		}
+		function, ok := v.(*types.MalFunction)
+		if isMacro {
+			if !ok {
+				return nil, nil, fmt.Errorf("defmacro!: second arg isn't a function definition")
+			}
+			function.IsMacro = true
+		}
+		recordDefinition(key, v)
+		env.Set(key.Value, v)
+		return v, nil, nil
+	}, nil
+}
+
+func compileLet(args []types.MalType, tail bool) (code, error) {
+	// analyzeLet has already checked the let* is well formed
+	frame, err := compileFrame(args[0].(*letBindings))
+	if err != nil {
+		return nil, err
+	}
+	body, err := compile(args[1], tail)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(env *environment.Env) (types.MalType, *tailCall, error) {
+		childEnv, err := frame(env)
+		if err != nil {
+			return nil, nil, err
+		}
+		return body(childEnv)
+	}, nil
+}
+
+// compileFrame compiles the bindings of a let* or loop*. The function it
+// returns creates the frame they're bound in, as a child of env.
+func compileFrame(bindings *letBindings) (func(env *environment.Env) (*environment.Env, error), error) {
+	values := make([]code, len(bindings.Values))
+	for i, value := range bindings.Values {
+		c, err := compile(value, false)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = c
+	}
+
+	return func(env *environment.Env) (*environment.Env, error) {
+		childEnv := environment.NewFrame(env, bindings.Names, nil)
+		for i, value := range values {
+			v, _, err := value(childEnv)
+			if err != nil {
+				return nil, err
+			}
+			childEnv.SetSlot(bindings.Indices[i], v)
+		}
+		return childEnv, nil
+	}, nil
+}
+
+// compileLoop compiles a loop*. The body returns a tailCall with Loop set
+// when it recurs, which the loop* runs straight away, rather than returning
+// it.
+func compileLoop(args []types.MalType, tail bool) (code, error) {
+	// analyzeLoop has already checked the loop* is well formed
+	frame, err := compileFrame(args[0].(*letBindings))
+	if err != nil {
+		return nil, err
+	}
+	body, err := compile(args[1], tail)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(env *environment.Env) (types.MalType, *tailCall, error) {
+		loopEnv, err := frame(env)
+		if err != nil {
+			return nil, nil, err
+		}
+		for {
+			result, call, err := body(loopEnv)
+			if err != nil || call == nil || call.Loop == nil {
+				return result, call, err
+			}
+			loopEnv = call.Loop
+		}
+	}, nil
+}
+
+// compileRecur compiles a recur. analyzeLoop has checked it's in tail
+// position, so it's always compiled as a tail call, even if the loop* isn't
+// in tail position itself.
+func compileRecur(r *recurForm) (code, error) {
+	args := make([]code, len(r.Args))
+	for i, arg := range r.Args {
+		c, err := compile(arg, false)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = c
+	}
+
+	return func(env *environment.Env) (types.MalType, *tailCall, error) {
+		values := make([]types.MalType, len(args))
+		for i, arg := range args {
+			v, _, err := arg(env)
+			if err != nil {
+				return nil, nil, err
+			}
+			values[i] = v
+		}
+		loopEnv, err := recurFrame(env, r, values)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, &tailCall{Loop: loopEnv, Form: r.Form}, nil
+	}, nil
+}
+
+func compileIf(args []types.MalType, tail bool) (code, error) {
+	if numArgs := len(args); numArgs != 2 && numArgs != 3 {
+		return nil, fmt.Errorf("if statements must have two or three arguments, got %d", numArgs)
+	}
+	condition, err := compile(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	then, err := compile(args[1], tail)
+	if err != nil {
+		return nil, err
+	}
+	otherwise := constant(&types.MalNil{})
+	if len(args) == 3 {
+		otherwise, err = compile(args[2], tail)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return func(env *environment.Env) (types.MalType, *tailCall, error) {
+		c, _, err := condition(env)
+		if err != nil {
+			return nil, nil, err
+		}
+		if IsTruthy(c) {
+			return then(env)
+		}
+		return otherwise(env)
+	}, nil
+}
+
+func compileDo(args []types.MalType, tail bool) (code, error) {
+	if len(args) == 0 {
+		return constant(&types.MalNil{}), nil
+	}
+	forms := make([]code, len(args))
+	for i, arg := range args {
+		c, err := compile(arg, tail && i == len(args)-1)
+		if err != nil {
+			return nil, err
+		}
+		forms[i] = c
+	}
+	init, last := forms[:len(forms)-1], forms[len(forms)-1]
+
+	return func(env *environment.Env) (types.MalType, *tailCall, error) {
+		for _, form := range init {
+			if _, _, err := form(env); err != nil {
+				return nil, nil, err
+			}
+		}
+		return last(env)
+	}, nil
+}
+
+func compileTry(args []types.MalType, tail bool) (code, error) {
+	if numArgs := len(args); numArgs != 1 && numArgs != 2 {
+		return nil, fmt.Errorf("try* takes one or two args, got %d", numArgs)
+	}
+	// The body can't be in tail position, otherwise its errors would be
+	// raised after we'd stopped listening for them
+	body, err := compile(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 1 {
+		return body, nil
+	}
+
+	catch, ok := args[1].(*types.MalList)
+	if !ok || catch.Len() != 3 {
+		return nil, fmt.Errorf("try*: second arg must be a (catch* sym handler) form")
+	}
+	if operator, ok := catch.Items()[0].(*types.MalSymbol); !ok || operator.Value != "catch*" {
+		return nil, fmt.Errorf("try*: second arg must be a (catch* sym handler) form")
+	}
+	key, ok := catch.Items()[1].(*types.MalSymbol)
+	if !ok {
+		return nil, fmt.Errorf("catch*: first arg isn't a symbol")
+	}
+	handler, err := compile(catch.Items()[2], tail)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{key.Value}
+
+	return func(env *environment.Env) (types.MalType, *tailCall, error) {
+		value, _, err := body(env)
+		if err == nil {
+			return value, nil, nil
+		}
+		childEnv := environment.NewFrame(
+			env, names, []types.MalType{&types.MalException{Err: err}},
+		)
+		return handler(childEnv)
+	}, nil
+}
+
+// compileCall compiles a function call. Calls to Lisp functions in tail
+// position are returned as tail calls. Calls to builtins are always made
+// straight away, as they don't use any stack space between them and their
+// caller.
+func compileCall(list *types.MalList, tail bool) (code, error) {
+	items := make([]code, list.Len())
+	for i, item := range list.Items() {
+		c, err := compile(item, false)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = c
+	}
+
+	operator, operands := items[0], items[1:]
+
+	return func(env *environment.Env) (types.MalType, *tailCall, error) {
+		f, _, err := operator(env)
+		if err != nil {
+			return nil, nil, err
+		}
+		function, ok := f.(*types.MalFunction)
+		if !ok {
+			return nil, nil, fmt.Errorf("first item in list isn't a function")
+		}
+
+		if function.IsMacro {
+			return nil, nil, lateMacroError(list)
+		}
+
+		args := make([]types.MalType, len(operands))
+		for i, operand := range operands {
+			v, _, err := operand(env)
+			if err != nil {
+				return nil, nil, err
+			}
+			args[i] = v
+		}
+
+		if tail && function.TailCallOptimised {
+			return nil, &tailCall{
+				Function: function,
+				Args:     args,
+				Form:     list,
+			}, nil
+		}
+		value, err := apply(env.Context(), function, args, list)
+		return value, nil, err
+	}, nil
+}
+
+// lateMacroError is the error from a compiled call whose operator has become
+// a macro since the function making the call was defined. The call's
+// arguments have already been analysed, so they can't be passed to the macro,
+// which expects the forms as they were written.
+func lateMacroError(form *types.MalList) error {
+	return fmt.Errorf("%s was defined as a macro after this call to it was compiled, so it wasn't expanded. Evaluate the definition of the function which makes the call again", form.First())
+}
+
+// newFunction creates the function defined by an analysed fn* form, whose
+// body has been compiled, closing over env
+func newFunction(l *lambda, body *compiledBody, env *environment.Env) *types.MalFunction {
+	function := &types.MalFunction{
+		TailCallOptimised: true,
+		AST:               body,
+		Env:               env,
+	}
+	// This Go function is what's run when the Lisp function is called from
+	// Go (e.g. to expand a macro)
+	function.Func = func(exprs ...types.MalType) (types.MalType, error) {
+		return apply(env.Context(), function, exprs, nil)
+	}
+	function.CallContext = func(ctx context.Context, exprs ...types.MalType) (types.MalType, error) {
+		ctx, cancel := callContext(ctx, env)
+		defer cancel()
+		return apply(ctx, function, exprs, nil)
+	}
+	return function
+}
+
+// callContext returns the context for a call made from Go with ctx. The call
+// stops when either ctx is cancelled, or the context of env, the env the
+// function was defined in, is cancelled by the interpreter shutting down.
+func callContext(ctx context.Context, env *environment.Env) (context.Context, context.CancelFunc) {
+	callCtx, cancel := context.WithCancel(env.Context())
+	stop := context.AfterFunc(ctx, cancel)
+	return callCtx, func() {
+		stop()
+		cancel()
+	}
+}
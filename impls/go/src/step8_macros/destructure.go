@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jamesroutley/mal/impls/go/src/core"
+	"github.com/jamesroutley/mal/impls/go/src/types"
+)
+
+// This file implements destructuring. Anywhere let* or fn* binds a symbol, it
+// can bind a pattern instead, which binds symbols to parts of the value:
+//
+// - (a b & more), or [a b & more], binds a and b to the first two items of a
+//   list (or vector, or lazy seq), and more to a list of the rest. Missing
+//   items are nil. (a b :as all) binds all to the whole list.
+// - {:keys [host port]} binds host and port to the values of :host and :port
+//   in a hash map. {h :host} binds h to the value of :host. :as binds the
+//   whole map.
+//
+// List and vector patterns can contain other patterns. Patterns are expanded into
+// ordinary let* bindings before the code is analysed, e.g.
+//
+// (let* ((a b) xs) a)
+//
+// becomes
+//
+// (let* (seq__1__ (<check xs is a list> xs) a (<nth> seq__1__ 0) b (<nth> seq__1__ 1)) a)
+//
+// where the functions in angle brackets are the Go functions below.
+
+// isPattern reports whether a binding target is a destructuring pattern,
+// rather than a symbol
+func isPattern(target types.MalType) bool {
+	switch target.(type) {
+	case *types.MalList, *types.MalVector, *types.MalHashMap:
+		return true
+	}
+	return false
+}
+
+// expandBindings expands the patterns in a let* binding list, of alternating
+// targets and values. The bindings it returns all bind symbols.
+func expandBindings(items []types.MalType) ([]types.MalType, error) {
+	if len(items)%2 != 0 {
+		return nil, fmt.Errorf("let*: first arg doesn't have an even number of items")
+	}
+	var expanded []types.MalType
+	for i := 0; i < len(items); i += 2 {
+		bindings, err := expandBinding(items[i], items[i+1])
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, bindings...)
+	}
+	return expanded, nil
+}
+
+// expandBinding expands the binding of target to the value of the form
+// value into bindings of symbols
+func expandBinding(target types.MalType, value types.MalType) ([]types.MalType, error) {
+	switch target := target.(type) {
+	case *types.MalSymbol:
+		return []types.MalType{target, value}, nil
+	case *types.MalList:
+		return expandListPattern(target, target.Items(), value)
+	case *types.MalVector:
+		return expandListPattern(target, target.Items(), value)
+	case *types.MalHashMap:
+		return expandMapPattern(target, value)
+	}
+	return nil, fmt.Errorf("can't bind %s: it isn't a symbol or a pattern", target)
+}
+
+// expandListPattern expands a list or vector pattern, whose items are items
+func expandListPattern(pattern types.MalType, items []types.MalType, value types.MalType) ([]types.MalType, error) {
+	seq := core.Gensym("seq")
+	bindings := []types.MalType{
+		seq, call(checkListFunction, value, quote(pattern)),
+	}
+	for i := 0; i < len(items); i++ {
+		switch item := items[i].(type) {
+		case *types.MalSymbol:
+			if item.Value == "&" {
+				if i+1 >= len(items) {
+					return nil, fmt.Errorf("destructuring %s: & must be followed by a pattern", pattern)
+				}
+				rest, err := expandBinding(items[i+1], call(dropFunction, seq, &types.MalInt{Value: i}))
+				if err != nil {
+					return nil, err
+				}
+				bindings = append(bindings, rest...)
+				i++
+				// Only :as and its symbol can follow the rest of the list
+				if after := len(items) - i - 1; after > 0 && !(after == 2 && isKeyword(items[i+1], "as")) {
+					return nil, fmt.Errorf("destructuring %s: only :as can follow & and its pattern", pattern)
+				}
+				continue
+			}
+		case *types.MalKeyword:
+			if item.Value == "as" {
+				as, ok := nextSymbol(items, i)
+				if !ok {
+					return nil, fmt.Errorf("destructuring %s: :as must be followed by a symbol", pattern)
+				}
+				bindings = append(bindings, as, seq)
+				i++
+				continue
+			}
+		}
+		element, err := expandBinding(items[i], call(nthFunction, seq, &types.MalInt{Value: i}))
+		if err != nil {
+			return nil, err
+		}
+		bindings = append(bindings, element...)
+	}
+	return bindings, nil
+}
+
+func expandMapPattern(pattern *types.MalHashMap, value types.MalType) ([]types.MalType, error) {
+	m := core.Gensym("map")
+	bindings := []types.MalType{
+		m, call(checkMapFunction, value, quote(pattern)),
+	}
+	for i, key := range pattern.Keys() {
+		target := pattern.Values()[i]
+		if keyword, ok := key.(*types.MalKeyword); ok {
+			switch keyword.Value {
+			case "keys":
+				var symbols []types.MalType
+				switch target := target.(type) {
+				case *types.MalList:
+					symbols = target.Items()
+				case *types.MalVector:
+					symbols = target.Items()
+				default:
+					return nil, fmt.Errorf("destructuring %s: :keys must be followed by a list of symbols", pattern)
+				}
+				for _, symbol := range symbols {
+					symbol, ok := symbol.(*types.MalSymbol)
+					if !ok {
+						return nil, fmt.Errorf("destructuring %s: :keys must be followed by a list of symbols", pattern)
+					}
+					bindings = append(bindings, symbol, call(getFunction, m, &types.MalKeyword{Value: symbol.Value}))
+				}
+				continue
+			case "as":
+				symbol, ok := target.(*types.MalSymbol)
+				if !ok {
+					return nil, fmt.Errorf("destructuring %s: :as must be followed by a symbol", pattern)
+				}
+				bindings = append(bindings, symbol, m)
+				continue
+			}
+		}
+		// Other entries map a symbol to the key whose value it's bound to.
+		// The reader has already read them as key, value pairs, so the
+		// symbol is the key.
+		symbol, ok := key.(*types.MalSymbol)
+		if !ok {
+			return nil, fmt.Errorf("destructuring %s: %s isn't a symbol", pattern, key)
+		}
+		bindings = append(bindings, symbol, call(getFunction, m, quote(target)))
+	}
+	return bindings, nil
+}
+
+// expandParams replaces the patterns in a fn* parameter list with symbols.
+// It returns the new parameter list, and the let* bindings which destructure
+// the args bound to them, which are empty if there are no patterns.
+func expandParams(params []types.MalType) ([]types.MalType, []types.MalType, error) {
+	expanded := make([]types.MalType, len(params))
+	var bindings []types.MalType
+	for i, param := range params {
+		if !isPattern(param) {
+			expanded[i] = param
+			continue
+		}
+		arg := core.Gensym("arg")
+		expanded[i] = arg
+		destructure, err := expandBinding(param, arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		bindings = append(bindings, destructure...)
+	}
+	return expanded, bindings, nil
+}
+
+func nextSymbol(items []types.MalType, i int) (*types.MalSymbol, bool) {
+	if i+1 >= len(items) {
+		return nil, false
+	}
+	symbol, ok := items[i+1].(*types.MalSymbol)
+	return symbol, ok
+}
+
+func isKeyword(form types.MalType, name string) bool {
+	keyword, ok := form.(*types.MalKeyword)
+	return ok && keyword.Value == name
+}
+
+func call(function *types.MalFunction, args ...types.MalType) *types.MalList {
+	return types.NewList(args...).Cons(function)
+}
+
+func quote(form types.MalType) *types.MalList {
+	return types.NewList(&types.MalSymbol{Value: "quote"}, form)
+}
+
+// checkListFunction checks a value destructured by a list pattern is a list,
+// vector, lazy seq or nil, and returns it
+var checkListFunction = &types.MalFunction{
+	Name: "destructure",
+	Func: func(args ...types.MalType) (types.MalType, error) {
+		switch args[0].(type) {
+		case *types.MalList, *types.MalVector, *types.MalLazySeq, *types.MalNil:
+			return args[0], nil
+		}
+		return nil, fmt.Errorf("can't destructure %s with %s: it isn't a list", args[0], args[1])
+	},
+}
+
+// checkMapFunction checks a value destructured by a hash map pattern is a
+// hash map or nil, and returns it
+var checkMapFunction = &types.MalFunction{
+	Name: "destructure",
+	Func: func(args ...types.MalType) (types.MalType, error) {
+		switch args[0].(type) {
+		case *types.MalHashMap, *types.MalNil:
+			return args[0], nil
+		}
+		return nil, fmt.Errorf("can't destructure %s with %s: it isn't a hash map", args[0], args[1])
+	},
+}
+
+// nthFunction returns the item of a list at an index, or nil if there isn't
+// one
+var nthFunction = &types.MalFunction{
+	Name: "destructure",
+	Func: func(args ...types.MalType) (types.MalType, error) {
+		seq, err := dropItems(args[0], args[1].(*types.MalInt).Value)
+		if err != nil {
+			return nil, err
+		}
+		first, _, ok, err := types.Next(seq)
+		if err != nil || !ok {
+			return &types.MalNil{}, err
+		}
+		return first, nil
+	},
+}
+
+// dropFunction returns a list of the items of a list after an index. If the
+// list is a lazy seq, the rest of it is too.
+var dropFunction = &types.MalFunction{
+	Name: "destructure",
+	Func: func(args ...types.MalType) (types.MalType, error) {
+		seq, err := dropItems(args[0], args[1].(*types.MalInt).Value)
+		if err != nil {
+			return nil, err
+		}
+		if !types.IsSeq(seq) {
+			return &types.MalList{}, nil
+		}
+		return seq, nil
+	},
+}
+
+// dropItems returns the sequence after the first n items of seq. Lazy seqs
+// are only realised as far as they need to be, so an infinite seq can be
+// destructured.
+func dropItems(seq types.MalType, n int) (types.MalType, error) {
+	switch list := seq.(type) {
+	case *types.MalList:
+		return dropSlice(list.Items(), n), nil
+	case *types.MalVector:
+		return dropSlice(list.Items(), n), nil
+	}
+	for i := 0; i < n; i++ {
+		_, rest, ok, err := types.Next(seq)
+		if err != nil || !ok {
+			return &types.MalList{}, err
+		}
+		seq = rest
+	}
+	return seq, nil
+}
+
+// dropSlice returns a list of the items after the first n
+func dropSlice(items []types.MalType, n int) *types.MalList {
+	if n >= len(items) {
+		return &types.MalList{}
+	}
+	return types.NewList(items[n:]...)
+}
+
+// getFunction returns the value of a key in a hash map, or nil if it isn't
+// there
+var getFunction = &types.MalFunction{
+	Name: "destructure",
+	Func: func(args ...types.MalType) (types.MalType, error) {
+		m, ok := args[0].(*types.MalHashMap)
+		if !ok {
+			return &types.MalNil{}, nil
+		}
+		if value, ok := m.Get(args[1]); ok {
+			return value, nil
+		}
+		return &types.MalNil{}, nil
+	},
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jamesroutley/mal/impls/go/src/environment"
+	"github.com/jamesroutley/mal/impls/go/src/printer"
+	"github.com/jamesroutley/mal/impls/go/src/types"
+)
+
+// This file implements the tools for debugging macros:
+//
+// - (macroexpand form) expands form until it's no longer a macro call, just
+//   like Eval does before evaluating it
+// - (macroexpand-1 form) expands form once
+// - (macroexpand-all form) expands form, and every form nested in it, except
+//   quoted ones, and calls to local variables which shadow macros
+//
+// They're special forms, so form isn't evaluated. The REPL's :expand command
+// prints each step macroexpand takes.
+
+// isMacroexpandForm reports whether operator is one of the macroexpand
+// special forms
+func isMacroexpandForm(operator string) bool {
+	switch operator {
+	case "macroexpand", "macroexpand-1", "macroexpand-all":
+		return true
+	}
+	return false
+}
+
+// evalMacroexpand evaluates a (macroexpand form), (macroexpand-1 form) or
+// (macroexpand-all form) special form, expanding macros defined in env
+func evalMacroexpand(list *types.MalList, env *environment.Env) (types.MalType, error) {
+	operator := list.Items()[0].(*types.MalSymbol).Value
+	if list.Len() != 2 {
+		return nil, fmt.Errorf("%s takes 1 arg, got %d", operator, list.Len()-1)
+	}
+	form := list.Items()[1]
+	switch operator {
+	case "macroexpand-1":
+		expanded, _, err := macroExpand1(form, env)
+		return expanded, err
+	case "macroexpand-all":
+		return macroExpandAll(form, env, nil)
+	}
+	return macroExpand(form, env)
+}
+
+// macroExpand1 expands ast once, if it's a macro call. It returns whether it
+// was expanded.
+func macroExpand1(ast types.MalType, env *environment.Env) (types.MalType, bool, error) {
+	if !isMacroCall(ast, env) {
+		return ast, false, nil
+	}
+	// isMacroCall has checked these casts will succeed
+	list := ast.(*types.MalList)
+	macroName := list.Items()[0].(*types.MalSymbol)
+	macroNameValue, err := env.Get(macroName.Value)
+	if err != nil {
+		return nil, false, err
+	}
+	macroFunc := macroNameValue.(*types.MalFunction)
+
+	expanded, err := macroFunc.Func(list.Items()[1:]...)
+	if err != nil {
+		return nil, false, types.WithStackFrame(err, &types.StackFrame{
+			Name: macroFunc.Name,
+			Form: list,
+		})
+	}
+	return expanded, true, nil
+}
+
+// macroExpandAll expands ast, and each form nested in it. Quoted forms aren't
+// expanded, and nor are quasiquoted ones, except where they're unquoted.
+// Binding lists and parameter lists aren't forms, so they aren't expanded,
+// but the values bound in them are.
+//
+// locals are the names of the local variables bound around ast. Like it is
+// when the code is analysed, a macro call isn't expanded if a local variable
+// with the same name as the macro shadows it.
+func macroExpandAll(ast types.MalType, env *environment.Env, locals map[string]bool) (types.MalType, error) {
+	for !isShadowed(ast, locals) {
+		expanded, ok, err := macroExpand1(ast, env)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		ast = expanded
+	}
+	var err error
+	switch ast := ast.(type) {
+	case *types.MalVector:
+		items := make([]types.MalType, ast.Len())
+		for i, item := range ast.Items() {
+			if items[i], err = macroExpandAll(item, env, locals); err != nil {
+				return nil, err
+			}
+		}
+		expanded := types.NewVector(items...)
+		expanded.Meta = ast.Meta
+		return expanded, nil
+	case *types.MalSet:
+		items := make([]types.MalType, ast.Len())
+		for i, item := range ast.Items() {
+			if items[i], err = macroExpandAll(item, env, locals); err != nil {
+				return nil, err
+			}
+		}
+		expanded, err := types.NewSet(items...)
+		if err != nil {
+			return nil, err
+		}
+		expanded.Meta = ast.Meta
+		return expanded, nil
+	case *types.MalHashMap:
+		values := make([]types.MalType, 0, 2*ast.Len())
+		for i, key := range ast.Keys() {
+			value, err := macroExpandAll(ast.Values()[i], env, locals)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, key, value)
+		}
+		expanded, err := types.NewHashMap(values...)
+		if err != nil {
+			return nil, err
+		}
+		expanded.Meta = ast.Meta
+		return expanded, nil
+	case *types.MalList:
+		return macroExpandAllList(ast, env, locals)
+	}
+	return ast, nil
+}
+
+// isShadowed reports whether ast is a call to a local variable
+func isShadowed(ast types.MalType, locals map[string]bool) bool {
+	list, ok := ast.(*types.MalList)
+	if !ok || list.Len() == 0 {
+		return false
+	}
+	operator, ok := list.Items()[0].(*types.MalSymbol)
+	return ok && locals[operator.Value]
+}
+
+func macroExpandAllList(list *types.MalList, env *environment.Env, locals map[string]bool) (types.MalType, error) {
+	if list.Len() == 0 {
+		return list, nil
+	}
+	// skip is the number of items after the operator which aren't forms
+	skip := 0
+	// Special forms can't be shadowed
+	if operator, ok := list.Items()[0].(*types.MalSymbol); ok {
+		args := list.Items()[1:]
+		switch operator.Value {
+		case "quote", "quasiquoteexpand", "macroexpand", "macroexpand-1", "macroexpand-all":
+			return list, nil
+		case "quasiquote":
+			return macroExpandUnquoted(list, env, locals)
+		case "def!", "defmacro!":
+			skip = 1
+		case "fn*":
+			if !isMultiArity(args) {
+				if len(args) == 0 {
+					return list, nil
+				}
+				return expandItems(list, list.Items()[:2], list.Items()[2:], env, bind(locals, args[0]))
+			}
+			// Each arity binds its own parameters
+			items := []types.MalType{list.Items()[0]}
+			for _, arg := range args {
+				clause := arg.(*types.MalList)
+				expanded, err := expandItems(clause, clause.Items()[:1], clause.Items()[1:], env, bind(locals, clause.Items()[0]))
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, expanded)
+			}
+			return types.NewListAt(list.Pos, items...), nil
+		case "catch*":
+			if len(args) == 0 {
+				return list, nil
+			}
+			return expandItems(list, list.Items()[:2], list.Items()[2:], env, bind(locals, args[0]))
+		case "let*", "loop*":
+			if len(args) == 0 {
+				return list, nil
+			}
+			bindings, ok := args[0].(*types.MalList)
+			if !ok {
+				return list, nil
+			}
+			// Each value can refer to the variables bound before it
+			inner := locals
+			expanded := make([]types.MalType, bindings.Len())
+			for i, item := range bindings.Items() {
+				expanded[i] = item
+				if i%2 == 0 {
+					continue
+				}
+				value, err := macroExpandAll(item, env, inner)
+				if err != nil {
+					return nil, err
+				}
+				expanded[i] = value
+				inner = bind(inner, bindings.Items()[i-1])
+			}
+			items := []types.MalType{
+				list.Items()[0], types.NewListAt(bindings.Pos, expanded...),
+			}
+			return expandItems(list, items, args[1:], env, inner)
+		}
+	}
+	if skip > list.Len()-1 {
+		skip = list.Len() - 1
+	}
+	return expandItems(list, list.Items()[:skip+1], list.Items()[skip+1:], env, locals)
+}
+
+// bind returns a copy of locals, along with the names bound by target, which
+// is a symbol, a destructuring pattern or a parameter list
+func bind(locals map[string]bool, target types.MalType) map[string]bool {
+	bound := make(map[string]bool, len(locals)+1)
+	for name := range locals {
+		bound[name] = true
+	}
+	var add func(target types.MalType)
+	add = func(target types.MalType) {
+		switch target := target.(type) {
+		case *types.MalSymbol:
+			if target.Value != "&" {
+				bound[target.Value] = true
+			}
+		case *types.MalList:
+			for _, item := range target.Items() {
+				add(item)
+			}
+		case *types.MalVector:
+			for _, item := range target.Items() {
+				add(item)
+			}
+		case *types.MalHashMap:
+			// {h :host} binds h, {:keys (host)} binds host, and {:as m} binds
+			// m
+			for i, key := range target.Keys() {
+				if _, ok := key.(*types.MalKeyword); ok {
+					add(target.Values()[i])
+				} else {
+					add(key)
+				}
+			}
+		}
+	}
+	add(target)
+	return bound
+}
+
+// expandItems returns a copy of list, whose items are done followed by the
+// expansion of each of forms
+func expandItems(
+	list *types.MalList, done []types.MalType, forms []types.MalType, env *environment.Env, locals map[string]bool,
+) (types.MalType, error) {
+	items := append([]types.MalType(nil), done...)
+	for _, form := range forms {
+		expanded, err := macroExpandAll(form, env, locals)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, expanded)
+	}
+	return types.NewListAt(list.Pos, items...), nil
+}
+
+// macroExpandUnquoted expands the forms which are unquoted inside a
+// quasiquoted form
+func macroExpandUnquoted(ast types.MalType, env *environment.Env, locals map[string]bool) (types.MalType, error) {
+	list, ok := ast.(*types.MalList)
+	if !ok || list.Len() == 0 {
+		return ast, nil
+	}
+	if operator, ok := list.Items()[0].(*types.MalSymbol); ok {
+		switch operator.Value {
+		case "unquote", "splice-unquote":
+			return expandItems(list, list.Items()[:1], list.Items()[1:], env, locals)
+		}
+	}
+	items := make([]types.MalType, list.Len())
+	for i, item := range list.Items() {
+		expanded, err := macroExpandUnquoted(item, env, locals)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = expanded
+	}
+	return types.NewListAt(list.Pos, items...), nil
+}
+
+// formatExpansion describes how ast is expanded, for the REPL's :expand
+// command. It lists each step taken by macroexpand, then, if nested forms
+// were expanded too, the result of macroexpand-all.
+// > :expand (when a (-> b c))
+// 0: (when a (-> b c))
+// 1: (if a (do (-> b c)))
+// all: (if a (do (c b)))
+func formatExpansion(ast types.MalType, env *environment.Env) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "0: %s\n", printer.PrStr(ast))
+	expanded := ast
+	for step := 1; ; step++ {
+		next, ok, err := macroExpand1(expanded, env)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			break
+		}
+		expanded = next
+		fmt.Fprintf(&b, "%d: %s\n", step, printer.PrStr(expanded))
+	}
+
+	all, err := macroExpandAll(ast, env, nil)
+	if err != nil {
+		return "", err
+	}
+	if printed := printer.PrStr(all); printed != printer.PrStr(expanded) {
+		fmt.Fprintf(&b, "all: %s\n", printed)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jamesroutley/mal/impls/go/src/core"
+	"github.com/jamesroutley/mal/impls/go/src/environment"
+	"github.com/jamesroutley/mal/impls/go/src/printer"
+	"github.com/jamesroutley/mal/impls/go/src/types"
+)
+
+// Functions (and anything else which can have metadata) are documented by
+// the :doc and :arglists keys of their metadata. Builtins are documented when
+// they're registered, and def! and defmacro! take an optional docstring:
+//
+// > (def! add1 "Adds 1 to a" (fn* (a) (+ a 1)))
+// > (doc add1)
+// add1
+// (add1 a)
+//   Adds 1 to a
+
+// splitDocstring checks the args of def! or defmacro!, and returns the symbol
+// and value, and the docstring, which is nil if there isn't one
+func splitDocstring(operator string, args []types.MalType) ([]types.MalType, *types.MalString, error) {
+	switch len(args) {
+	case 2:
+		return args, nil, nil
+	case 3:
+		doc, ok := args[1].(*types.MalString)
+		if !ok {
+			return nil, nil, fmt.Errorf("%s: docstring isn't a string", operator)
+		}
+		return []types.MalType{args[0], args[2]}, doc, nil
+	}
+	return nil, nil, fmt.Errorf("%s takes 2 args, or 3 with a docstring", operator)
+}
+
+// withDoc adds a docstring to value's metadata. Functions which haven't been
+// named yet have just been created by fn*, so, like recordDefinition, it
+// changes them in place. Anything else is copied.
+func withDoc(value types.MalType, doc *types.MalString) (types.MalType, error) {
+	var meta *types.MalHashMap
+	switch m := types.Meta(value).(type) {
+	case *types.MalNil:
+		meta, _ = types.NewHashMap()
+	case *types.MalHashMap:
+		meta = m
+	default:
+		return nil, fmt.Errorf("can't add a docstring to %s, whose metadata isn't a hash map", value)
+	}
+	// The key is a keyword, so this can't fail
+	meta, _ = meta.Assoc(&types.MalKeyword{Value: "doc"}, doc)
+
+	if function, ok := value.(*types.MalFunction); ok && function.Name == "" {
+		function.Meta = meta
+		return function, nil
+	}
+	documented, err := types.WithMeta(value, meta)
+	if err != nil {
+		return nil, fmt.Errorf("can't add a docstring to %s", value)
+	}
+	return documented, nil
+}
+
+// docFunction adds a docstring to a value. The analyser uses it to implement
+// def! and defmacro! with a docstring.
+var docFunction = &types.MalFunction{
+	Name: "def!",
+	Func: func(args ...types.MalType) (types.MalType, error) {
+		return withDoc(args[0], args[1].(*types.MalString))
+	},
+}
+
+// formatDoc returns the documentation of a value: its name, its arglists, and
+// its docstring, indented
+func formatDoc(value types.MalType) string {
+	meta, _ := types.Meta(value).(*types.MalHashMap)
+	getString := func(key string) (string, bool) {
+		if meta == nil {
+			return "", false
+		}
+		s, ok := getKeyword(meta, key).(*types.MalString)
+		if !ok {
+			return "", false
+		}
+		return s.Value, true
+	}
+
+	var b strings.Builder
+	name, ok := getString("name")
+	if !ok {
+		name = printer.PrStr(value)
+	}
+	b.WriteString(name + "\n")
+	if function, ok := value.(*types.MalFunction); ok && function.IsMacro {
+		b.WriteString("Macro\n")
+	}
+	if meta != nil {
+		if arglists, ok := getKeyword(meta, "arglists").(*types.MalList); ok {
+			for _, arglist := range arglists.Items() {
+				params, ok := arglist.(*types.MalList)
+				if !ok {
+					continue
+				}
+				call := append([]types.MalType{&types.MalSymbol{Value: name}}, params.Items()...)
+				b.WriteString(printer.PrStr(types.NewList(call...)) + "\n")
+			}
+		}
+	}
+	doc, ok := getString("doc")
+	if !ok {
+		doc = "No documentation"
+	}
+	for _, line := range strings.Split(doc, "\n") {
+		b.WriteString("  " + line + "\n")
+	}
+	return b.String()
+}
+
+func getKeyword(m *types.MalHashMap, key string) types.MalType {
+	value, _ := m.Get(&types.MalKeyword{Value: key})
+	return value
+}
+
+// setHelpFunctions adds doc, dir and apropos to env. Called by name, dir and
+// apropos are special forms (see evalSymbolsForm), but they're also
+// functions, so they can be passed around like any other. As functions,
+// they only search env, and the envs it's nested in.
+func setHelpFunctions(env *environment.Env) {
+	env.Set("doc", core.NewBuiltin("doc", "(x)",
+		"Prints the name, arglists and docstring of x",
+		func(args ...types.MalType) (types.MalType, error) {
+			if err := core.ValidateNArgs(1, args); err != nil {
+				return nil, err
+			}
+			fmt.Print(formatDoc(args[0]))
+			return &types.MalNil{}, nil
+		},
+	))
+
+	env.Set("dir", core.NewBuiltin("dir", "()",
+		"Returns a sorted list of every symbol which is defined, including local variables",
+		func(args ...types.MalType) (types.MalType, error) {
+			return evalSymbolsForm("dir", args, env)
+		},
+	))
+
+	env.Set("apropos", core.NewBuiltin("apropos", "(s)",
+		"Returns a sorted list of the defined symbols, including local variables, whose names contain the string s",
+		func(args ...types.MalType) (types.MalType, error) {
+			return evalSymbolsForm("apropos", args, env)
+		},
+	))
+}
+
+// isSymbolsForm reports whether operator is dir or apropos, which are
+// special forms when they're called by name
+func isSymbolsForm(operator string) bool {
+	return operator == "dir" || operator == "apropos"
+}
+
+// evalSymbolsForm evaluates a (dir) or (apropos s) special form, whose args
+// have already been evaluated. They're special forms so they can search the
+// env they're called in, and the envs it's nested in, which means they find
+// local variables, as well as globals.
+func evalSymbolsForm(operator string, args []types.MalType, env *environment.Env) (types.MalType, error) {
+	if operator == "dir" {
+		if err := core.ValidateNArgs(0, args); err != nil {
+			return nil, err
+		}
+		return symbolList(env.Symbols()), nil
+	}
+
+	if err := core.ValidateNArgs(1, args); err != nil {
+		return nil, err
+	}
+	s, ok := args[0].(*types.MalString)
+	if !ok {
+		return nil, fmt.Errorf("apropos takes a string")
+	}
+	var matches []string
+	for _, name := range env.Symbols() {
+		if strings.Contains(name, s.Value) {
+			matches = append(matches, name)
+		}
+	}
+	return symbolList(matches), nil
+}
+
+func symbolList(names []string) *types.MalList {
+	items := make([]types.MalType, len(names))
+	for i, name := range names {
+		items[i] = &types.MalSymbol{Value: name}
+	}
+	return types.NewList(items...)
+}
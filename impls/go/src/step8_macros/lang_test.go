@@ -1,7 +1,11 @@
 package main
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/jamesroutley/mal/impls/go/src/core"
 	"github.com/jamesroutley/mal/impls/go/src/environment"
@@ -82,6 +86,57 @@ func TestFn(t *testing.T) {
 			input:    "((fn* (a) a) 100)",
 			expected: "100",
 		},
+		{
+			name:     "& binds the remaining args to a list",
+			input:    "((fn* (a & more) (list a more)) 1 2 3)",
+			expected: "(1 (2 3))",
+		},
+		{
+			name:     "the rest param can be empty",
+			input:    "((fn* (a & more) (list a more)) 1)",
+			expected: "(1 ())",
+		},
+		{
+			name:     "calling a function with the wrong number of args is an error",
+			input:    "(try* ((fn* (a b) a) 1) (catch* e e))",
+			expected: `"anonymous function takes args (a b), got 1"`,
+		},
+		{
+			name:     "calling a variadic function with too few args is an error",
+			input:    "(do (def! f (fn* (a & more) a)) (try* (f) (catch* e e)))",
+			expected: `"f takes args (a & more), got 0"`,
+		},
+		{
+			name:          "& must be followed by one param",
+			input:         "(fn* (a & b c) a)",
+			expextedError: fmt.Errorf("fn*: & must be followed by exactly one parameter"),
+		},
+	}
+	runTests(t, cases)
+}
+
+func TestLists(t *testing.T) {
+	cases := []*TestCase{
+		{
+			name:     "first returns the first item",
+			input:    "(list (first (list 1 2)) (first (list)) (first nil))",
+			expected: "(1 nil nil)",
+		},
+		{
+			name:     "rest returns every item but the first",
+			input:    "(list (rest (list 1 2 3)) (rest (list)) (rest nil))",
+			expected: "((2 3) () ())",
+		},
+		{
+			name:     "nth returns the item at an index",
+			input:    "(nth (list 1 2 3) 2)",
+			expected: "3",
+		},
+		{
+			name:          "nth raises an error if the index is out of range",
+			input:         "(nth (list 1 2 3) 3)",
+			expextedError: fmt.Errorf("nth: index 3 out of range for a list of 3 items"),
+		},
 	}
 	runTests(t, cases)
 }
@@ -105,6 +160,82 @@ func TestRecursion(t *testing.T) {
 	runTests(t, cases)
 }
 
+func TestLoop(t *testing.T) {
+	cases := []*TestCase{
+		{
+			name:     "recur rebinds the loop* variables and evaluates the body again",
+			input:    "(loop* (i 0 acc 1) (if (< i 5) (recur (+ i 1) (* acc 2)) acc))",
+			expected: "32",
+		},
+		{
+			name:     "loops don't grow the stack",
+			input:    "((fn* (n) (loop* (i 0) (if (< i n) (recur (+ i 1)) i))) 100000)",
+			expected: "100000",
+		},
+		{
+			name:     "loop* doesn't have to be in tail position",
+			input:    "(+ 1 ((fn* () (+ 1 (loop* (i 0) (if (< i 3) (recur (+ i 1)) i))))))",
+			expected: "5",
+		},
+		{
+			name:     "recur can be in tail position of let*, do and macros",
+			input:    "(loop (i 0 acc ()) (let* (next (+ i 1)) (cond (= i 3) acc true (do (recur next (cons i acc))))))",
+			expected: "(2 1 0)",
+		},
+		{
+			name:     "recur refers to the innermost loop*",
+			input:    "(loop* (i 0 acc 0) (if (< i 3) (recur (+ i 1) (+ acc (loop* (j 0) (if (< j i) (recur (+ j 1)) j)))) acc))",
+			expected: "3",
+		},
+		{
+			name:     "each iteration has its own frame",
+			input:    "(loop* (i 0 fs ()) (if (< i 3) (recur (+ i 1) (cons (fn* () i) fs)) (map (fn* (f) (f)) fs)))",
+			expected: "(2 1 0)",
+		},
+		{
+			name:     "loop* destructures its bindings each time round",
+			input:    "(loop* ((x & more) (list 1 2 3) acc 0) (if x (recur more (+ acc x)) acc))",
+			expected: "6",
+		},
+		{
+			name:     "recur can be in tail position of a catch* handler",
+			input:    "(loop* (i 0) (try* (if (< i 3) (i) i) (catch* e (recur (+ i 1)))))",
+			expected: "3",
+		},
+		{
+			name:          "recur must be in tail position",
+			input:         "(loop* (i 0) (+ 1 (recur i)))",
+			expextedError: fmt.Errorf("recur isn't in tail position: (recur i)"),
+		},
+		{
+			name:     "the error is reported when the function is defined",
+			input:    "(try* (fn* (n) (loop* (i n) (if (recur i) 1 2))) (catch* e e))",
+			expected: `"recur isn't in tail position: (recur i)"`,
+		},
+		{
+			name:          "recur can't be in the body of a try*",
+			input:         "(loop* (i 0) (try* (recur i) (catch* e e)))",
+			expextedError: fmt.Errorf("recur isn't in tail position: (recur i)"),
+		},
+		{
+			name:          "recur must be inside a loop*",
+			input:         "(fn* (n) (recur n))",
+			expextedError: fmt.Errorf("recur isn't inside a loop*"),
+		},
+		{
+			name:          "a fn* inside a loop* can't recur to it",
+			input:         "(loop* (i 0) (fn* () (recur 1)))",
+			expextedError: fmt.Errorf("recur isn't inside a loop*"),
+		},
+		{
+			name:          "recur takes an arg for each binding",
+			input:         "(loop* (i 0 j 0) (recur 1))",
+			expextedError: fmt.Errorf("recur takes 2 args, the number of loop* bindings, got 1"),
+		},
+	}
+	runTests(t, cases)
+}
+
 func TestReadString(t *testing.T) {
 	cases := []*TestCase{
 		{
@@ -132,24 +263,1761 @@ func TestQuote(t *testing.T) {
 	runTests(t, cases)
 }
 
-func runTests(t *testing.T, cases []*TestCase) {
-	t.Helper()
-	for _, tc := range cases {
-		tc := tc
-		t.Run(tc.name, func(t *testing.T) {
-			t.Parallel()
-			env := environment.NewEnv()
-			for _, item := range core.Namespace {
-				env.Set(item.Symbol.Value, item.Func)
-			}
-			actual, err := Rep(tc.input, env)
-			if tc.expextedError != nil {
-				// TODO: assert on error message
-				assert.Error(t, err)
-				return
-			}
-			require.NoError(t, err)
-			assert.Equal(t, tc.expected, actual)
+func TestTryCatch(t *testing.T) {
+	cases := []*TestCase{
+		{
+			name:     "try returns the value of its body if there's no error",
+			input:    "(try* 123 (catch* e 456))",
+			expected: "123",
+		},
+		{
+			name:     "exceptions print their message escaped",
+			input:    "(try* (slurp \"no\\such\nfile\") (catch* e e))",
+			expected: `"open no\\such\nfile: no such file or directory"`,
+		},
+		{
+			name:     "catch binds the exception",
+			input:    "(try* (abc 1 2) (catch* e e))",
+			expected: "\"`abc` is undefined\"",
+		},
+		{
+			name:          "try without catch returns the error",
+			input:         "(try* (abc 1 2))",
+			expextedError: fmt.Errorf("`abc` is undefined"),
+		},
+		{
+			name:     "caught exceptions can be compared",
+			input:    "(try* (abc) (catch* e (list (= e e) (= e (try* (abc) (catch* e2 e2))) (= e 1))))",
+			expected: "(true true false)",
+		},
+		{
+			name:     "functions are only equal to themselves",
+			input:    "(list (= + +) (= + -) (= (fn* (a) a) (fn* (a) a)))",
+			expected: "(true false false)",
+		},
+	}
+	runTests(t, cases)
+}
+
+func TestStackTrace(t *testing.T) {
+	cases := []*TestCase{
+		{
+			name:     "builtins are included in the stack trace",
+			input:    "(try* (count 1) (catch* e (stacktrace e)))",
+			expected: `("count: (count 1) at 1:7")`,
+		},
+		{
+			name: "functions are named after the symbol they're defined as",
+			input: `
+(do
+	(def! f (fn* (a) (+ 1 (g a))))
+	(def! g (fn* (a) (count a)))
+	(try* (f 1) (catch* e (stacktrace e))))`,
+			expected: `("count: (count a) at 4:19" "g: (g a) at 3:24" "f: (f 1) at 5:8")`,
+		},
+		{
+			name: "tail calls replace the caller's frame",
+			input: `
+(do
+	(def! f (fn* (a) (g a)))
+	(def! g (fn* (a) (count a)))
+	(try* (f 1) (catch* e (stacktrace e))))`,
+			expected: `("count: (count a) at 4:19" "g: (g a) at 3:19")`,
+		},
+		{
+			name:     "anonymous functions",
+			input:    "(try* ((fn* (a) (count a)) 1) (catch* e (stacktrace e)))",
+			expected: `("count: (count a) at 1:17" "<anonymous>: ((fn* (a) (count a)) 1) at 1:7")`,
+		},
+	}
+	runTests(t, cases)
+}
+
+func TestSourcePosition(t *testing.T) {
+	cases := []*TestCase{
+		{
+			name:     "lists have positions",
+			input:    "(source-position (quote (a b)))",
+			expected: "(nil 1 25)",
+		},
+		{
+			name:     "symbols have positions",
+			input:    "(source-position (quote abc))",
+			expected: "(nil 1 25)",
+		},
+		{
+			name:     "other atoms don't have positions",
+			input:    "(source-position 1)",
+			expected: "nil",
+		},
+		{
+			name:     "read-string records the filename and position",
+			input:    "(source-position (read-string \"\n  ; comment\n  (a)\" \"foo.mal\"))",
+			expected: `("foo.mal" 3 3)`,
+		},
+		{
+			name:     "columns count characters, not bytes",
+			input:    `(try* (do "é" (count 1)) (catch* e (stacktrace e)))`,
+			expected: `("count: (count 1) at 1:15")`,
+		},
+	}
+	runTests(t, cases)
+}
+
+func TestLocalVariables(t *testing.T) {
+	cases := []*TestCase{
+		{
+			name:     "closures capture their parameters",
+			input:    "(((fn* (a) (fn* (b) (+ a b))) 1) 2)",
+			expected: "3",
+		},
+		{
+			name:     "let* inside a function shadows parameters",
+			input:    "((fn* (a b) (let* (a 10 c (+ a b)) (+ a c))) 1 2)",
+			expected: "22",
+		},
+		{
+			name:     "rebinding a variable in the same let*",
+			input:    "((fn* () (let* (a 1 a (+ a 1)) a)))",
+			expected: "2",
+		},
+		{
+			name:     "let* values which refer to later bindings are looked up by name",
+			input:    "((fn* () (let* (f (fn* () g) g 5) (f))))",
+			expected: "5",
+		},
+		{
+			name:     "def! inside a function defines a local variable",
+			input:    "((fn* (a) (do (def! b (+ a 1)) (def! a 10) (+ a b))) 1)",
+			expected: "12",
+		},
+		{
+			name:     "catch* inside a function",
+			input:    "((fn* (a) (try* (abc) (catch* e (list a e)))) 1)",
+			expected: "(1 \"`abc` is undefined\")",
+		},
+		{
+			name: "macros are expanded with access to local variables",
+			input: `
+(do
+	(defmacro! unless (fn* (pred a b) (quasiquote (if (unquote pred) (unquote b) (unquote a)))))
+	((fn* (x) (unless x 1 2)) false))`,
+			expected: "1",
+		},
+		{
+			name: "parameters shadow macros",
+			input: `
+(do
+	(defmacro! unless (fn* (pred a b) (quasiquote (if (unquote pred) (unquote b) (unquote a)))))
+	((fn* (unless) (unless 1 2 3)) list))`,
+			expected: "(1 2 3)",
+		},
+		{
+			name:     "quasiquote inside a function",
+			input:    "((fn* (a) (quasiquote (a (unquote a) (splice-unquote (list a a))))) 1)",
+			expected: "(a 1 1 1)",
+		},
+	}
+	runTests(t, cases)
+}
+
+func TestMacros(t *testing.T) {
+	cases := []*TestCase{
+		{
+			name: "macros which expand to function calls",
+			input: `
+(do
+	(defmacro! inc (fn* (a) (list (quote +) a 1)))
+	(inc 2))`,
+			expected: "3",
+		},
+		{
+			name: "calling a macro defined after the function which calls it is an error",
+			input: `
+(do
+	(def! f (fn* () (g [1 2 3])))
+	(defmacro! g (fn* (x) (count x)))
+	(try* (f) (catch* e e)))`,
+			expected: `"g was defined as a macro after this call to it was compiled, so it wasn't expanded. Evaluate the definition of the function which makes the call again"`,
+		},
+		{
+			name: "redefining the function expands a macro defined after it",
+			input: `
+(do
+	(def! f (fn* () (g [1 2 3])))
+	(defmacro! g (fn* (x) (count x)))
+	(def! f (fn* () (g [1 2 3])))
+	(f))`,
+			expected: "3",
+		},
+		{
+			name: "macros which expand to function calls inside a function",
+			input: `
+(do
+	(defmacro! inc (fn* (a) (list (quote +) a 1)))
+	((fn* (a) (inc a)) 2))`,
+			expected: "3",
+		},
+		{
+			name: "tail calls inside macro expansions",
+			input: `
+(do
+	(defmacro! unless (fn* (pred a b) (quasiquote (if (unquote pred) (unquote b) (unquote a)))))
+	(def! count-to (fn* (n) (unless (= n 0) (count-to (- n 1)) n)))
+	(count-to 10000))`,
+			expected: "0",
+		},
+	}
+	runTests(t, cases)
+}
+
+func TestPrelude(t *testing.T) {
+	cases := []*TestCase{
+		// These mirror impls/tests/lib/threading.mal
+		{
+			name:     "-> with no forms",
+			input:    "(-> 7)",
+			expected: "7",
+		},
+		{
+			name:     "-> with a symbol",
+			input:    "(-> (list 7 8 9) first)",
+			expected: "7",
+		},
+		{
+			name:     "-> with a list",
+			input:    "(-> (list 7 8 9) (first))",
+			expected: "7",
+		},
+		{
+			name:     "-> inserts the value as the first arg",
+			input:    "(-> (list 7 8 9) first (+ 7))",
+			expected: "14",
+		},
+		{
+			name:     "-> with several forms",
+			input:    "(-> (list 7 8 9) rest (rest) first (+ 7))",
+			expected: "16",
+		},
+		{
+			name:     "->> with no forms",
+			input:    `(->> "L")`,
+			expected: `"L"`,
+		},
+		{
+			name:     "->> inserts the value as the last arg",
+			input:    `(->> "L" (+ "A") (+ "M"))`,
+			expected: `"MAL"`,
+		},
+		{
+			name:     "->> with several forms",
+			input:    "(->> (list 4) (concat (list 3)) (concat (list 2)) rest (concat (list 1)))",
+			expected: "(1 3 4)",
+		},
+		{
+			name:     "cond returns the first expression whose test is true",
+			input:    "(list (cond false 1 true 2 true 3) (cond false 1) (cond))",
+			expected: "(2 nil nil)",
+		},
+		{
+			name:     "and returns the first false value, or the last value",
+			input:    "(list (and) (and 1 2) (and 1 nil 2) (and false))",
+			expected: "(true 2 nil false)",
+		},
+		{
+			name:     "or returns the first true value",
+			input:    "(list (or) (or nil 2) (or false nil) (or 1 (abc)))",
+			expected: "(nil 2 nil 1)",
+		},
+		{
+			name: "and and or evaluate each form once",
+			input: `
+(let* (c (chan 10))
+	(do
+		(and (put! c 1) (put! c 2))
+		(or (take! c) (abc))
+		(list (take! c) (alts! (list c) 0))))`,
+			expected: "(2 nil)",
+		},
+		{
+			name:     "when evaluates its body if the test is true",
+			input:    "(list (when true 1 2) (when false (abc)))",
+			expected: "(2 nil)",
+		},
+		{
+			name:     "when-not evaluates its body if the test is false",
+			input:    "(list (when-not false 1 2) (when-not true (abc)))",
+			expected: "(2 nil)",
+		},
+		{
+			name:     "defn defines a function",
+			input:    "(do (defn add (a b) (prn a) (+ a b)) (add 1 2))",
+			expected: "3",
+		},
+		{
+			name:     "defn takes a docstring",
+			input:    `(do (defn add "Adds a and b" (a b) (+ a b)) (list (add 1 2) (get (meta add) :doc)))`,
+			expected: `(3 "Adds a and b")`,
+		},
+		{
+			name:     "let evaluates a body of several forms",
+			input:    "(let (a 1 b (+ a 1)) (prn a) (+ a b))",
+			expected: "3",
+		},
+		{
+			name:     "if-let binds the test",
+			input:    "(list (if-let (a (+ 1 2)) (+ a 1) 0) (if-let (a nil) 1 0) (if-let (a false) 1))",
+			expected: "(4 0 nil)",
+		},
+		{
+			name:     "doto calls each form with the value, and returns it",
+			input:    "(let* (c (chan 10)) (do (doto c (put! 1) (put! 2) close!) (list (take! c) (take! c) (take! c))))",
+			expected: "(1 2 nil)",
+		},
+		{
+			name:     "the prelude's definitions are documented",
+			input:    "(get (meta cond) :doc)",
+			expected: `"Takes pairs of tests and expressions. Returns the value of the expression of the first test which is true, or nil if none are"`,
+		},
+	}
+	runTests(t, cases)
+}
+
+func TestGensym(t *testing.T) {
+	cases := []*TestCase{
+		{
+			name:     "gensym returns a new symbol each time",
+			input:    `(list (= (gensym) (gensym)) (= (gensym "a") (gensym "a")))`,
+			expected: "(false false)",
+		},
+		{
+			name:          "gensym takes a string prefix",
+			input:         "(gensym 1)",
+			expextedError: fmt.Errorf("gensym takes a string prefix"),
+		},
+		{
+			name:     "auto-gensyms in a quasiquote are replaced with the same symbol",
+			input:    "(let* (form (quasiquote (a# a# a))) (list (= (nth form 0) (nth form 1)) (= (nth form 0) (quote a#))))",
+			expected: "(true false)",
+		},
+		{
+			name:     "auto-gensyms in different quasiquotes are different",
+			input:    "(= (quasiquote a#) (quasiquote a#))",
+			expected: "false",
+		},
+		{
+			name:     "auto-gensyms in unquoted code aren't replaced",
+			input:    "(let* (a# 1) (quasiquote (unquote a#)))",
+			expected: "1",
+		},
+		{
+			name: "auto-gensyms don't capture the macro's args",
+			input: `
+(do
+	(defmacro! add-one (fn* (x) (quasiquote (let* (one# 1) (+ (unquote x) one#)))))
+	(let* (one 10 one# 100) (list (add-one one) (add-one one#))))`,
+			expected: "(11 101)",
+		},
+		{
+			name:     "the prelude's macros are hygienic",
+			input:    "(let* (value 5) (list (or nil value) (and 1 value)))",
+			expected: "(5 5)",
+		},
+	}
+	runTests(t, cases)
+}
+
+func TestMacroexpand(t *testing.T) {
+	cases := []*TestCase{
+		{
+			name:     "macroexpand expands until the form isn't a macro call",
+			input:    "(macroexpand (-> a (b) c))",
+			expected: "(c (b a))",
+		},
+		{
+			name:     "macroexpand-1 expands once",
+			input:    "(macroexpand-1 (-> a (b) c))",
+			expected: "(-> (b a) c)",
+		},
+		{
+			name:     "macroexpand-1 leaves forms which aren't macro calls alone",
+			input:    "(macroexpand-1 (+ 1 2))",
+			expected: "(+ 1 2)",
+		},
+		{
+			name:     "macroexpand-all expands nested forms",
+			input:    "(macroexpand-all (when a (-> b c) (list (when-not d e))))",
+			expected: "(if a (do (c b) (list (if d nil (do e)))))",
+		},
+		{
+			name:     "macroexpand-all doesn't expand quoted forms",
+			input:    "(macroexpand-all (list (quote (when a b)) (quasiquote (when a (unquote (when b c))))))",
+			expected: "(list (quote (when a b)) (quasiquote (when a (unquote (if b (do c))))))",
+		},
+		{
+			name:     "macroexpand-all doesn't expand binding lists",
+			input:    "(macroexpand-all (let* (x (when a b)) (fn* (y) (when c d))))",
+			expected: "(let* (x (if a (do b))) (fn* (y) (if c (do d))))",
+		},
+		{
+			name:     "macroexpand-all doesn't expand calls to locals which shadow macros",
+			input:    "(macroexpand-all (let* (when (when a b)) (list (when c d) (fn* (x) (when c d)))))",
+			expected: "(let* (when (if a (do b))) (list (when c d) (fn* (x) (when c d))))",
+		},
+		{
+			name:     "fn*, catch* and destructuring bind locals which shadow macros",
+			input:    "(macroexpand-all (list (fn* (when) (when c d)) (fn* ((when) (when c)) ((a b) (when a b))) (fn* ({:keys [when]}) (when c)) (try* a (catch* when (when b c)))))",
+			expected: "(list (fn* (when) (when c d)) (fn* ((when) (when c)) ((a b) (if a (do b)))) (fn* ({:keys [when]}) (when c)) (try* a (catch* when (when b c))))",
+		},
+		{
+			name:     "macroexpand-all shows what the shadowed call runs",
+			input:    "((fn* (when) (list (macroexpand-all (when 1)) (when 1))) (fn* (x) (+ x 1)))",
+			expected: "((when 1) 2)",
+		},
+		{
+			name:     "macroexpand works in functions",
+			input:    "((fn* (a) (list (macroexpand-1 (when a b)) (macroexpand-all (when a b)))) 1)",
+			expected: "((if a (do b)) (if a (do b)))",
+		},
+		{
+			name:          "macroexpand-1 takes 1 arg",
+			input:         "(macroexpand-1 a b)",
+			expextedError: fmt.Errorf("macroexpand-1 takes 1 arg, got 2"),
+		},
+	}
+	runTests(t, cases)
+}
+
+func TestFormatExpansion(t *testing.T) {
+	for _, engine := range engines {
+		env := newTestEnv(t, engine)
+		ast, err := Read("(when a (-> b c))")
+		require.NoError(t, err)
+		expansion, err := formatExpansion(ast, env)
+		require.NoError(t, err)
+		assert.Equal(t, "0: (when a (-> b c))\n1: (if a (do (-> b c)))\nall: (if a (do (c b)))\n", expansion)
+
+		ast, err = Read("(-> a b)")
+		require.NoError(t, err)
+		expansion, err = formatExpansion(ast, env)
+		require.NoError(t, err)
+		assert.Equal(t, "0: (-> a b)\n1: (-> (b a))\n2: (b a)\n", expansion)
+	}
+}
+
+func TestDestructuring(t *testing.T) {
+	cases := []*TestCase{
+		{
+			name:     "let* destructures lists",
+			input:    "(let* ((a b & more) (list 1 2 3 4)) (list a b more))",
+			expected: "(1 2 (3 4))",
+		},
+		{
+			name:     "missing items are nil",
+			input:    "(let* ((a b & more) (list 1)) (list a b more))",
+			expected: "(1 nil ())",
+		},
+		{
+			name:     "nil destructures as an empty list",
+			input:    "(let* ((a b) nil) (list a b))",
+			expected: "(nil nil)",
+		},
+		{
+			name:     ":as binds the whole list",
+			input:    "(let* ((a :as all) (list 1 2)) (list a all))",
+			expected: "(1 (1 2))",
+		},
+		{
+			name:     "list patterns can be nested",
+			input:    "(let* ((a (b c) & (d)) (list 1 (list 2 3) 4)) (list a b c d))",
+			expected: "(1 2 3 4)",
+		},
+		{
+			name:     "vectors are list patterns",
+			input:    "(let* ([a [b c] & more :as all] (list 1 [2 3] 4)) (list a b c more all))",
+			expected: "(1 2 3 (4) (1 [2 3] 4))",
+		},
+		{
+			name:     "fn* destructures vector patterns",
+			input:    "((fn* ([a b]) (+ a b)) [1 2])",
+			expected: "3",
+		},
+		{
+			name:     ":keys takes a vector",
+			input:    `(let* ({:keys [host port]} {:host "localhost" :port 80}) (list host port))`,
+			expected: `("localhost" 80)`,
+		},
+		{
+			name:     "let* destructures hash maps",
+			input:    `(let* ({:keys (host port) :as m} {:host "localhost" :port 80 :path "/"}) (list host port (count (keys m))))`,
+			expected: `("localhost" 80 3)`,
+		},
+		{
+			name:     "hash map patterns can bind keys to symbols",
+			input:    `(let* ({h :host p "port"} {:host "localhost" "port" 80}) (list h p))`,
+			expected: `("localhost" 80)`,
+		},
+		{
+			name:     "hash maps can be nested in list patterns",
+			input:    "(let* ((a {:keys (b)}) (list 1 {:b 2})) (list a b))",
+			expected: "(1 2)",
+		},
+		{
+			name:     "later bindings can use destructured ones",
+			input:    "(let* ((a b) (list 1 2) c (+ a b)) c)",
+			expected: "3",
+		},
+		{
+			name:     "fn* destructures its args",
+			input:    "((fn* ((a b) {:keys (c)} & (d)) (list a b c d)) (list 1 2) {:c 3} 4)",
+			expected: "(1 2 3 4)",
+		},
+		{
+			name:     "destructuring works with defn",
+			input:    "(do (defn add-pair ((a b)) (+ a b)) (list (add-pair (list 1 2)) (get (meta add-pair) :arglists)))",
+			expected: "(3 (((a b))))",
+		},
+		{
+			name:     "destructuring a value with the wrong shape is an error",
+			input:    "(try* (let* ((a b) 1) a) (catch* e e))",
+			expected: `"can't destructure 1 with (a b): it isn't a list"`,
+		},
+		{
+			name:     "destructuring a hash map pattern checks for a hash map",
+			input:    "(try* ((fn* ({:keys (a)}) a) (list 1)) (catch* e e))",
+			expected: `"can't destructure (1) with {:keys (a)}: it isn't a hash map"`,
+		},
+		{
+			name:          "bindings must be symbols or patterns",
+			input:         "(let* (1 2) 1)",
+			expextedError: fmt.Errorf("can't bind 1: it isn't a symbol or a pattern"),
+		},
+		{
+			name:          "only :as can follow the rest of a list",
+			input:         "(let* ((a & b c) (list 1 2 3)) c)",
+			expextedError: fmt.Errorf("destructuring (a & b c): only :as can follow & and its pattern"),
+		},
+		{
+			name:          "nothing can follow :as after the rest of a list",
+			input:         "(let* ((a & b :as c d) (list 1 2 3)) c)",
+			expextedError: fmt.Errorf("destructuring (a & b :as c d): only :as can follow & and its pattern"),
+		},
+		{
+			name:          ":keys takes a list of symbols",
+			input:         "(let* ({:keys (1)} {}) 1)",
+			expextedError: fmt.Errorf("destructuring {:keys (1)}: :keys must be followed by a list of symbols"),
+		},
+	}
+	runTests(t, cases)
+}
+
+func TestMultiArity(t *testing.T) {
+	cases := []*TestCase{
+		{
+			name:     "calls run the arity which takes that many args",
+			input:    "(let* (f (fn* ((a) (list 1 a)) ((a b) (list 2 a b)))) (list (f 1) (f 1 2)))",
+			expected: "((1 1) (2 1 2))",
+		},
+		{
+			name:     "the variadic arity takes any other number of args",
+			input:    "(let* (f (fn* (() 0) ((a) 1) ((a b & more) more))) (list (f) (f 1) (f 1 2) (f 1 2 3 4)))",
+			expected: "(0 1 () (3 4))",
+		},
+		{
+			name:     "an arity can have several body forms, or none",
+			input:    "(let* (f (fn* ((a) (prn a) a) ((a b)))) (list (f 1) (f 1 2)))",
+			expected: "(1 nil)",
+		},
+		{
+			name:     "arities can call each other",
+			input:    "(do (def! count-down (fn* ((n) (count-down n 0)) ((n acc) (if (= n 0) acc (count-down (- n 1) (+ acc 1)))))) (count-down 10000))",
+			expected: "10000",
+		},
+		{
+			name:     "arities can destructure their args",
+			input:    "((fn* (((a b)) (+ a b)) ((a b) (* a b))) (list 2 3))",
+			expected: "5",
+		},
+		{
+			name:     "closures capture the env in every arity",
+			input:    "(let* (x 10 f (fn* ((a) (+ a x)) ((a b) (+ a b x)))) (list (f 1) (f 1 2)))",
+			expected: "(11 13)",
+		},
+		{
+			name:     "defn defines multi-arity functions",
+			input:    `(do (defn greet "Greets someone" ((name) (greet "Hello" name)) ((greeting name) (list greeting name))) (list (greet "you") (greet "Hi" "you") (get (meta greet) :arglists)))`,
+			expected: `(("Hello" "you") ("Hi" "you") ((name) (greeting name)))`,
+		},
+		{
+			name:     "calls with no matching arity are an error listing each arity",
+			input:    "(do (def! f (fn* ((a) a) ((a b) b))) (try* (f 1 2 3) (catch* e e)))",
+			expected: `"f takes args (a) or (a b), got 3"`,
+		},
+		{
+			name:     "too few args for the variadic arity are an error",
+			input:    "(try* ((fn* ((a) a) ((a b c & more) a)) 1 2) (catch* e e))",
+			expected: `"anonymous function takes args (a) or (a b c & more), got 2"`,
+		},
+		{
+			name:     "a destructured param and a body which is a call aren't arities",
+			input:    "(do (def! id (fn* (x) x)) ((fn* ((a b)) ((id +) a b)) (list 1 2)))",
+			expected: "3",
+		},
+		{
+			name:          "two arities can't take the same number of args",
+			input:         "(fn* ((a) a) ((b) b) ((a b) b))",
+			expextedError: fmt.Errorf("fn*: more than one parameter list takes 1 args"),
+		},
+		{
+			name:          "only one arity can be variadic",
+			input:         "(fn* ((& a) a) ((a & b) b))",
+			expextedError: fmt.Errorf("fn*: only one parameter list can have a & parameter"),
+		},
+		{
+			name:          "fixed arities can't take more args than the variadic one",
+			input:         "(fn* ((a & more) a) ((a b c) c))",
+			expextedError: fmt.Errorf("fn*: a parameter list can't take more args than the one with a & parameter"),
+		},
+	}
+	runTests(t, cases)
+}
+
+func TestLazySeqs(t *testing.T) {
+	cases := []*TestCase{
+		{
+			name:     "range with no args is infinite",
+			input:    "(take 5 (range))",
+			expected: "(0 1 2 3 4)",
+		},
+		{
+			name:     "range takes an end, a start and a step",
+			input:    "(list (range 3) (range 1 4) (range 10 0 -3) (range 3 1))",
+			expected: "((0 1 2) (1 2 3) (10 7 4 1) ())",
+		},
+		{
+			name:     "iterate, repeat and cycle",
+			input:    "(list (take 4 (iterate (fn* (a) (* a 2)) 1)) (take 2 (repeat :a)) (repeat 3 :b) (take 5 (cycle (list 1 2))) (cycle (list)))",
+			expected: "((1 2 4 8) (:a :a) (:b :b :b) (1 2 1 2 1) ())",
+		},
+		{
+			name:     "map, filter and take-while are lazy",
+			input:    "(take-while (fn* (a) (< a 20)) (filter (fn* (a) (= a (* 2 (/ a 2)))) (map (fn* (a) (* a 3)) (range))))",
+			expected: "(0 6 12 18)",
+		},
+		{
+			name:     "lazy-seq defines recursive sequences",
+			input:    "(do (def! from (fn* (n) (lazy-seq (cons n (from (+ n 1)))))) (take 3 (from 10)))",
+			expected: "(10 11 12)",
+		},
+		{
+			name:          "printing a lazy seq which fails to be realised is an error",
+			input:         "(map (fn* (a) (abc)) (range))",
+			expextedError: fmt.Errorf("`abc` is undefined"),
+		},
+		{
+			name:          "printing a collection holding a lazy seq which fails is an error",
+			input:         "[1 (take 3 (filter (fn* (a) (abc)) (range)))]",
+			expextedError: fmt.Errorf("`abc` is undefined"),
+		},
+		{
+			name:          "pp-str returns the error from realising a lazy seq",
+			input:         "(pp-str (map (fn* (a) (abc)) (list 1)))",
+			expextedError: fmt.Errorf("`abc` is undefined"),
+		},
+		{
+			name:     "items are only computed when they're needed",
+			input:    "(let* (s (map (fn* (a) a) (range))) (list (realized? s) (first s) (realized? s)))",
+			expected: "(false 0 true)",
+		},
+		{
+			name:     "items are computed once",
+			input:    "(let* (p (promise) s (lazy-seq (list (deliver p 1)))) (= (first s) (first s)))",
+			expected: "true",
+		},
+		{
+			name:     "drop, nth, rest and empty? only realise what they need",
+			input:    "(list (first (drop 5 (range))) (nth (range) 100) (first (rest (range))) (empty? (range)) (empty? (filter (fn* (a) false) (list 1 2))))",
+			expected: "(5 100 1 false true)",
+		},
+		{
+			name:     "count realises every item",
+			input:    "(count (range 100000))",
+			expected: "100000",
+		},
+		{
+			name:     "lazy seqs equal lists with the same items",
+			input:    "(list (= (take 3 (range)) (list 0 1 2)) (= (list 0 1) (take 2 (range))) (= (range) (list 0 1)) (= (take 2 (range)) (list 0 2)))",
+			expected: "(true true false false)",
+		},
+		{
+			name:     "cons and concat don't realise lazy seqs",
+			input:    "(list (take 3 (cons :a (range))) (take 4 (concat (list :a :b) (range))))",
+			expected: "((:a 0 1) (:a :b 0 1))",
+		},
+		{
+			name:     "lazy seqs can be destructured",
+			input:    "(let* ((a b & more) (range)) (list a b (first more)))",
+			expected: "(0 1 2)",
+		},
+		{
+			name:     "lazy seqs are seqs, but not lists",
+			input:    "(list (seq? (range)) (list? (range)) (seq? (list)) (seq? nil))",
+			expected: "(true false true false)",
+		},
+		{
+			name:     "errors computing items are raised when they're realised",
+			input:    "(let* (s (map (fn* (a) (nth (list) a)) (range))) (try* (doall (take 1 s)) (catch* e e)))",
+			expected: `"nth: index 0 out of range for a list of 0 items"`,
+		},
+	}
+	runTests(t, cases)
+}
+
+func TestLineSeq(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "lines.txt")
+	require.NoError(t, os.WriteFile(filename, []byte("one\ntwo\nthree\n"), 0o644))
+
+	for _, engine := range engines {
+		env := newTestEnv(t, engine)
+		actual, err := Rep(fmt.Sprintf(`(list (take 2 (line-seq "%s")) (count (line-seq "%s")))`, filename, filename), env)
+		require.NoError(t, err)
+		assert.Equal(t, `(("one" "two") 3)`, actual)
+	}
+}
+
+func TestMap(t *testing.T) {
+	cases := []*TestCase{
+		{
+			name:     "map applies a function to each item, keeping their order",
+			input:    "(map (fn* (a) (* a a)) (list 1 2 3 4 5 6 7 8 9 10))",
+			expected: "(1 4 9 16 25 36 49 64 81 100)",
+		},
+		{
+			name:     "map works with builtins",
+			input:    "(map count (list (list 1) (list) (list 1 2)))",
+			expected: "(1 0 2)",
+		},
+		{
+			name:     "map over an empty list",
+			input:    "(map (fn* (a) a) (list))",
+			expected: "()",
+		},
+		{
+			name:     "map over nil",
+			input:    "(map (fn* (a) a) nil)",
+			expected: "()",
+		},
+		{
+			name: "map calls functions which recurse",
+			input: `
+(do
+	(def! fib (fn* (n) (if (<= n 1) n (+ (fib (- n 1)) (fib (- n 2))))))
+	(map fib (list 10 11 12 13 14 15)))`,
+			expected: "(55 89 144 233 377 610)",
+		},
+		{
+			name:     "map returns the error from a failed call",
+			input:    "(try* (map count (list (list) 1 (list))) (catch* e e))",
+			expected: `"first argument to count isn't a list"`,
+		},
+		{
+			name:     "map cancels a call which never returns when another fails",
+			input:    "(try* (pmap 2 (fn* (x) (if (= x 1) (loop* (i 0) (recur (+ i 1))) (abc))) (list 1 2)) (catch* e e))",
+			expected: `"` + "`abc`" + ` is undefined"`,
+		},
+		{
+			name:     "map returns an error without waiting for a blocked call",
+			input:    "(let* (c (chan)) (try* (pmap 2 (fn* (x) (if (= x 1) (take! c) (abc))) (list 1 2)) (catch* e e)))",
+			expected: `"` + "`abc`" + ` is undefined"`,
+		},
+		{
+			name: "functions called by map can define globals",
+			input: `
+(do
+	(def! x 0)
+	(def! set-x (fn* (n) (eval (list (quote def!) (quote x) n))))
+	(map set-x (list 1 2 3 4 5 6 7 8 9 10 11 12 13 14 15 16))
+	(> x 0))`,
+			expected: "true",
+		},
+		{
+			name:          "map takes a function",
+			input:         "(map 1 (list 1))",
+			expextedError: fmt.Errorf("map takes a function"),
+		},
+		{
+			name:     "pmap limits the number of calls run at once",
+			input:    "(pmap 2 (fn* (a) (+ a 1)) (list 1 2 3 4 5))",
+			expected: "(2 3 4 5 6)",
+		},
+		{
+			name:     "pmap with one worker",
+			input:    "(pmap 1 (fn* (a) (+ a 1)) (list 1 2 3))",
+			expected: "(2 3 4)",
+		},
+		{
+			name:          "pmap takes a positive number of workers",
+			input:         "(pmap 0 (fn* (a) a) (list 1 2 3))",
+			expextedError: fmt.Errorf("pmap takes a positive number of workers as its first argument"),
+		},
+	}
+	runTests(t, cases)
+}
+
+func TestHashMaps(t *testing.T) {
+	cases := []*TestCase{
+		{
+			name:     "keywords evaluate to themselves",
+			input:    "(list :a (keyword \"b\") (keyword? :a) (keyword? \"a\"))",
+			expected: "(:a :b true false)",
+		},
+		{
+			name:     "hash map literals evaluate their keys and values",
+			input:    `{:a (+ 1 1) "b" (list 1)}`,
+			expected: `{:a 2 "b" (1)}`,
+		},
+		{
+			name:     "hash map literal keys are evaluated like values",
+			input:    "(let* (k 1) (list {k 2} (get {(+ 1 1) :x [k] :y} 2) ((fn* (a) {a a}) :a)))",
+			expected: "({1 2} :x {:a :a})",
+		},
+		{
+			name:     "hash map literals inside functions",
+			input:    "((fn* (a) {:a a :b (+ a 1)}) 1)",
+			expected: "{:a 1 :b 2}",
+		},
+		{
+			name:     "get returns the value of a key, or nil",
+			input:    "(let* (m (hash-map :a 1)) (list (get m :a) (get m :b) (get nil :a)))",
+			expected: "(1 nil nil)",
+		},
+		{
+			name:     "assoc and dissoc return new maps",
+			input:    "(let* (m {:a 1} n (assoc m :b 2 :a 3)) (list m n (dissoc n :a)))",
+			expected: "({:a 1} {:a 3 :b 2} {:b 2})",
+		},
+		{
+			name:     "contains?, keys and vals",
+			input:    "(let* (m {:a 1 :b 2}) (list (contains? m :a) (contains? m :c) (keys m) (vals m)))",
+			expected: "(true false (:a :b) (1 2))",
+		},
+		{
+			name:     "maps are equal if they have the same keys and values",
+			input:    "(list (= {:a 1 :b 2} {:b 2 :a 1}) (= {:a 1} {:a 2}) (map? {}) (map? (list)))",
+			expected: "(true false true false)",
+		},
+		{
+			name:     "collections can be keys",
+			input:    "(let* (m (hash-map (list 1 2) :list {:a #{1}} :map)) (list (get m [1 2]) (get m {:a #{1}}) (get m (list 2 1))))",
+			expected: "(:list :map nil)",
+		},
+		{
+			name:          "functions can't be keys",
+			input:         "(hash-map (fn* () 1) 1)",
+			expextedError: fmt.Errorf("#<function> can't be used as a hash map key"),
+		},
+	}
+	runTests(t, cases)
+}
+
+func TestVectors(t *testing.T) {
+	cases := []*TestCase{
+		{
+			name:     "vector literals evaluate their items",
+			input:    "(list [1 (+ 1 1) (list 3)] [] (vector 1 2) (vec (list 1 2)) (vec (range 3)))",
+			expected: "([1 2 (3)] [] [1 2] [1 2] [0 1 2])",
+		},
+		{
+			name:     "vector literals inside functions",
+			input:    "((fn* (a) [a (+ a 1)]) 1)",
+			expected: "[1 2]",
+		},
+		{
+			name:     "vectors are sequences",
+			input:    "(let* (v [1 2 3]) (list (count v) (first v) (rest v) (nth v 2) (empty? []) (map (fn* (a) (* a 2)) v) (take 2 v)))",
+			expected: "(3 1 (2 3) 3 true (2 4 6) (1 2))",
+		},
+		{
+			name:     "vector?, list?, seq? and sequential?",
+			input:    "(list (vector? [1]) (vector? (list 1)) (list? [1]) (seq? [1]) (sequential? [1]) (sequential? (list)) (sequential? {}))",
+			expected: "(true false false false true true false)",
+		},
+		{
+			name:     "vectors are equal to lists with the same items",
+			input:    "(list (= [1 2] [1 2]) (= [1 2] (list 1 2)) (= (list 1 2) [1 2]) (= [1 2] [1 3]) (= [[1]] (list (list 1))) (= [0 1] (range 2)))",
+			expected: "(true true true false true true)",
+		},
+		{
+			name:     "conj adds to the end of a vector, and the front of a list",
+			input:    "(list (conj [1 2] 3 4) (conj (list 1 2) 3 4) (conj nil 1) (conj {:a 1} [:b 2]))",
+			expected: "([1 2 3 4] (4 3 1 2) (1) {:a 1 :b 2})",
+		},
+		{
+			name:     "assoc, get and contains? use indices",
+			input:    "(let* (v [:a :b]) (list (assoc v 0 :x) (assoc v 2 :c) (get v 1) (get v 2) (contains? v 1) (contains? v 2)))",
+			expected: "([:x :b] [:a :b :c] :b nil true false)",
+		},
+		{
+			name:          "assoc past the end of a vector",
+			input:         "(assoc [1] 2 :a)",
+			expextedError: fmt.Errorf("assoc: index 2 out of range for a vector of 1 items"),
+		},
+		{
+			name:     "cons and concat return lists",
+			input:    "(list (cons 0 [1 2]) (concat [1] (list 2) [3]))",
+			expected: "((0 1 2) (1 2 3))",
+		},
+		{
+			name:     "quasiquote keeps vectors",
+			input:    "(let* (a 1 b (list 2 3)) (quasiquote [0 (unquote a) (splice-unquote b) []]))",
+			expected: "[0 1 2 3 []]",
+		},
+		{
+			name:     "vectors can be destructured",
+			input:    "(let* ((a & more) [1 2 3] {:keys (b)} {:b [4]}) (list a more b))",
+			expected: "(1 (2 3) [4])",
+		},
+		{
+			name:     "vectors can have metadata",
+			input:    "(meta (with-meta [1] {:a 1}))",
+			expected: "{:a 1}",
+		},
+		{
+			name:     "macros can build vectors",
+			input:    "(do (defmacro! pair (fn* (a b) [a b])) (pair (+ 1 1) 3))",
+			expected: "[2 3]",
+		},
+	}
+	runTests(t, cases)
+}
+
+func TestPersistentCollections(t *testing.T) {
+	cases := []*TestCase{
+		{
+			name:     "updating a collection doesn't change the original",
+			input:    "(let* (l (list 1) v [1] m {:a 1}) (list (cons 0 l) (conj v 2) (assoc v 0 0) (assoc m :b 2) l v m))",
+			expected: "((0 1) [1 2] [0] {:a 1 :b 2} (1) [1] {:a 1})",
+		},
+		{
+			name:     "cons shares the rest of the list",
+			input:    "(let* (l (list 2 3) a (cons 1 l) b (cons 0 l)) (list a b (rest a) (= (rest a) (rest b))))",
+			expected: "((1 2 3) (0 2 3) (2 3) true)",
+		},
+		{
+			name:     "building big collections an item at a time",
+			input:    "(loop (n 0 l () v [] m {}) (if (< n 20000) (recur (+ n 1) (cons n l) (conj v n) (assoc m n n)) (list (count l) (first l) (count v) (nth v 19999) (count m) (get m 12345))))",
+			expected: "(20000 19999 20000 19999 20000 12345)",
+		},
+		{
+			name:     "hash maps keep the order keys were added in",
+			input:    "(let* (m (assoc (dissoc (hash-map :a 1 :b 2 :c 3) :b) :d 4 :a 5)) (list m (keys m)))",
+			expected: "({:a 5 :c 3 :d 4} (:a :c :d))",
+		},
+	}
+	runTests(t, cases)
+}
+
+func TestSets(t *testing.T) {
+	cases := []*TestCase{
+		{
+			name:     "set literals evaluate their items and drop duplicates",
+			input:    "(let* (a 1) #{a 2 (+ a 2) (+ a 1)})",
+			expected: "#{1 2 3}",
+		},
+		{
+			name:     "set",
+			input:    "(list (set (list 1 2 1)) (set [3 3]) (set ()))",
+			expected: "(#{1 2} #{3} #{})",
+		},
+		{
+			name:     "set?",
+			input:    "(list (set? #{}) (set? [1]) (set? {}) (set? nil))",
+			expected: "(true false false false)",
+		},
+		{
+			name:     "conj and disj",
+			input:    "(let* (s #{1 2}) (list (conj s 3 1) (disj s 1 4) s))",
+			expected: "(#{1 2 3} #{2} #{1 2})",
+		},
+		{
+			name:     "contains?, get and count",
+			input:    "(let* (s #{:a :b}) (list (contains? s :a) (contains? s :c) (get s :b) (get s :c) (count s) (empty? s) (empty? #{})))",
+			expected: "(true false :b nil 2 false true)",
+		},
+		{
+			name:     "set operations",
+			input:    "(list (set/union #{1 2} #{2 3} nil) (set/intersection #{1 2 3} #{2 3 4} #{3 2}) (set/difference #{1 2 3} #{2} #{3}) (set/subset? #{1} #{1 2}) (set/subset? #{1 3} #{1 2}))",
+			expected: "(#{1 2 3} #{2 3} #{1} true false)",
+		},
+		{
+			name:     "sets are equal if they have the same items",
+			input:    "(list (= #{1 2} #{2 1}) (= #{1 2} #{1 2 3}) (= #{1} [1]) (= #{} #{}))",
+			expected: "(true false false true)",
+		},
+		{
+			name:     "items are the same if they're =",
+			input:    "(list (contains? #{[1 2]} (list 1 2)) (count #{[1 2] (list 1 2) {:a 1} {:a 1}}) (contains? #{#{1 2}} #{2 1}))",
+			expected: "(true 2 true)",
+		},
+		{
+			name:     "sets can be keys",
+			input:    "(get {#{1 2} :found} #{2 1})",
+			expected: ":found",
+		},
+		{
+			name:     "sets are sequences",
+			input:    "(list (map (fn* (x) (* x 2)) #{1 2 3}) (filter (fn* (x) (> x 1)) #{1 2 3}) (first #{4 5}) (rest #{4 5}))",
+			expected: "((2 4 6) (2 3) 4 (5))",
+		},
+		{
+			name:     "sets can have metadata",
+			input:    "(let* (s (with-meta #{1} {:a 1})) (list (meta s) (meta (conj s 2))))",
+			expected: "({:a 1} {:a 1})",
+		},
+		{
+			name:     "quasiquoting a set",
+			input:    "(let* (a 1 b (list 2 3)) (quasiquote #{(unquote a) (splice-unquote b)}))",
+			expected: "#{1 2 3}",
+		},
+		{
+			name:          "functions can't be in a set",
+			input:         "#{(fn* () 1)}",
+			expextedError: fmt.Errorf("#<function> can't be in a set"),
+		},
+		{
+			name:          "disj takes a set",
+			input:         "(disj [1 2] 1)",
+			expextedError: fmt.Errorf("disj takes a set"),
+		},
+	}
+	runTests(t, cases)
+}
+
+func TestChars(t *testing.T) {
+	cases := []*TestCase{
+		{
+			name:     "character literals",
+			input:    "(list \\a \\é \\( \\newline \\space \\u00e9)",
+			expected: "(\\a \\é \\( \\newline \\space \\é)",
+		},
+		{
+			name:     "char? and equality",
+			input:    "(list (char? \\a) (char? \"a\") (= \\a \\a) (= \\a \\b) (= \\a \"a\"))",
+			expected: "(true false true false false)",
+		},
+		{
+			name:     "char and int convert between characters and code points",
+			input:    "(list (int \\a) (int \\é) (char 233) (char (+ (int \\a) 1)) (int 5))",
+			expected: "(97 233 \\é \\b 5)",
+		},
+		{
+			name:          "char takes a valid code point",
+			input:         "(char -1)",
+			expextedError: fmt.Errorf("char: -1 isn't a valid code point"),
+		},
+		{
+			name:     "string/codepoints",
+			input:    "(string/codepoints \"héllo\")",
+			expected: "(104 233 108 108 111)",
+		},
+		{
+			name:     "strings are counted in characters",
+			input:    "(list (count \"héllo\") (count \"日本語\") (count \"\") (empty? \"\") (empty? \"a\"))",
+			expected: "(5 3 0 true false)",
+		},
+		{
+			name:     "strings are sequences of characters",
+			input:    "(list (first \"éa\") (rest \"日本語\") (nth \"日本語\" 2) (map int \"ab\") (vec \"ab\") (filter (fn* (c) (= c \\l)) \"hello\"))",
+			expected: "(\\é (\\本 \\語) \\語 (97 98) [\\a \\b] (\\l \\l))",
+		},
+		{
+			name:          "nth on a string counts characters",
+			input:         "(nth \"日本語\" 3)",
+			expextedError: fmt.Errorf("nth: index 3 out of range for a string of 3 characters"),
+		},
+		{
+			name:     "characters can be keys",
+			input:    "(list (get {\\a 1} \\a) (set \"hello\"))",
+			expected: "(1 #{\\h \\e \\l \\o})",
+		},
+	}
+	runTests(t, cases)
+}
+
+func TestPrettyPrinting(t *testing.T) {
+	cases := []*TestCase{
+		{
+			name:     "values which fit are printed on one line",
+			input:    "(pp-str (quote (7 8 9 \"ten\" [11 12 [13 14]] 15 16)))",
+			expected: `"(7 8 9 "ten" [11 12 [13 14]] 15 16)"`,
+		},
+		// These are the styles from impls/tests/lib/pprint.mal, which breaks
+		// every collection
+		{
+			name:  "items line up with the first one",
+			input: "(pp-str (quote (7 8 9 \"ten\" [11 12 [13 14]] 15 16)) {:width 0})",
+			expected: `"(7
+ 8
+ 9
+ "ten"
+ [11
+  12
+  [13
+   14]]
+ 15
+ 16)"`,
+		},
+		{
+			name:  "hash map values line up after their keys",
+			input: "(pp-str (quote {:abc 123 :def {:ghi 456 :jkl [789 \"ten eleven twelve\"]}}) {:width 0})",
+			expected: `"{:abc 123
+ :def {:ghi 456
+       :jkl [789
+             "ten eleven twelve"]}}"`,
+		},
+		{
+			name:  "hash maps in lists",
+			input: "(pp-str (quote (7 8 {:abc 123 :def {:ghi 456 :jkl 789}} 9 10 [11 12 [13 14]] 15 16)) {:width 0})",
+			expected: `"(7
+ 8
+ {:abc 123
+  :def {:ghi 456
+        :jkl 789}}
+ 9
+ 10
+ [11
+  12
+  [13
+   14]]
+ 15
+ 16)"`,
+		},
+		{
+			name:  "only collections which don't fit are broken up",
+			input: "(pp-str [[1 2 3] [4 5 6] [7 8 9]] {:width 12})",
+			expected: `"[[1 2 3]
+ [4 5 6]
+ [7 8 9]]"`,
+		},
+		{
+			name:  "the arguments of calls are indented",
+			input: "(pp-str (quote (def! fib (fn* (N) (if (= N 0) 1 (+ N (fib (- N 1))))))) {:width 30})",
+			expected: `"(def! fib
+  (fn* (N)
+    (if (= N 0)
+      1
+      (+ N (fib (- N 1))))))"`,
+		},
+		{
+			name:  "indent",
+			input: "(pp-str (quote (do (a) (b))) {:width 0 :indent 4})",
+			expected: `"(do (a)
+    (b))"`,
+		},
+		{
+			name:     "*print-length*",
+			input:    "(do (def! *print-length* 3) (list (pp-str (range)) (pp-str [1 2 3]) (pp-str {:a 1 :b 2 :c 3 :d 4})))",
+			expected: `("(0 1 2 ...)" "[1 2 3]" "{:a 1 :b 2 :c 3 ...}")`,
+		},
+		{
+			name:     "*print-level*",
+			input:    "(do (def! *print-level* 2) (list (pp-str [1 [2 [3 [4]]] {:a {:b 1}}]) (pp-str 1)))",
+			expected: `("[1 [2 #] {:a #}]" "1")`,
+		},
+		{
+			name:     "printing a lazy seq which contains itself",
+			input:    "(do (def! ones (lazy-seq (cons 1 ones))) (def! s (lazy-seq (list 1 s))) (list ones s (pp-str ones)))",
+			expected: `((1 #<cycle>) (1 #<cycle>) "(1 #<cycle>)")`,
+		},
+		{
+			name:          "*print-length* must be a number",
+			input:         "(do (def! *print-length* :a) (pp-str 1))",
+			expextedError: fmt.Errorf("pp-str: *print-length* must be nil or a number"),
+		},
+		{
+			name:          "pprint takes a hash map of options",
+			input:         "(pprint 1 2)",
+			expextedError: fmt.Errorf("pprint takes a hash map of options"),
+		},
+	}
+	runTests(t, cases)
+}
+
+func TestMetadata(t *testing.T) {
+	cases := []*TestCase{
+		{
+			name:     "values have no metadata by default",
+			input:    "(meta (list 1))",
+			expected: "nil",
+		},
+		{
+			name:     "with-meta attaches metadata to a copy",
+			input:    "(let* (a (list 1) b (with-meta a {:a 1})) (list (meta a) (meta b) (= a b)))",
+			expected: "(nil {:a 1} true)",
+		},
+		{
+			name:     "^ is shorthand for with-meta",
+			input:    "(meta ^{:a 1} (fn* () 1))",
+			expected: "{:a 1}",
+		},
+		{
+			name:     "vary-meta calls a function on the metadata",
+			input:    "(meta (vary-meta ^{:a 1} (hash-map) assoc :b 2))",
+			expected: "{:a 1 :b 2}",
+		},
+		{
+			name:     "symbols can have metadata",
+			input:    "(meta (with-meta (quote a) {:a 1}))",
+			expected: "{:a 1}",
+		},
+		{
+			name:     "def! records the function's name and position",
+			input:    "(do (def! f (fn* () 1)) (meta f))",
+			expected: `{:name "f" :arglists (()) :line 1 :column 11}`,
+		},
+		{
+			name:     "def! keeps existing metadata",
+			input:    `(do (def! f ^{:doc "does things"} (fn* () 1)) (meta f))`,
+			expected: `{:doc "does things" :name "f" :arglists (()) :line 1 :column 11}`,
+		},
+		{
+			name: "def! records the position inside functions",
+			input: `
+(do
+	(def! make (fn* () (def! g (fn* () 1))))
+	(meta (make)))`,
+			expected: `{:name "g" :arglists (()) :line 3 :column 27}`,
+		},
+		{
+			name:     "def! only names a function once",
+			input:    "(do (def! f (fn* () 1)) (def! g f) (get (meta g) :name))",
+			expected: `"f"`,
+		},
+	}
+	runTests(t, cases)
+}
+
+func TestDocs(t *testing.T) {
+	cases := []*TestCase{
+		{
+			name:     "builtins have docstrings",
+			input:    "(get (meta count) :doc)",
+			expected: `"Returns the number of items in list. (count nil) is 0"`,
+		},
+		{
+			name:     "builtins have arglists",
+			input:    "(get (meta read-string) :arglists)",
+			expected: "((s) (s filename))",
+		},
+		{
+			name:     "def! takes a docstring",
+			input:    `(do (def! add1 "Adds 1 to a" (fn* (a) (+ a 1))) (meta add1))`,
+			expected: `{:doc "Adds 1 to a" :name "add1" :arglists ((a)) :line 1 :column 11}`,
+		},
+		{
+			name:     "the value is still defined",
+			input:    `(do (def! add1 "Adds 1 to a" (fn* (a) (+ a 1))) (add1 2))`,
+			expected: "3",
+		},
+		{
+			name:     "defmacro! takes a docstring",
+			input:    `(do (defmacro! ignore "Ignores its arg" (fn* (a) nil)) (list (ignore (abc)) (get (meta ignore) :doc)))`,
+			expected: `(nil "Ignores its arg")`,
+		},
+		{
+			name:     "docstrings can be added to lists",
+			input:    `(do (def! l "A list" (list 1 2)) (list l (meta l)))`,
+			expected: `((1 2) {:doc "A list"})`,
+		},
+		{
+			name:          "docstrings can't be added to numbers",
+			input:         `(def! a "A number" 1)`,
+			expextedError: fmt.Errorf("can't add a docstring to 1"),
+		},
+		{
+			name:          "docstrings must be strings",
+			input:         `(def! a 1 2)`,
+			expextedError: fmt.Errorf("def!: docstring isn't a string"),
+		},
+		{
+			name:     "doc returns nil",
+			input:    "(doc count)",
+			expected: "nil",
+		},
+		{
+			name:     "dir lists every symbol",
+			input:    `(list (empty? (dir)) (= (dir) (apropos "")))`,
+			expected: "(false true)",
+		},
+		{
+			name:     "apropos finds symbols",
+			input:    `(apropos "map")`,
+			expected: "(hash-map map map? pmap)",
+		},
+		{
+			name:     "apropos finds new definitions",
+			input:    `(do (def! my-map 1) (apropos "map"))`,
+			expected: "(hash-map map map? my-map pmap)",
+		},
+		{
+			name:     "apropos finds let* bindings",
+			input:    `(let* (zzzlocal 1) (apropos "zzzlocal"))`,
+			expected: "(zzzlocal)",
+		},
+		{
+			name:     "apropos finds the params of the function it's called in",
+			input:    `(do (def! f (fn* (zzzparam) (let* (zzzlet 2) (apropos (str-zzz))))) (def! str-zzz (fn* () "zzz")) (f 1))`,
+			expected: "(str-zzz zzzlet zzzparam)",
+		},
+		{
+			name:     "dir lists local variables",
+			input:    `((fn* (zzzparam) (= (dir) (apropos ""))) 1)`,
+			expected: "true",
+		},
+		{
+			name:     "passed as a function, apropos only searches globals",
+			input:    `((fn* (zzzparam) ((fn* (f) (f "zzzparam")) apropos)) 1)`,
+			expected: "()",
+		},
+		{
+			name:          "apropos takes a string",
+			input:         "(apropos 1)",
+			expextedError: fmt.Errorf("apropos takes a string"),
+		},
+	}
+	runTests(t, cases)
+}
+
+func TestFormatDoc(t *testing.T) {
+	for _, engine := range engines {
+		env := newTestEnv(t, engine)
+		for _, c := range []struct {
+			input    string
+			expected string
+		}{
+			{
+				input:    "read-string",
+				expected: "read-string\n(read-string s)\n(read-string s filename)\n  Reads the first form in the string s. filename is recorded in the positions of the forms read\n",
+			},
+			{
+				input:    `(def! add1 "Adds 1 to a" (fn* (a) (+ a 1)))`,
+				expected: "add1\n(add1 a)\n  Adds 1 to a\n",
+			},
+			{
+				input:    "future",
+				expected: "future\nMacro\n(future & body)\n  Evaluates body on a new goroutine. Returns a future, which is delivered with the value of its last form\n",
+			},
+			{
+				input:    "(fn* (a) a)",
+				expected: "#<function>\n  No documentation\n",
+			},
+			{
+				input:    `(def! greet "Greets someone" (fn* ((name) name) ((greeting name) greeting)))`,
+				expected: "greet\n(greet name)\n(greet greeting name)\n  Greets someone\n",
+			},
+		} {
+			ast, err := Read(c.input)
+			require.NoError(t, err)
+			value, err := Eval(ast, env)
+			require.NoError(t, err)
+			assert.Equal(t, c.expected, formatDoc(value))
+		}
+	}
+}
+
+func TestErrorValues(t *testing.T) {
+	cases := []*TestCase{
+		{
+			name:     "error creates an error value",
+			input:    `(error "not found")`,
+			expected: "#<error: not found>",
+		},
+		{
+			name:     "error? reports whether a value is an error",
+			input:    `(list (error? (error "not found")) (error? 1) (error? nil))`,
+			expected: "(true false false)",
+		},
+		{
+			name:     "error-message returns an error's message",
+			input:    `(error-message (error "not found"))`,
+			expected: `"not found"`,
+		},
+		{
+			name:          "builtins raise an exception if they're passed an error",
+			input:         `(+ 1 (error "not found"))`,
+			expextedError: fmt.Errorf("+ was passed an error value: not found"),
+		},
+		{
+			name:     "the exception includes the error's message",
+			input:    `(try* (count (error "not found")) (catch* e e))`,
+			expected: `"count was passed an error value: not found"`,
+		},
+		{
+			name:     "some builtins accept errors",
+			input:    `(list (= (error "a") (error "a")) (cons (error "a") (list)))`,
+			expected: "(true (#<error: a>))",
+		},
+		{
+			name: "lisp functions can return errors",
+			input: `
+(do
+	(def! safe-div (fn* (a b) (if (= b 0) (error "divide by zero") (/ a b))))
+	(list (safe-div 6 2) (safe-div 1 0)))`,
+			expected: "(3 #<error: divide by zero>)",
+		},
+		{
+			name:     "ok-> threads a value through forms",
+			input:    "(ok-> 1 (+ 2) (* 3) list)",
+			expected: "(9)",
+		},
+		{
+			name: "ok-> stops at the first error",
+			input: `
+(do
+	(def! safe-div (fn* (a b) (if (= b 0) (error "divide by zero") (/ a b))))
+	(list (ok-> 12 (safe-div 2) (safe-div 3)) (ok-> 12 (safe-div 0) (safe-div 3))))`,
+			expected: "(2 #<error: divide by zero>)",
+		},
+		{
+			name:     "ok-> returns an error value it starts with",
+			input:    `(ok-> (error "oops") (+ 1))`,
+			expected: "#<error: oops>",
+		},
+		{
+			name:     "ok-> doesn't capture variables used in its forms",
+			input:    "(let* (v 10) (ok-> 1 (+ v) (- v)))",
+			expected: "1",
+		},
+		{
+			name:     "ok-> inside a function",
+			input:    "((fn* (a) (ok-> a (+ 1) (* a))) 2)",
+			expected: "6",
+		},
+	}
+	runTests(t, cases)
+}
+
+func TestFutures(t *testing.T) {
+	cases := []*TestCase{
+		{
+			name:     "future evaluates its body, and deref returns the result",
+			input:    "(deref (future (+ 1 2)))",
+			expected: "3",
+		},
+		{
+			name: "futures run in parallel",
+			input: `
+(do
+	(def! fib (fn* (n) (if (<= n 1) n (+ (fib (- n 1)) (fib (- n 2))))))
+	(def! a (future (fib 15)))
+	(def! b (future (fib 16)))
+	(list (deref a) (deref b)))`,
+			expected: "(610 987)",
+		},
+		{
+			name:     "future and go take several forms",
+			input:    "(let* (c (chan 1)) (list (deref (future (put! c 1) (take! c))) (deref (go (put! c 2) (take! c)))))",
+			expected: "(1 2)",
+		},
+		{
+			name:     "deref returns the error from a failed future",
+			input:    "(try* (deref (future (count 1))) (catch* e e))",
+			expected: `"first argument to count isn't a list"`,
+		},
+		{
+			name: "a future can wait for a promise",
+			input: `
+(do
+	(def! p (promise))
+	(def! f (future (+ 1 (deref p))))
+	(deliver p 10)
+	(deref f))`,
+			expected: "11",
+		},
+		{
+			name:     "promises can only be delivered once",
+			input:    "(let* (p (promise) a (deliver p 1) b (deliver p 2)) (list (deref p) b))",
+			expected: "(1 nil)",
+		},
+		{
+			name:     "realized? reports whether a promise has been delivered",
+			input:    "(let* (p (promise) before (realized? p)) (do (deliver p 1) (list before (realized? p))))",
+			expected: "(false true)",
+		},
+		{
+			name:     "deref returns the timeout value if the promise isn't delivered in time",
+			input:    `(deref (promise) 10 "timed out")`,
+			expected: `"timed out"`,
+		},
+		{
+			name:     "deref with a timeout returns the value if it's delivered in time",
+			input:    `(deref (future 1) 1000 "timed out")`,
+			expected: "1",
+		},
+	}
+	runTests(t, cases)
+}
+
+func TestChannels(t *testing.T) {
+	cases := []*TestCase{
+		{
+			name:     "values put on a buffered channel can be taken",
+			input:    "(let* (c (chan 2) a (put! c 1) b (put! c 2)) (list a b (take! c) (take! c)))",
+			expected: "(true true 1 2)",
+		},
+		{
+			name: "unbuffered channels pass values between goroutines",
+			input: `
+(do
+	(def! c (chan))
+	(go (put! c 1))
+	(take! c))`,
+			expected: "1",
+		},
+		{
+			name: "a pipeline of goroutines",
+			input: `
+(do
+	(def! produce (fn* (out n) (if (= n 0) (close! out) (do (put! out n) (produce out (- n 1))))))
+	(def! square (fn* (in out)
+		(let* (v (take! in))
+			(if (= v nil) (close! out) (do (put! out (* v v)) (square in out))))))
+	(def! collect (fn* (in acc)
+		(let* (v (take! in))
+			(if (= v nil) acc (collect in (cons v acc))))))
+	(def! numbers (chan))
+	(def! squares (chan))
+	(go (produce numbers 4))
+	(go (square numbers squares))
+	(collect squares (list)))`,
+			expected: "(1 4 9 16)",
+		},
+		{
+			name:     "taking from a closed channel returns its buffered values, then nil",
+			input:    "(let* (c (chan 1) a (put! c 1) b (close! c)) (list (take! c) (take! c)))",
+			expected: "(1 nil)",
+		},
+		{
+			name:     "putting on a closed channel returns false",
+			input:    "(let* (c (chan 1) a (close! c)) (put! c 1))",
+			expected: "false",
+		},
+		{
+			name:          "nil can't be put on a channel",
+			input:         "(put! (chan 1) nil)",
+			expextedError: fmt.Errorf("can't put nil on a channel"),
+		},
+		{
+			name: "alts! takes from whichever channel is ready",
+			input: `
+(let* (a (chan 1) b (chan 1) c (put! b 2) result (alts! (list a b)))
+	(= result (list 2 b)))`,
+			expected: "true",
+		},
+		{
+			name: "alts! can put values",
+			input: `
+(let* (a (chan) b (chan 1) result (alts! (list a (list b 3))))
+	(list (= result (list true b)) (take! b)))`,
+			expected: "(true 3)",
+		},
+		{
+			name:     "alts! returns nil if it times out",
+			input:    "(alts! (list (chan)) 10)",
+			expected: "nil",
+		},
+	}
+	runTests(t, cases)
+}
+
+func TestShutdown(t *testing.T) {
+	for _, engine := range engines {
+		engine := engine
+		t.Run(engine, func(t *testing.T) {
+			runtime := core.NewRuntime()
+			env, err := newEnv(engine, runtime)
+			require.NoError(t, err)
+
+			// One future loops forever, and the others wait for things
+			// which never happen
+			_, err = Rep(`
+(do
+	(def! spin (fn* (n) (spin (+ n 1))))
+	(def! spinning (future (spin 0)))
+	(def! looping (future (loop* (n 0) (recur (+ n 1)))))
+	(def! waiting (future (deref (promise))))
+	(def! taking (go (take! (chan))))
+	(def! selecting (go (alts! (list (chan))))))`, env)
+			require.NoError(t, err)
+
+			assert.True(t, runtime.Shutdown(5*time.Second), "futures didn't stop")
+
+			_, err = Rep("(deref spinning)", env)
+			assert.Error(t, err)
+			_, err = Rep("(future 1)", env)
+			assert.Error(t, err)
+			_, err = Rep("((fn* () 1))", env)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestEngines(t *testing.T) {
+	cases := []*TestCase{
+		{
+			name: "deep recursion which isn't in tail position",
+			input: `
+(do
+	(def! sumdown (fn* (n) (if (= n 0) 0 (+ n (sumdown (- n 1))))))
+	(sumdown 100000))`,
+			expected: "5000050000",
+		},
+		{
+			name: "errors are caught by try* in an outer function",
+			input: `
+(do
+	(def! length (fn* (a) (count a)))
+	(def! safe-length (fn* (a) (+ 1 (try* (length a) (catch* e -1)))))
+	(list (safe-length (list 1 2)) (safe-length 1)))`,
+			expected: "(3 0)",
+		},
+		{
+			name: "the stack trace includes each function which was running",
+			input: `
+(do
+	(def! length (fn* (a) (count a)))
+	(def! twice (fn* (a) (* 2 (length a))))
+	(try* (twice 1) (catch* e (stacktrace e))))`,
+			expected: `("count: (count a) at 3:24" "length: (length a) at 4:28" "twice: (twice 1) at 5:8")`,
+		},
+	}
+	runTests(t, cases)
+}
+
+func TestDisasm(t *testing.T) {
+	env := newTestEnv(t, "vm")
+	actual, err := Rep("(disasm (fn* (n) (if n (f n) 0)))", env)
+	require.NoError(t, err)
+	assert.Equal(t, `"0000 LOCAL          0	; n
+0003 JUMP_IF_FALSE  20
+0006 GLOBAL         2	; f
+0009 MACRO_CHECK    1	; (f n)
+0012 LOCAL          3	; n
+0015 TAIL_CALL      1 1	; (f n)
+0020 CONST          4	; 0
+0023 RETURN
+"`, actual)
+
+	actual, err = Rep("(disasm (fn* ((a) a) ((a b) b)))", env)
+	require.NoError(t, err)
+	assert.Equal(t, `"(a)
+0000 LOCAL          0	; a
+0003 RETURN
+(a b)
+0000 LOCAL          0	; b
+0003 RETURN
+"`, actual)
+
+	env = newTestEnv(t, "closure")
+	_, err = Rep("(disasm (fn* (n) n))", env)
+	assert.Error(t, err)
+}
+
+// Benchmarks use the functions from impls/tests/computations.mal
+const computations = `
+(do
+	(def! sumdown (fn* (n) (if (= n 0) 0 (+ n (sumdown (- n 1))))))
+	(def! fib (fn* (n) (if (<= n 1) n (+ (fib (- n 1)) (fib (- n 2)))))))`
+
+func BenchmarkFib(b *testing.B) {
+	runBenchmark(b, computations, "(fib 15)")
+}
+
+func BenchmarkSumdown(b *testing.B) {
+	runBenchmark(b, computations, "(sumdown 100)")
+}
+
+func BenchmarkLet(b *testing.B) {
+	runBenchmark(
+		b,
+		"(def! lets (fn* (n) (let* (a n b (+ a 1)) (let* (c (+ a b)) (+ a (+ b c))))))",
+		"(lets 1)",
+	)
+}
+
+// BenchmarkDef times defining globals, like loading a file of def!s does.
+// Each def! should take the same time, however many globals there already
+// are.
+func BenchmarkDef(b *testing.B) {
+	env := newTestEnv(b, "closure")
+	inputs := make([]string, b.N)
+	for i := range inputs {
+		inputs[i] = fmt.Sprintf("(def! var-%d %d)", i, i)
+	}
+	b.ResetTimer()
+	for _, input := range inputs {
+		if _, err := Rep(input, env); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// runBenchmark times evaluating input, after evaluating setup
+func runBenchmark(b *testing.B, setup string, input string) {
+	b.Helper()
+	for _, engine := range engines {
+		b.Run(engine, func(b *testing.B) {
+			env := newTestEnv(b, engine)
+			_, err := Rep(setup, env)
+			require.NoError(b, err)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := Rep(input, env); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// newTestEnv creates a root env, whose futures are stopped when the test
+// finishes
+func newTestEnv(tb testing.TB, engine string) *environment.Env {
+	runtime := core.NewRuntime()
+	tb.Cleanup(func() {
+		runtime.Shutdown(time.Second)
+	})
+	env, err := newEnv(engine, runtime)
+	require.NoError(tb, err)
+	return env
+}
+
+// engines are the ways function bodies can be run. Tests are run with each
+// of them.
+var engines = []string{"closure", "vm"}
+
+func runTests(t *testing.T, cases []*TestCase) {
+	t.Helper()
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			for _, engine := range engines {
+				engine := engine
+				t.Run(engine, func(t *testing.T) {
+					t.Parallel()
+					env := newTestEnv(t, engine)
+					actual, err := Rep(tc.input, env)
+					if tc.expextedError != nil {
+						// TODO: assert on error message
+						assert.Error(t, err)
+						return
+					}
+					require.NoError(t, err)
+					assert.Equal(t, tc.expected, actual)
+				})
+			}
 		})
 	}
 	t.Parallel()
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jamesroutley/mal/impls/go/src/environment"
+	"github.com/jamesroutley/mal/impls/go/src/types"
+)
+
+// This file implements loop* and recur. loop* binds variables like let*, and
+// evaluates its body. (recur args...) rebinds them to args, and evaluates the
+// body again:
+//
+// > (loop* (i 0 acc 1) (if (< i 5) (recur (+ i 1) (* acc 2)) acc))
+// 32
+//
+// recur jumps back to the start of the body, rather than making a call, so a
+// loop never grows the stack. That only works if there's nothing left to do
+// after the recur, so it has to be in tail position. Unlike a tail call,
+// which just isn't optimised if it's somewhere else, a recur which isn't in
+// tail position is an error, reported when the loop* is analysed.
+//
+// Each time round the loop, the body runs in a new frame, so closures created
+// by one iteration don't see the variables change in the next.
+
+// recurForm is an analysed (recur args...). It refers to the loop* Depth
+// frames above it, whose variables are bound by Bindings.
+type recurForm struct {
+	Args     []types.MalType
+	Depth    int
+	Bindings *letBindings
+	// Form is the recur form, before it was analysed
+	Form *types.MalList
+}
+
+func (r *recurForm) String() string {
+	return r.Form.String()
+}
+
+// analyzeLoop analyses (loop* (k1 v1 k2 v2 ...) body). The bindings work like
+// let*'s, except that recur rebinds each of them, so they each need their own
+// slot. Patterns are replaced with symbols, and destructured by a let* around
+// the body, which is run again after each recur.
+func analyzeLoop(list *types.MalList, sc *scope, env *environment.Env) (types.MalType, error) {
+	args := list.Items()[1:]
+	if len(args) != 2 {
+		return nil, fmt.Errorf("loop* takes 2 args")
+	}
+	bindingList, ok := args[0].(*types.MalList)
+	if !ok {
+		return nil, fmt.Errorf("loop*: first arg isn't a list")
+	}
+	if bindingList.Len()%2 != 0 {
+		return nil, fmt.Errorf("loop*: first arg doesn't have an even number of items")
+	}
+	targets := make([]types.MalType, 0, bindingList.Len()/2)
+	for i := 0; i < bindingList.Len(); i += 2 {
+		targets = append(targets, bindingList.Items()[i])
+	}
+	symbols, destructure, err := expandParams(targets)
+	if err != nil {
+		return nil, err
+	}
+
+	loopScope := &scope{outer: sc}
+	bindings := &letBindings{}
+	for i, symbol := range symbols {
+		key, ok := symbol.(*types.MalSymbol)
+		if !ok {
+			return nil, fmt.Errorf("can't bind %s: it isn't a symbol or a pattern", symbol)
+		}
+		value, err := analyze(bindingList.Items()[2*i+1], loopScope, env)
+		if err != nil {
+			return nil, err
+		}
+		bindings.Values = append(bindings.Values, value)
+		bindings.Indices = append(bindings.Indices, loopScope.declare(key.Value))
+	}
+	bindings.Names = loopScope.names
+	loopScope.loop = bindings
+
+	var body types.MalType = args[1]
+	if len(destructure) > 0 {
+		body = types.NewList(
+			&types.MalSymbol{Value: "let*"}, types.NewList(destructure...), body,
+		)
+	}
+	body, err = analyze(body, loopScope, env)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTailRecur(body, true); err != nil {
+		return nil, err
+	}
+	return withItems(list, list.Items()[0], bindings, body), nil
+}
+
+// analyzeRecur analyses (recur args...), which must be inside a loop* in the
+// same function
+func analyzeRecur(list *types.MalList, sc *scope, env *environment.Env) (types.MalType, error) {
+	bindings, depth, ok := sc.recurTarget()
+	if !ok {
+		return nil, fmt.Errorf("recur isn't inside a loop*")
+	}
+	args := list.Items()[1:]
+	if len(args) != len(bindings.Indices) {
+		return nil, fmt.Errorf("recur takes %d args, the number of loop* bindings, got %d", len(bindings.Indices), len(args))
+	}
+	r := &recurForm{
+		Depth:    depth,
+		Bindings: bindings,
+		Form:     list,
+	}
+	for _, arg := range args {
+		a, err := analyze(arg, sc, env)
+		if err != nil {
+			return nil, err
+		}
+		r.Args = append(r.Args, a)
+	}
+	return r, nil
+}
+
+// checkTailRecur checks every recur in an analysed loop* body is in tail
+// position. tail is true if ast is. Nested fn* forms can't contain a recur
+// for this loop*, so they aren't checked.
+func checkTailRecur(ast types.MalType, tail bool) error {
+	switch tok := ast.(type) {
+	case *recurForm:
+		if !tail {
+			return fmt.Errorf("recur isn't in tail position: %s", tok)
+		}
+		return checkEachTailRecur(tok.Args, false)
+
+	case *types.MalList:
+		if tok.Len() == 0 {
+			return nil
+		}
+		operator, _ := tok.Items()[0].(*types.MalSymbol)
+		if operator == nil {
+			return checkEachTailRecur(tok.Items(), false)
+		}
+		args := tok.Items()[1:]
+		switch operator.Value {
+		case "quote", "quasiquoteexpand", "macroexpand", "macroexpand-1", "macroexpand-all":
+			return nil
+
+		case "if":
+			if len(args) == 0 {
+				return nil
+			}
+			if err := checkTailRecur(args[0], false); err != nil {
+				return err
+			}
+			return checkEachTailRecur(args[1:], tail)
+
+		case "do":
+			if len(args) == 0 {
+				return nil
+			}
+			if err := checkEachTailRecur(args[:len(args)-1], false); err != nil {
+				return err
+			}
+			return checkTailRecur(args[len(args)-1], tail)
+
+		// A recur in the body of a nested loop* is for that loop*, so the
+		// body is checked as if it were in tail position
+		case "let*", "loop*":
+			bindings, ok := args[0].(*letBindings)
+			if !ok || len(args) != 2 {
+				return nil
+			}
+			if err := checkEachTailRecur(bindings.Values, false); err != nil {
+				return err
+			}
+			return checkTailRecur(args[1], tail || operator.Value == "loop*")
+
+		// A recur in the body would jump out of the try* block before it
+		// had finished, so only the catch* handler is in tail position
+		case "try*":
+			if len(args) != 2 {
+				return checkEachTailRecur(args, false)
+			}
+			if err := checkTailRecur(args[0], false); err != nil {
+				return err
+			}
+			catch, ok := args[1].(*types.MalList)
+			if !ok || catch.Len() != 3 {
+				return nil
+			}
+			return checkTailRecur(catch.Items()[2], tail)
+		}
+		return checkEachTailRecur(args, false)
+	}
+	return nil
+}
+
+func checkEachTailRecur(forms []types.MalType, tail bool) error {
+	for _, form := range forms {
+		if err := checkTailRecur(form, tail); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recurFrame creates the frame the body of a loop* runs in after a recur. The
+// loop*'s current frame is r.Depth frames above env. The new frame replaces
+// it, with the variables bound to values.
+func recurFrame(env *environment.Env, r *recurForm, values []types.MalType) (*environment.Env, error) {
+	loopEnv := env
+	for i := 0; i < r.Depth; i++ {
+		loopEnv = loopEnv.Outer
+	}
+	next := environment.NewFrame(loopEnv.Outer, r.Bindings.Names, nil)
+	for i, value := range values {
+		next.SetSlot(r.Bindings.Indices[i], value)
+	}
+	// Like a function call, each iteration checks whether the interpreter
+	// has been shut down, so a loop which never ends can be stopped
+	return next, next.Err()
+}
+
+// evalLoop evaluates a loop* which isn't in a function body. Eval can't jump
+// back to the start of a loop*, so it's analysed and compiled to closures,
+// just like it would be in a function body.
+func evalLoop(list *types.MalList, env *environment.Env) (types.MalType, error) {
+	// The loop* may have been analysed already, if it's from a function body
+	// which is being evaluated by Eval (e.g. because it calls a macro which
+	// wasn't defined when the function was compiled)
+	var analysed types.MalType = list
+	if list.Len() != 3 || !isLetBindings(list.Items()[1]) {
+		var err error
+		if analysed, err = analyzeLoop(list, nil, env); err != nil {
+			return nil, err
+		}
+	}
+	c, err := compile(analysed, false)
+	if err != nil {
+		return nil, err
+	}
+	value, _, err := c(env)
+	return value, err
+}
+
+func isLetBindings(ast types.MalType) bool {
+	_, ok := ast.(*letBindings)
+	return ok
+}
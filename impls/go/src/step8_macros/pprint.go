@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jamesroutley/mal/impls/go/src/core"
+	"github.com/jamesroutley/mal/impls/go/src/environment"
+	"github.com/jamesroutley/mal/impls/go/src/printer"
+	"github.com/jamesroutley/mal/impls/go/src/types"
+)
+
+// The pretty printer breaks collections which don't fit on a line over
+// several lines:
+//
+// > (pprint (quote (def! fib (fn* (N) (if (= N 0) 1 (+ N (fib (- N 1))))))) {:width 30})
+// (def! fib
+//   (fn* (N)
+//     (if (= N 0)
+//       1
+//       (+ N (fib (- N 1))))))
+//
+// *print-length* and *print-level* limit how many items of each collection,
+// and how many levels of nested collections, are printed.
+
+// printOptions returns the options pprint and pp-str print with: the width
+// and indent from opts, which is nil or a hash map, and the length and level
+// from *print-length* and *print-level* in env
+func printOptions(name string, env *environment.Env, opts types.MalType) (printer.Options, error) {
+	options := printer.DefaultOptions
+	for _, setting := range []struct {
+		key   string
+		value *int
+	}{
+		{"width", &options.Width},
+		{"indent", &options.Indent},
+	} {
+		m, ok := opts.(*types.MalHashMap)
+		if !ok {
+			break
+		}
+		switch value := getKeyword(m, setting.key).(type) {
+		case nil:
+		case *types.MalInt:
+			if value.Value < 0 {
+				return options, fmt.Errorf("%s: :%s can't be negative", name, setting.key)
+			}
+			*setting.value = value.Value
+		default:
+			return options, fmt.Errorf("%s: :%s must be a number", name, setting.key)
+		}
+	}
+
+	for _, setting := range []struct {
+		symbol string
+		value  *int
+	}{
+		{"*print-length*", &options.Length},
+		{"*print-level*", &options.Level},
+	} {
+		value, _ := env.Resolve(setting.symbol)
+		switch value := value.(type) {
+		case nil, *types.MalNil:
+		case *types.MalInt:
+			if value.Value < 0 {
+				return options, fmt.Errorf("%s: %s can't be negative", name, setting.symbol)
+			}
+			*setting.value = value.Value
+		default:
+			return options, fmt.Errorf("%s: %s must be nil or a number", name, setting.symbol)
+		}
+	}
+	return options, nil
+}
+
+// prettyArgs checks the args of pprint or pp-str, and returns the value to
+// print, and the options to print it with
+func prettyArgs(name string, env *environment.Env, args []types.MalType) (types.MalType, printer.Options, error) {
+	var opts types.MalType = &types.MalNil{}
+	switch len(args) {
+	case 1:
+	case 2:
+		opts = args[1]
+		switch opts.(type) {
+		case *types.MalNil, *types.MalHashMap:
+		default:
+			return nil, printer.Options{}, fmt.Errorf("%s takes a hash map of options", name)
+		}
+	default:
+		return nil, printer.Options{}, fmt.Errorf("%s takes 1 or 2 args, got %d", name, len(args))
+	}
+	options, err := printOptions(name, env, opts)
+	return args[0], options, err
+}
+
+// setPrintFunctions adds pprint and pp-str to env, along with
+// *print-length* and *print-level*, which are nil to begin with
+func setPrintFunctions(env *environment.Env) {
+	env.Set("*print-length*", &types.MalNil{})
+	env.Set("*print-level*", &types.MalNil{})
+
+	env.Set("pp-str", core.NewBuiltin("pp-str", "(x) (x opts)",
+		"Returns x pretty printed, over as many lines as it needs to fit in (:width opts) columns (80 by default). The arguments of a call which doesn't fit on one line are indented by (:indent opts) (2 by default). *print-length* and *print-level* limit how many items, and how many levels of nested collections, are printed",
+		func(args ...types.MalType) (types.MalType, error) {
+			value, options, err := prettyArgs("pp-str", env, args)
+			if err != nil {
+				return nil, err
+			}
+			s, err := printer.PrettyPrint(value, options)
+			if err != nil {
+				return nil, err
+			}
+			return &types.MalString{
+				Value: s,
+			}, nil
+		},
+	))
+
+	env.Set("pprint", core.NewBuiltin("pprint", "(x) (x opts)",
+		"Prints x, followed by a newline, like pp-str",
+		func(args ...types.MalType) (types.MalType, error) {
+			value, options, err := prettyArgs("pprint", env, args)
+			if err != nil {
+				return nil, err
+			}
+			s, err := printer.PrettyPrint(value, options)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Println(s)
+			return &types.MalNil{}, nil
+		},
+	))
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	_ "embed"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/chzyer/readline"
 	"github.com/jamesroutley/mal/impls/go/src/core"
@@ -18,24 +22,24 @@ var debugExpressions = []string{
 	"(unless true 7 8)",
 }
 
+var (
+	engine = flag.String("engine", "closure", "how to run function bodies: closure, or vm to compile them to bytecode")
+	debug  = flag.Bool("debug", false, "evaluate debugExpressions and exit, rather than starting the REPL")
+)
+
 func main() {
-	env := environment.NewEnv()
-	for _, item := range core.Namespace {
-		env.Set(item.Symbol.Value, item.Func)
-	}
+	flag.Parse()
 
-	// Builtin functions defined in lisp
-	_, err := Rep("(def! not (fn* (a) (if a false true)))", env)
-	if err != nil {
-		log.Fatal(err)
-	}
+	runtime := core.NewRuntime()
+	// Stop any futures which are still running when we exit
+	defer runtime.Shutdown(time.Second)
 
-	_, err = Rep("(def! load-file (fn* (f) (eval (read-string (+ \"(do \" (slurp f) \"\nnil)\")))))", env)
+	env, err := newEnv(*engine, runtime)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	if len(debugExpressions) != 0 {
+	if *debug {
 		for _, expr := range debugExpressions {
 			fmt.Printf("user> %s\n", expr)
 			output, err := Rep(expr, env)
@@ -56,13 +60,6 @@ func main() {
 		log.Fatal(err)
 	}
 
-	// Eval function. Needs to be here, because it closes over `env`
-	env.Set("eval", &types.MalFunction{
-		Func: func(args ...types.MalType) (types.MalType, error) {
-			return Eval(args[0], env)
-		},
-	})
-
 	// code := `"abc"`
 	// ast, err := Read(code)
 	// if err != nil {
@@ -89,14 +86,129 @@ func main() {
 			break
 		}
 		line = strings.TrimSuffix(line, "\n")
+		if strings.HasPrefix(line, ":expand ") {
+			if err := printExpansion(strings.TrimPrefix(line, ":expand "), env); err != nil {
+				printError(err)
+			}
+			continue
+		}
 		output, err := Rep(line, env)
 		if err != nil {
-			fmt.Println(err)
+			printError(err)
 		}
 		fmt.Println(output)
 	}
 }
 
+// newEnv creates the root environment, containing the builtin functions.
+// engine sets *engine*, which decides how function bodies are run: "closure"
+// compiles them to Go closures, and "vm" compiles them to bytecode. Code run
+// in the env stops when runtime is shut down.
+func newEnv(engine string, runtime *core.Runtime) (*environment.Env, error) {
+	if engine != "closure" && engine != "vm" {
+		return nil, fmt.Errorf("unknown engine %q", engine)
+	}
+
+	env := environment.NewEnvWithContext(runtime.Context())
+	for _, item := range core.Namespace {
+		env.Set(item.Symbol.Value, item.Func)
+	}
+	for _, item := range runtime.Namespace() {
+		env.Set(item.Symbol.Value, item.Func)
+	}
+	env.Set("*engine*", &types.MalString{Value: engine})
+
+	// Eval function. Needs to be here, because it closes over `env`
+	env.Set("eval", core.NewBuiltin("eval", "(form)",
+		"Evaluates form in the global environment",
+		func(args ...types.MalType) (types.MalType, error) {
+			return Eval(args[0], env)
+		},
+	))
+
+	// load-file evaluates each form in a file. It's implemented in Go, rather
+	// than in terms of read-string, so the forms it reads keep their
+	// filename and line numbers.
+	env.Set("load-file", core.NewBuiltin("load-file", "(filename)",
+		"Evaluates each form in a file",
+		func(args ...types.MalType) (types.MalType, error) {
+			filename, ok := args[0].(*types.MalString)
+			if !ok {
+				return nil, fmt.Errorf("load-file takes a string")
+			}
+			data, err := ioutil.ReadFile(filename.Value)
+			if err != nil {
+				return nil, err
+			}
+			if err := evalSource(string(data), filename.Value, env); err != nil {
+				return nil, err
+			}
+			return &types.MalNil{}, nil
+		},
+	))
+
+	// disasm returns the bytecode of a function compiled by the vm engine
+	env.Set("disasm", core.NewBuiltin("disasm", "(f)",
+		"Returns a listing of the bytecode of a function compiled by the vm engine",
+		func(args ...types.MalType) (types.MalType, error) {
+			if err := core.ValidateNArgs(1, args); err != nil {
+				return nil, err
+			}
+			function, ok := args[0].(*types.MalFunction)
+			if !ok {
+				return nil, fmt.Errorf("disasm takes a function")
+			}
+			body, ok := function.AST.(*vmBody)
+			if !ok {
+				return nil, fmt.Errorf("disasm: function wasn't compiled to bytecode")
+			}
+			if len(body.Chunks) == 1 {
+				return &types.MalString{
+					Value: disassemble(body.Chunks[0]),
+				}, nil
+			}
+			// Each arity has its own chunk, headed by its parameter list
+			var listing strings.Builder
+			for i, c := range body.Chunks {
+				fmt.Fprintf(&listing, "%s\n%s", body.Lambda.Arities[i].Arglist, disassemble(c))
+			}
+			return &types.MalString{
+				Value: listing.String(),
+			}, nil
+		},
+	))
+
+	setHelpFunctions(env)
+	setPrintFunctions(env)
+
+	// Builtin functions defined in lisp
+	if err := evalSource(prelude, "prelude.mal", env); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+// prelude is the part of the standard library written in lisp, which newEnv
+// evaluates in every env
+//
+//go:embed prelude.mal
+var prelude string
+
+// evalSource evaluates each form in source, which was read from filename
+func evalSource(source string, filename string, env *environment.Env) error {
+	forms, err := reader.ReadAll(source, filename)
+	if err != nil {
+		return err
+	}
+	for _, form := range forms {
+		if _, err := Eval(form, env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Read tokenizes and parses source code
 func Read(s string) (types.MalType, error) {
 	return reader.ReadStr(s)
@@ -113,6 +225,9 @@ func Read(s string) (types.MalType, error) {
 // 3. Lists: by default, they're treated as function calls - each item is
 // evaluated, and the first item (the function itself) is called with the rest
 // of the items as arguments.
+//
+// Function bodies aren't evaluated here. They're compiled when the function
+// is created (see compile.go), and run by apply.
 func Eval(ast types.MalType, env *environment.Env) (types.MalType, error) {
 top:
 	// First - check if ast is a list. If it isn't we can evaluate it as an
@@ -121,7 +236,7 @@ top:
 	if !ok {
 		return evalAST(ast, env)
 	}
-	if len(list.Items) == 0 {
+	if list.Len() == 0 {
 		return ast, nil
 	}
 
@@ -139,9 +254,10 @@ top:
 		// Check if the ast is still a list after the macro expansion. If it
 		// isn't, we just return evalAST, like we did for non-lists above.
 		// If it is, continue.
-		switch expandedAST.(type) {
+		switch expandedAST := expandedAST.(type) {
 		case *types.MalList:
 			ast = expandedAST
+			list = expandedAST
 			// continue
 		default:
 			return evalAST(expandedAST, env)
@@ -181,29 +297,42 @@ top:
 		return nil, fmt.Errorf("list did not evaluate to a list")
 	}
 
-	function, ok := evaluatedList.Items[0].(*types.MalFunction)
+	function, ok := evaluatedList.Items()[0].(*types.MalFunction)
 	if !ok {
 		return nil, fmt.Errorf("first item in list isn't a function")
 	}
 
-	if !function.TailCallOptimised {
-		return function.Func(evaluatedList.Items[1:]...)
-	}
+	return apply(env.Context(), function, evaluatedList.Items()[1:], list)
+}
 
-	// Function is tail call optimised.
-	// Construct the correct environment it should be run in
-	childEnv := environment.NewChildEnv(
-		function.Env.(*environment.Env), function.Params, evaluatedList.Items[1:],
-	)
+// Print prints the AST as a human readable string. It's not inteded for
+// debugging. Any lazy seqs in it are realised, and if that fails, the error
+// is returned.
+func Print(s types.MalType) (string, error) {
+	return printer.Print(s)
+}
 
-	ast = function.AST
-	env = childEnv
-	goto top
+// printError prints an error, followed by its mal stack trace if it has one
+func printError(err error) {
+	fmt.Println(err)
+	for _, frame := range types.StackTrace(err) {
+		fmt.Printf("  in %s\n", frame)
+	}
 }
 
-// Print prints the AST as a human readable string. It's not inteded for debugging
-func Print(s types.MalType) string {
-	return printer.PrStr(s)
+// printExpansion prints each step of the macro expansion of the form in s.
+// It implements the REPL's :expand command.
+func printExpansion(s string, env *environment.Env) error {
+	ast, err := Read(s)
+	if err != nil {
+		return err
+	}
+	expansion, err := formatExpansion(ast, env)
+	if err != nil {
+		return err
+	}
+	fmt.Print(expansion)
+	return nil
 }
 
 // Rep - read, evaluate, print
@@ -216,7 +345,10 @@ func Rep(s string, env *environment.Env) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	s = Print(t)
+	s, err = Print(t)
+	if err != nil {
+		return "", err
+	}
 	return s, nil
 }
 
@@ -231,18 +363,57 @@ func evalAST(ast types.MalType, env *environment.Env) (types.MalType, error) {
 			return nil, err
 		}
 		return value, nil
+	case *localSymbol:
+		return env.Lookup(tok.Depth, tok.Index, tok.Name)
+	// Analysed fn* forms are normally compiled along with the function that
+	// contains them, but can end up here if they're passed to a macro which
+	// wasn't defined when that function was compiled
+	case *lambda:
+		return compileFunction(tok, env)
+	case *types.MalVector:
+		items := make([]types.MalType, tok.Len())
+		for i, item := range tok.Items() {
+			evaluated, err := Eval(item, env)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = evaluated
+		}
+		return types.NewVector(items...), nil
+	case *types.MalSet:
+		items := make([]types.MalType, tok.Len())
+		for i, item := range tok.Items() {
+			evaluated, err := Eval(item, env)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = evaluated
+		}
+		return types.NewSet(items...)
+	case *types.MalHashMap:
+		pairs := make([]types.MalType, 0, 2*tok.Len())
+		for i, key := range tok.Keys() {
+			key, err := Eval(key, env)
+			if err != nil {
+				return nil, err
+			}
+			value, err := Eval(tok.Values()[i], env)
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(pairs, key, value)
+		}
+		return types.NewHashMap(pairs...)
 	case *types.MalList:
-		items := make([]types.MalType, len(tok.Items))
-		for i, item := range tok.Items {
+		items := make([]types.MalType, tok.Len())
+		for i, item := range tok.Items() {
 			evaluated, err := Eval(item, env)
 			if err != nil {
 				return nil, err
 			}
 			items[i] = evaluated
 		}
-		return &types.MalList{
-			Items: items,
-		}, nil
+		return types.NewList(items...), nil
 	}
 	return ast, nil
 }
@@ -252,7 +423,7 @@ func isTCOSpecialForm(ast types.MalType) (operator *types.MalSymbol, args []type
 	if !ok {
 		return nil, nil, false
 	}
-	items := tok.Items
+	items := tok.Items()
 	if len(items) == 0 {
 		return nil, nil, false
 	}
@@ -275,7 +446,7 @@ func isSpecialForm(ast types.MalType) (operator *types.MalSymbol, args []types.M
 	if !ok {
 		return nil, nil, false
 	}
-	items := tok.Items
+	items := tok.Items()
 	if len(items) == 0 {
 		return nil, nil, false
 	}
@@ -286,7 +457,10 @@ func isSpecialForm(ast types.MalType) (operator *types.MalSymbol, args []types.M
 	}
 
 	switch operator.Value {
-	case "fn*", "def!", "quote", "quasiquoteexpand", "defmacro!", "macroexpand":
+	case "fn*", "def!", "quote", "quasiquoteexpand", "defmacro!", "try*", "loop*", "recur":
+		return operator, items[1:], true
+	}
+	if isMacroexpandForm(operator.Value) || isSymbolsForm(operator.Value) {
 		return operator, items[1:], true
 	}
 
@@ -314,21 +488,36 @@ func evalTCOSpecialForm(
 		if len(args) != 2 {
 			return nil, nil, fmt.Errorf("let* takes 2 args")
 		}
+
+		// The let* has been analysed, so we know which slot each binding
+		// goes in
+		if bindings, ok := args[0].(*letBindings); ok {
+			childEnv := environment.NewFrame(env, bindings.Names, nil)
+			for i, value := range bindings.Values {
+				evaluated, err := Eval(value, childEnv)
+				if err != nil {
+					return nil, nil, err
+				}
+				childEnv.SetSlot(bindings.Indices[i], evaluated)
+			}
+			return args[1], childEnv, nil
+		}
+
 		bindingList, ok := args[0].(*types.MalList)
 		if !ok {
 			return nil, nil, fmt.Errorf("let*: first arg isn't a list")
 		}
-		if len(bindingList.Items)%2 != 0 {
-			return nil, nil, fmt.Errorf("let*: first arg doesn't have an even number of items")
+		// Patterns are expanded into bindings of symbols (see
+		// destructure.go)
+		items, err := expandBindings(bindingList.Items())
+		if err != nil {
+			return nil, nil, err
 		}
 
 		childEnv := env.ChildEnv()
-		for i := 0; i < len(bindingList.Items); i += 2 {
-			key, ok := bindingList.Items[i].(*types.MalSymbol)
-			if !ok {
-				return nil, nil, fmt.Errorf("let*: binding list: arg %d isn't a symbol", i)
-			}
-			value, err := Eval(bindingList.Items[i+1], childEnv)
+		for i := 0; i < len(items); i += 2 {
+			key := items[i].(*types.MalSymbol)
+			value, err := Eval(items[i+1], childEnv)
 			if err != nil {
 				return nil, nil, err
 			}
@@ -398,9 +587,15 @@ func evalSpecialForm(
 	// 10
 	// > a
 	// 10
+	//
+	// A docstring can be given before the value, which is added to its
+	// metadata:
+	//
+	// > (def! add1 "Adds 1 to a" (fn* (a) (+ a 1)))
 	case "def!":
-		if len(args) != 2 {
-			return nil, fmt.Errorf("def! takes 2 args")
+		args, doc, err := splitDocstring("def!", args)
+		if err != nil {
+			return nil, err
 		}
 		key, ok := args[0].(*types.MalSymbol)
 		if !ok {
@@ -410,6 +605,12 @@ func evalSpecialForm(
 		if err != nil {
 			return nil, err
 		}
+		if doc != nil {
+			if value, err = withDoc(value, doc); err != nil {
+				return nil, err
+			}
+		}
+		recordDefinition(key, value)
 		env.Set(key.Value, value)
 		return value, nil
 
@@ -420,45 +621,37 @@ func evalSpecialForm(
 	// #<function>
 	// > (add1 2)
 	// 3
+	//
+	// A function can have several parameter lists, each with its own body.
+	// The one which is run depends on how many args it's called with:
+	//
+	// > (def! add (fn* ((a) a) ((a b) (+ a b))))
+	// > (add 1 2)
+	// 3
 	case "fn*":
-		if len(args) != 2 {
-			return nil, fmt.Errorf("fn* statements must have two arguments, got %d", len(args))
+		// The function body is analysed and compiled once, here, rather than
+		// each time the function is called. See analyze.go, and compile.go or
+		// bytecode.go.
+		l, err := analyzeLambda(args, nil, env)
+		if err != nil {
+			return nil, err
 		}
+		return compileFunction(l, env)
 
-		// arguments is the first argument supplied to the fn* function (e.g.
-		// `(a)` in the example above)
-		arguments, ok := args[0].(*types.MalList)
-		if !ok {
-			return nil, fmt.Errorf("fn* statements must have a list as the first arg")
-		}
-		// Cast it from a list of MalType to a list of MalSymbol
-		binds := make([]*types.MalSymbol, len(arguments.Items))
-		for i, a := range arguments.Items {
-			bind, ok := a.(*types.MalSymbol)
-			if !ok {
-				// TODO: improve this - say which argument isn't a symbol
-				return nil, fmt.Errorf("fn* statements must have a list of symbols as the first arg")
-			}
-			binds[i] = bind
-		}
-
-		// TODO: recomment this
-		return &types.MalFunction{
-			TailCallOptimised: true,
-			AST:               args[1],
-			Params:            binds,
-			Env:               env,
-			// This Go function is what's run when the Lisp function is
-			// run. When the Lisp function is run, we create a new environment,
-			// which binds the Lisp function's arguments to the parameters
-			// defined when the function was defined.
-			Func: func(exprs ...types.MalType) (types.MalType, error) {
-				childEnv := environment.NewChildEnv(
-					env, binds, exprs,
-				)
-				return Eval(args[1], childEnv)
-			},
-		}, nil
+	// Binds variables like let*, and evaluates the body. In tail position,
+	// (recur args...) rebinds the variables, and evaluates the body again,
+	// without growing the stack. See loop.go.
+	//
+	// e.g:
+	// > (loop* (i 0 acc 1) (if (< i 5) (recur (+ i 1) (* acc 2)) acc))
+	// 32
+	case "loop*":
+		return evalLoop(types.NewList(args...).Cons(operator), env)
+
+	// A recur inside a loop* has been analysed along with the loop*, so if
+	// Eval finds one, it's in the wrong place
+	case "recur":
+		return nil, fmt.Errorf("recur isn't inside a loop*")
 
 	case "quote":
 		return args[0], nil
@@ -468,8 +661,9 @@ func evalSpecialForm(
 
 	// Creates a new macro
 	case "defmacro!":
-		if len(args) != 2 {
-			return nil, fmt.Errorf("defmacro! takes 2 args")
+		args, doc, err := splitDocstring("defmacro!", args)
+		if err != nil {
+			return nil, err
 		}
 		key, ok := args[0].(*types.MalSymbol)
 		if !ok {
@@ -479,18 +673,71 @@ func evalSpecialForm(
 		if err != nil {
 			return nil, err
 		}
+		if doc != nil {
+			if value, err = withDoc(value, doc); err != nil {
+				return nil, err
+			}
+		}
 		function, ok := value.(*types.MalFunction)
 		if !ok {
 			return nil, fmt.Errorf("defmacro!: second arg isn't a function definition")
 		}
 		function.IsMacro = true
+		recordDefinition(key, function)
 		env.Set(key.Value, function)
 		return function, nil
 
-	// Macroexpand expands a macro and returns the expanded form. Useful for
-	// debugging macros
-	case "macroexpand":
-		return macroExpand(args[0], env)
+	// macroexpand, macroexpand-1 and macroexpand-all expand a macro and
+	// return the expanded form. Useful for debugging macros. See expand.go.
+	case "macroexpand", "macroexpand-1", "macroexpand-all":
+		return evalMacroexpand(types.NewList(args...).Cons(operator), env)
+
+	// dir and apropos list the symbols defined in env, and the envs it's
+	// nested in. See help.go.
+	case "dir", "apropos":
+		evaluated := make([]types.MalType, len(args))
+		for i, arg := range args {
+			value, err := Eval(arg, env)
+			if err != nil {
+				return nil, err
+			}
+			evaluated[i] = value
+		}
+		return evalSymbolsForm(operator.Value, evaluated, env)
+
+	// Evaluates the first arg. If that fails, and a (catch* sym handler) form
+	// is supplied, the handler is evaluated with the exception bound to sym.
+	// e.g:
+	//
+	// > (try* (abc 1 2) (catch* e e))
+	// "`abc` is undefined"
+	case "try*":
+		if numArgs := len(args); numArgs != 1 && numArgs != 2 {
+			return nil, fmt.Errorf("try* takes one or two args, got %d", numArgs)
+		}
+		value, err := Eval(args[0], env)
+		if err == nil || len(args) == 1 {
+			return value, err
+		}
+
+		catch, ok := args[1].(*types.MalList)
+		if !ok || catch.Len() != 3 {
+			return nil, fmt.Errorf("try*: second arg must be a (catch* sym handler) form")
+		}
+		if operator, ok := catch.Items()[0].(*types.MalSymbol); !ok || operator.Value != "catch*" {
+			return nil, fmt.Errorf("try*: second arg must be a (catch* sym handler) form")
+		}
+		key, ok := catch.Items()[1].(*types.MalSymbol)
+		if !ok {
+			return nil, fmt.Errorf("catch*: first arg isn't a symbol")
+		}
+
+		// The handler may have been analysed, so this needs to be a frame,
+		// with the exception in slot 0
+		childEnv := environment.NewFrame(
+			env, []string{key.Value}, []types.MalType{&types.MalException{Err: err}},
+		)
+		return Eval(catch.Items()[2], childEnv)
 
 	// XXX: if you add a case here, you also need to add it to `isSpecialForm`
 
@@ -499,6 +746,74 @@ func evalSpecialForm(
 	}
 }
 
+// recordDefinition records that def! or defmacro! is binding value to key.
+// Functions are named after the first symbol they're bound to, so stack
+// traces are readable, and the name, arglist and where it was defined are
+// added to their metadata.
+func recordDefinition(key *types.MalSymbol, value types.MalType) {
+	function, ok := value.(*types.MalFunction)
+	if !ok || function.Name != "" {
+		return
+	}
+	function.Name = key.Value
+
+	var meta *types.MalHashMap
+	switch m := function.Meta.(type) {
+	case nil:
+		meta, _ = types.NewHashMap()
+	case *types.MalHashMap:
+		meta = m
+	default:
+		// Leave metadata which isn't a hash map alone
+		return
+	}
+	pairs := []types.MalType{
+		&types.MalKeyword{Value: "name"}, &types.MalString{Value: key.Value},
+	}
+	if l := lambdaOf(function); l != nil {
+		pairs = append(pairs, &types.MalKeyword{Value: "arglists"}, l.Arglists())
+	}
+	if pos := key.Pos; pos != nil {
+		if pos.File != "" {
+			pairs = append(pairs, &types.MalKeyword{Value: "file"}, &types.MalString{Value: pos.File})
+		}
+		pairs = append(pairs,
+			&types.MalKeyword{Value: "line"}, &types.MalInt{Value: pos.Line},
+			&types.MalKeyword{Value: "column"}, &types.MalInt{Value: pos.Column},
+		)
+	}
+	// The keys are all keywords, so this can't fail
+	function.Meta, _ = meta.Assoc(pairs...)
+}
+
+// compileFunction compiles an analysed fn* with the engine selected by
+// *engine*, and creates the function it defines, closing over env
+func compileFunction(l *lambda, env *environment.Env) (*types.MalFunction, error) {
+	if usesVM(env) {
+		chunks, err := compileChunks(l)
+		if err != nil {
+			return nil, err
+		}
+		return newVMFunction(l, chunks, env), nil
+	}
+	body, err := compileLambda(l)
+	if err != nil {
+		return nil, err
+	}
+	return newFunction(l, body, env), nil
+}
+
+// usesVM reports whether functions defined in env should be compiled to
+// bytecode, rather than closures
+func usesVM(env *environment.Env) bool {
+	engine, ok := env.Resolve("*engine*")
+	if !ok {
+		return false
+	}
+	s, ok := engine.(*types.MalString)
+	return ok && s.Value == "vm"
+}
+
 // IsTruthy returns a type's truthiness. Currently: it's falsy if the type is
 // `nil` or the boolean 'false'. All other values are truthy.
 func IsTruthy(t types.MalType) bool {
@@ -512,9 +827,43 @@ func IsTruthy(t types.MalType) bool {
 
 }
 
+// quasiquote expands a quasiquoted form into code which builds it. Symbols
+// ending in # (e.g. `value#`) are auto-gensyms: each is replaced with a new
+// symbol, which can't clash with any in the code a macro is passed. Every
+// use of the same auto-gensym in the form is replaced with the same symbol.
 func quasiquote(ast types.MalType) (types.MalType, error) {
+	return quasiquoteForm(ast, map[string]*types.MalSymbol{})
+}
+
+// quasiquoteForm expands a form inside a quasiquote. gensyms maps the
+// auto-gensyms found in the quasiquote so far to the symbols which replace
+// them.
+func quasiquoteForm(ast types.MalType, gensyms map[string]*types.MalSymbol) (types.MalType, error) {
+	// A vector or set is quasiquoted like a list of its items, which is
+	// turned back into a vector or set when it's evaluated
+	switch coll := ast.(type) {
+	case *types.MalVector:
+		quasiquoted, err := quasiquoteForm(types.NewList(coll.Items()...), gensyms)
+		if err != nil {
+			return nil, err
+		}
+		return types.NewList(&types.MalSymbol{Value: "vec"}, quasiquoted), nil
+	case *types.MalSet:
+		quasiquoted, err := quasiquoteForm(types.NewList(coll.Items()...), gensyms)
+		if err != nil {
+			return nil, err
+		}
+		return types.NewList(&types.MalSymbol{Value: "set"}, quasiquoted), nil
+	}
 	list, ok := ast.(*types.MalList)
 	if !ok {
+		if symbol, ok := ast.(*types.MalSymbol); ok && len(symbol.Value) > 1 && strings.HasSuffix(symbol.Value, "#") {
+			name := symbol.Value
+			if _, ok := gensyms[name]; !ok {
+				gensyms[name] = core.Gensym(strings.TrimSuffix(name, "#"))
+			}
+			ast = gensyms[name]
+		}
 		// `ast` isn't a list, which means it can't be an unquoted form. Return
 		// its quoted form. Here, we quote it regardless of its type.
 		// Quoting forms such as ints and strings is redundant - quoting
@@ -522,16 +871,14 @@ func quasiquote(ast types.MalType) (types.MalType, error) {
 		// evaluate to themselves, so there's no difference caused by quoting
 		// them. However, there's also no harm harm in doing so.
 		// This return statements returns the AST version of (quote <ast>)
-		return &types.MalList{
-			Items: []types.MalType{
-				&types.MalSymbol{Value: "quote"},
-				ast,
-			},
-		}, nil
+		return types.NewList(
+			&types.MalSymbol{Value: "quote"},
+			ast,
+		), nil
 	}
 
 	// Okay - ast is a list
-	items := list.Items
+	items := list.Items()
 
 	// If the list has no items, return it unmodified
 	if len(items) == 0 {
@@ -541,7 +888,7 @@ func quasiquote(ast types.MalType) (types.MalType, error) {
 	// If the first item in the list is the function `unquote`, return the
 	// first argument without quoting it.
 	if symbol, ok := items[0].(*types.MalSymbol); ok && symbol.Value == "unquote" {
-		return list.Items[1], nil
+		return list.Items()[1], nil
 	}
 
 	// Okay - ast is a list, than hasn't been unquoted
@@ -552,28 +899,24 @@ func quasiquote(ast types.MalType) (types.MalType, error) {
 
 		// TODO: implement `splice-unquote` functionality
 		if args, ok := isSpliceUnquoteForm(element); ok {
-			quasiquoted = &types.MalList{
-				Items: []types.MalType{
-					&types.MalSymbol{Value: "concat"},
-					args[0],
-					quasiquoted,
-				},
-			}
+			quasiquoted = types.NewList(
+				&types.MalSymbol{Value: "concat"},
+				args[0],
+				quasiquoted,
+			)
 			continue
 		}
 
-		quasiqutoedElement, err := quasiquote(element)
+		quasiqutoedElement, err := quasiquoteForm(element, gensyms)
 		if err != nil {
 			return nil, err
 		}
 
-		quasiquoted = &types.MalList{
-			Items: []types.MalType{
-				&types.MalSymbol{Value: "cons"},
-				quasiqutoedElement,
-				quasiquoted,
-			},
-		}
+		quasiquoted = types.NewList(
+			&types.MalSymbol{Value: "cons"},
+			quasiqutoedElement,
+			quasiquoted,
+		)
 
 	}
 	return quasiquoted, nil
@@ -584,7 +927,7 @@ func isSpliceUnquoteForm(ast types.MalType) (spliceUnquoteArgs []types.MalType,
 	if !ok {
 		return nil, false
 	}
-	items := list.Items
+	items := list.Items()
 	if len(items) == 0 {
 		return nil, false
 	}
@@ -603,7 +946,7 @@ func isMacroCall(ast types.MalType, env *environment.Env) bool {
 	if !ok {
 		return false
 	}
-	items := list.Items
+	items := list.Items()
 	if len(items) == 0 {
 		return false
 	}
@@ -611,11 +954,8 @@ func isMacroCall(ast types.MalType, env *environment.Env) bool {
 	if !ok {
 		return false
 	}
-	value, err := env.Get(symbol.Value)
-	if err != nil {
-		// This looks dangerous, but is okay - the only error this function
-		// returns is a not found when the symbol isn't defined in any
-		// environment
+	value, ok := env.Resolve(symbol.Value)
+	if !ok {
 		return false
 	}
 	function, ok := value.(*types.MalFunction)
@@ -625,33 +965,14 @@ func isMacroCall(ast types.MalType, env *environment.Env) bool {
 	return function.IsMacro
 }
 
+// macroExpand expands ast until it's no longer a macro call. Expanding a
+// macro may produce a call to another macro, which is expanded in turn.
 func macroExpand(ast types.MalType, env *environment.Env) (types.MalType, error) {
-	for isMacroCall(ast, env) {
-		// TODO: isMacroCall could return the macro function, which would save
-		// the casting below
-		//
-		// Don't check the ok value here because we've validated that ast is a
-		// list in isMacroCall. If it's not, something very strange has
-		// happened
-		list := ast.(*types.MalList)
-		// Again, we've already checked this - skip ok checking
-		macroName := list.Items[0].(*types.MalSymbol)
-
-		macroNameValue, err := env.Get(macroName.Value)
-		if err != nil {
-			// Shouldn't happen - we've already validated this above
-			return nil, err
-		}
-		macroFunc := macroNameValue.(*types.MalFunction)
-
-		newAst, err := macroFunc.Func(list.Items[1:]...)
-		if err != nil {
-			return nil, err
+	for {
+		expanded, ok, err := macroExpand1(ast, env)
+		if err != nil || !ok {
+			return expanded, err
 		}
-
-		// Set the evaulated macro to `ast` and loop back - this lets us
-		// iteratively expand nested macros
-		ast = newAst
+		ast = expanded
 	}
-	return ast, nil
 }
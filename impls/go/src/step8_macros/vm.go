@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jamesroutley/mal/impls/go/src/environment"
+	"github.com/jamesroutley/mal/impls/go/src/types"
+)
+
+// This file implements a stack based virtual machine, which runs functions
+// compiled to bytecode (see bytecode.go).
+//
+// Calls between bytecode functions don't use the Go stack: each call pushes a
+// vmFrame, and returning pops it. Tail calls replace the current vmFrame, so
+// they don't use any space at all. Calls to other functions (builtins, or
+// functions compiled to closures) go through apply.
+
+// vmFrame is a call to a bytecode function which is in progress
+type vmFrame struct {
+	function *types.MalFunction
+	chunk    *chunk
+	ip       int
+	env      *environment.Env
+	// base is the height of the stack when the call was made. The call's
+	// temporary values are stored above it.
+	base int
+	// form is the list which made the call, or nil if it was made from Go
+	form *types.MalList
+	// handlers are the try* blocks the call is inside, innermost last
+	handlers []*tryHandler
+}
+
+// tryHandler records how to restore a frame when a try* block catches an
+// error
+type tryHandler struct {
+	target int
+	height int
+	env    *environment.Env
+	key    *types.MalSymbol
+}
+
+// newVMFunction creates the function defined by an analysed fn* form, whose
+// body has been compiled to bytecode, closing over env
+func newVMFunction(l *lambda, chunks []*chunk, env *environment.Env) *types.MalFunction {
+	function := &types.MalFunction{
+		TailCallOptimised: true,
+		AST:               &vmBody{Chunks: chunks, Lambda: l},
+		Env:               env,
+	}
+	// This Go function is what's run when the Lisp function is called from
+	// Go (e.g. to expand a macro)
+	function.Func = func(exprs ...types.MalType) (types.MalType, error) {
+		return runVM(env.Context(), function, exprs, nil)
+	}
+	function.CallContext = func(ctx context.Context, exprs ...types.MalType) (types.MalType, error) {
+		ctx, cancel := callContext(ctx, env)
+		defer cancel()
+		return runVM(ctx, function, exprs, nil)
+	}
+	return function
+}
+
+// runVM calls a bytecode function with args. The call, and the calls it
+// makes, stop once ctx is cancelled. form is the list which made the call, or
+// nil if the call was made from Go.
+func runVM(ctx context.Context, function *types.MalFunction, args []types.MalType, form *types.MalList) (types.MalType, error) {
+	env, arity, err := newCallFrame(ctx, function, args)
+	if err != nil {
+		return nil, withCallFrame(err, function, form)
+	}
+	vm := &vm{}
+	vm.call(function, env, arity, form)
+	return vm.run()
+}
+
+type vm struct {
+	stack  []types.MalType
+	frames []*vmFrame
+}
+
+func (vm *vm) push(value types.MalType) {
+	vm.stack = append(vm.stack, value)
+}
+
+func (vm *vm) pop() types.MalType {
+	value := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return value
+}
+
+// call pushes a frame which calls function, which must be a bytecode
+// function. env and arity are the frame and the arity's index returned by
+// newCallFrame.
+func (vm *vm) call(function *types.MalFunction, env *environment.Env, arity int, form *types.MalList) {
+	vm.frames = append(vm.frames, &vmFrame{
+		function: function,
+		chunk:    function.AST.(*vmBody).Chunks[arity],
+		env:      env,
+		base:     len(vm.stack),
+		form:     form,
+	})
+}
+
+// isBytecodeFunction reports whether the VM can call function itself, rather
+// than going through apply
+func isBytecodeFunction(function *types.MalFunction) bool {
+	_, ok := function.AST.(*vmBody)
+	return ok && function.TailCallOptimised
+}
+
+// run runs the VM until the first frame returns
+func (vm *vm) run() (types.MalType, error) {
+	frame := vm.frames[len(vm.frames)-1]
+	for {
+		code := frame.chunk.Code
+		constants := frame.chunk.Constants
+		op := opcode(code[frame.ip])
+		if int(op) >= len(opcodeInfo) {
+			return nil, fmt.Errorf("unknown opcode %d", op)
+		}
+		// Most instructions take an operand, so we decode the first one up
+		// front
+		numOperands := opcodeInfo[op].Operands
+		var operand int
+		if numOperands > 0 {
+			operand = readOperand(code, frame.ip+1)
+		}
+		frame.ip += 1 + 2*numOperands
+
+		var err error
+		switch op {
+		case OpConst:
+			vm.push(constants[operand])
+
+		case OpGlobal:
+			var value types.MalType
+			value, err = frame.env.Get(constants[operand].(*types.MalSymbol).Value)
+			if err == nil {
+				vm.push(value)
+			}
+
+		case OpLocal:
+			local := constants[operand].(*localSymbol)
+			var value types.MalType
+			value, err = frame.env.Lookup(local.Depth, local.Index, local.Name)
+			if err == nil {
+				vm.push(value)
+			}
+
+		case OpClosure:
+			l := constants[operand].(*vmLambda)
+			vm.push(newVMFunction(l.Lambda, l.Chunks, frame.env))
+
+		case OpDef, OpDefMacro:
+			key := constants[operand].(*types.MalSymbol)
+			value := vm.stack[len(vm.stack)-1]
+			function, ok := value.(*types.MalFunction)
+			if op == OpDefMacro {
+				if !ok {
+					err = fmt.Errorf("defmacro!: second arg isn't a function definition")
+					break
+				}
+				function.IsMacro = true
+			}
+			recordDefinition(key, value)
+			frame.env.Set(key.Value, value)
+
+		case OpPushFrame:
+			bindings := constants[operand].(*letBindings)
+			frame.env = environment.NewFrame(frame.env, bindings.Names, nil)
+
+		case OpSetSlot:
+			frame.env.SetSlot(operand, vm.pop())
+
+		case OpPopFrame:
+			frame.env = frame.env.Outer
+
+		case OpJump:
+			frame.ip = operand
+
+		case OpJumpIfFalse:
+			if !IsTruthy(vm.pop()) {
+				frame.ip = operand
+			}
+
+		case OpPop:
+			vm.pop()
+
+		case OpMacroCheck:
+			function, ok := vm.stack[len(vm.stack)-1].(*types.MalFunction)
+			if ok && function.IsMacro {
+				err = lateMacroError(constants[operand].(*types.MalList))
+			}
+
+		case OpCall, OpTailCall:
+			numArgs := operand
+			form := constants[readOperand(code, frame.ip-2)].(*types.MalList)
+			calleeIndex := len(vm.stack) - numArgs - 1
+			function, ok := vm.stack[calleeIndex].(*types.MalFunction)
+			if !ok {
+				err = fmt.Errorf("first item in list isn't a function")
+				break
+			}
+			args := make([]types.MalType, numArgs)
+			copy(args, vm.stack[calleeIndex+1:])
+			vm.stack = vm.stack[:calleeIndex]
+
+			if !isBytecodeFunction(function) {
+				var value types.MalType
+				value, err = apply(frame.env.Context(), function, args, form)
+				if err != nil {
+					break
+				}
+				vm.push(value)
+				if op == OpCall {
+					break
+				}
+				// A tail call to something we can't run ourselves. We've
+				// made the call, so all that's left is to return its result.
+				op = OpReturn
+			} else {
+				var env *environment.Env
+				var arity int
+				env, arity, err = newCallFrame(frame.env.Context(), function, args)
+				if err != nil {
+					err = withCallFrame(err, function, form)
+					break
+				}
+				if op == OpTailCall {
+					vm.stack = vm.stack[:frame.base]
+					vm.frames = vm.frames[:len(vm.frames)-1]
+				}
+				vm.call(function, env, arity, form)
+				frame = vm.frames[len(vm.frames)-1]
+				break
+			}
+			fallthrough
+
+		case OpReturn:
+			result := vm.pop()
+			vm.stack = vm.stack[:frame.base]
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			if len(vm.frames) == 0 {
+				return result, nil
+			}
+			frame = vm.frames[len(vm.frames)-1]
+			vm.push(result)
+
+		case OpTry:
+			frame.handlers = append(frame.handlers, &tryHandler{
+				target: operand,
+				height: len(vm.stack),
+				env:    frame.env,
+				key:    constants[readOperand(code, frame.ip-2)].(*types.MalSymbol),
+			})
+
+		case OpEndTry:
+			frame.handlers = frame.handlers[:len(frame.handlers)-1]
+
+		case OpRecur:
+			recur := constants[operand].(*recurForm)
+			argsStart := len(vm.stack) - len(recur.Args)
+			var env *environment.Env
+			env, err = recurFrame(frame.env, recur, vm.stack[argsStart:])
+			if err != nil {
+				break
+			}
+			vm.stack = vm.stack[:argsStart]
+			frame.env = env
+			frame.ip = readOperand(code, frame.ip-2)
+
+		case OpMacroexpand:
+			var value types.MalType
+			value, err = evalMacroexpand(constants[operand].(*types.MalList), frame.env)
+			if err == nil {
+				vm.push(value)
+			}
+
+		case OpSymbols:
+			form := constants[operand].(*types.MalList)
+			argsStart := len(vm.stack) - (form.Len() - 1)
+			args := make([]types.MalType, form.Len()-1)
+			copy(args, vm.stack[argsStart:])
+			vm.stack = vm.stack[:argsStart]
+			var value types.MalType
+			value, err = evalSymbolsForm(form.Items()[0].(*types.MalSymbol).Value, args, frame.env)
+			if err == nil {
+				vm.push(value)
+			}
+		}
+
+		if err != nil {
+			frame, err = vm.unwind(err)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// unwind handles an error raised by the current frame. It pops frames until
+// it finds one which is inside a try* block, and returns that frame, set up
+// to run the block's catch* handler. If no frame catches the error, it
+// returns the error, with the frames it popped added to its stack trace.
+func (vm *vm) unwind(err error) (*vmFrame, error) {
+	for len(vm.frames) > 0 {
+		frame := vm.frames[len(vm.frames)-1]
+		if n := len(frame.handlers); n > 0 {
+			handler := frame.handlers[n-1]
+			frame.handlers = frame.handlers[:n-1]
+			vm.stack = vm.stack[:handler.height]
+			frame.env = environment.NewFrame(
+				handler.env,
+				[]string{handler.key.Value},
+				[]types.MalType{&types.MalException{Err: err}},
+			)
+			frame.ip = handler.target
+			return frame, nil
+		}
+
+		err = withCallFrame(err, frame.function, frame.form)
+		vm.stack = vm.stack[:frame.base]
+		vm.frames = vm.frames[:len(vm.frames)-1]
+	}
+	return nil, err
+}
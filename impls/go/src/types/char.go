@@ -0,0 +1,60 @@
+package types
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// MalChar is a single Unicode character (a rune). Characters are written
+// \a, or by name, e.g. \newline. Strings are sequences of characters, so
+// walking one (with first, rest, map etc.) yields one MalChar per rune,
+// rather than per byte.
+type MalChar struct {
+	Value rune
+}
+
+// charNames are the names of characters which would be hard to read if
+// they were written literally
+var charNames = map[string]rune{
+	"newline":   '\n',
+	"space":     ' ',
+	"tab":       '\t',
+	"return":    '\r',
+	"backspace": '\b',
+	"formfeed":  '\f',
+}
+
+// CharNamed returns the character written \name: either a single
+// character, a name from charNames, or uXXXX, the hex code point of a
+// character
+func CharNamed(name string) (*MalChar, error) {
+	if r, size := utf8.DecodeRuneInString(name); size == len(name) && r != utf8.RuneError {
+		return &MalChar{Value: r}, nil
+	}
+	if r, ok := charNames[name]; ok {
+		return &MalChar{Value: r}, nil
+	}
+	var r rune
+	if n, err := fmt.Sscanf(name, "u%04x", &r); err == nil && n == 1 && len(name) == 5 && utf8.ValidRune(r) {
+		return &MalChar{Value: r}, nil
+	}
+	return nil, fmt.Errorf("unknown character \\%s", name)
+}
+
+func (c *MalChar) String() string {
+	for name, r := range charNames {
+		if r == c.Value {
+			return `\` + name
+		}
+	}
+	return `\` + string(c.Value)
+}
+
+// Chars returns the characters of a string, one per rune
+func Chars(s string) []MalType {
+	chars := make([]MalType, 0, utf8.RuneCountInString(s))
+	for _, r := range s {
+		chars = append(chars, &MalChar{Value: r})
+	}
+	return chars
+}
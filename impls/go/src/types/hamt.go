@@ -0,0 +1,321 @@
+package types
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"sort"
+	"sync"
+)
+
+// This file implements a hash array mapped trie (HAMT), which backs
+// MalHashMap and MalSet. It's a trie keyed on the hash of each key, 5 bits per level.
+// Each node only stores the children it has, so a bitmap records which of its
+// 32 possible children are there. Adding or removing a key copies the nodes
+// on the path to it, and shares the rest with the old trie.
+//
+// Once all 32 bits of two keys' hashes have been used up, and they still
+// collide, they're stored together in a collision node, which is searched
+// linearly.
+//
+// orderedTrie wraps the trie, and keeps track of the order keys were added
+// in. Hash maps and sets are both built on it.
+
+const (
+	hamtBits = 5
+	hamtMask = 1<<hamtBits - 1
+)
+
+// hamtEntry is a key and its value. id is the key's hashKey, which
+// identifies it, and hash is the hash of id.
+type hamtEntry struct {
+	hash  uint32
+	id    string
+	key   MalType
+	value MalType
+	// order records when the key was added to the map, so the map can list
+	// its keys in the order they were added
+	order int
+}
+
+// hamtNode is a node in the trie. Each of its slots holds either an entry or
+// a child node. Collision nodes have no bitmap, and only hold entries.
+type hamtNode struct {
+	bitmap uint32
+	slots  []hamtSlot
+	// collision is true for a collision node
+	collision bool
+}
+
+type hamtSlot struct {
+	entry *hamtEntry
+	child *hamtNode
+}
+
+var emptyHAMTNode = &hamtNode{}
+
+func hashID(id string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return h.Sum32()
+}
+
+// index returns the bit for hash at shift, and the position of its slot
+func (n *hamtNode) index(hash uint32, shift uint) (uint32, int) {
+	bit := uint32(1) << ((hash >> shift) & hamtMask)
+	return bit, bits.OnesCount32(n.bitmap & (bit - 1))
+}
+
+func (n *hamtNode) get(hash uint32, shift uint, id string) *hamtEntry {
+	for {
+		if n.collision {
+			for _, slot := range n.slots {
+				if slot.entry.id == id {
+					return slot.entry
+				}
+			}
+			return nil
+		}
+		bit, i := n.index(hash, shift)
+		if n.bitmap&bit == 0 {
+			return nil
+		}
+		slot := n.slots[i]
+		if slot.entry != nil {
+			if slot.entry.id == id {
+				return slot.entry
+			}
+			return nil
+		}
+		n = slot.child
+		shift += hamtBits
+	}
+}
+
+// assoc returns a copy of n with e added, replacing the value of any entry
+// with the same id. added is false if
+// it replaced an entry.
+func (n *hamtNode) assoc(shift uint, e *hamtEntry) (node *hamtNode, added bool) {
+	if n.collision {
+		for i, slot := range n.slots {
+			if slot.entry.id == e.id {
+				return n.withSlot(i, hamtSlot{entry: e.replacing(slot.entry)}), false
+			}
+		}
+		return n.insertSlot(0, len(n.slots), hamtSlot{entry: e}), true
+	}
+
+	bit, i := n.index(e.hash, shift)
+	if n.bitmap&bit == 0 {
+		return n.insertSlot(bit, i, hamtSlot{entry: e}), true
+	}
+	slot := n.slots[i]
+	if slot.child != nil {
+		child, added := slot.child.assoc(shift+hamtBits, e)
+		return n.withSlot(i, hamtSlot{child: child}), added
+	}
+	if slot.entry.id == e.id {
+		return n.withSlot(i, hamtSlot{entry: e.replacing(slot.entry)}), false
+	}
+	// Another key's already in this slot, so they both move down a level
+	child := newHAMTNode(shift + hamtBits)
+	child, _ = child.assoc(shift+hamtBits, slot.entry)
+	child, _ = child.assoc(shift+hamtBits, e)
+	return n.withSlot(i, hamtSlot{child: child}), true
+}
+
+// newHAMTNode returns an empty node at shift. Below the last level, all the
+// bits of the hash have been used, so it's a collision node.
+func newHAMTNode(shift uint) *hamtNode {
+	return &hamtNode{collision: shift >= 32}
+}
+
+// dissoc returns a copy of n without the entry for id, or nil if that would
+// leave it empty. removed is false if there's no entry for id, in which case
+// n is returned unchanged.
+func (n *hamtNode) dissoc(hash uint32, shift uint, id string) (node *hamtNode, removed bool) {
+	if n.collision {
+		for i, slot := range n.slots {
+			if slot.entry.id == id {
+				return n.removeSlot(0, i), true
+			}
+		}
+		return n, false
+	}
+
+	bit, i := n.index(hash, shift)
+	if n.bitmap&bit == 0 {
+		return n, false
+	}
+	slot := n.slots[i]
+	if slot.entry != nil {
+		if slot.entry.id != id {
+			return n, false
+		}
+		return n.removeSlot(bit, i), true
+	}
+	child, removed := slot.child.dissoc(hash, shift+hamtBits, id)
+	if !removed {
+		return n, false
+	}
+	if child == nil {
+		return n.removeSlot(bit, i), true
+	}
+	return n.withSlot(i, hamtSlot{child: child}), true
+}
+
+// withSlot returns a copy of n, with slot i replaced
+func (n *hamtNode) withSlot(i int, slot hamtSlot) *hamtNode {
+	slots := append([]hamtSlot(nil), n.slots...)
+	slots[i] = slot
+	return &hamtNode{bitmap: n.bitmap, slots: slots, collision: n.collision}
+}
+
+// insertSlot returns a copy of n, with slot inserted at position i, and bit
+// set in the bitmap
+func (n *hamtNode) insertSlot(bit uint32, i int, slot hamtSlot) *hamtNode {
+	slots := make([]hamtSlot, len(n.slots)+1)
+	copy(slots, n.slots[:i])
+	slots[i] = slot
+	copy(slots[i+1:], n.slots[i:])
+	return &hamtNode{bitmap: n.bitmap | bit, slots: slots, collision: n.collision}
+}
+
+// removeSlot returns a copy of n, without the slot at position i, and with
+// bit cleared in the bitmap. It returns nil if the copy would be empty.
+func (n *hamtNode) removeSlot(bit uint32, i int) *hamtNode {
+	if len(n.slots) == 1 {
+		return nil
+	}
+	slots := make([]hamtSlot, 0, len(n.slots)-1)
+	slots = append(slots, n.slots[:i]...)
+	slots = append(slots, n.slots[i+1:]...)
+	return &hamtNode{bitmap: n.bitmap &^ bit, slots: slots, collision: n.collision}
+}
+
+// entries appends every entry in the trie under n to entries
+func (n *hamtNode) entries(entries []*hamtEntry) []*hamtEntry {
+	for _, slot := range n.slots {
+		if slot.entry != nil {
+			entries = append(entries, slot.entry)
+		} else {
+			entries = slot.child.entries(entries)
+		}
+	}
+	return entries
+}
+
+// replacing returns a copy of e which replaces old. It keeps old's key, and
+// its position in the order.
+func (e *hamtEntry) replacing(old *hamtEntry) *hamtEntry {
+	copied := *e
+	copied.key = old.key
+	copied.order = old.order
+	return &copied
+}
+
+// orderedTrie is a persistent map from ids to entries. Its methods return a
+// new orderedTrie, rather than changing the old one. The zero orderedTrie is
+// empty.
+type orderedTrie struct {
+	root  *hamtNode
+	count int
+	// nextOrder is the order of the next key to be added
+	nextOrder int
+	// ordered caches the entries, in the order they were added
+	ordered *orderedEntries
+}
+
+// orderedEntries is a trie's keys and values, in the order they were added.
+// They're computed the first time they're needed.
+type orderedEntries struct {
+	once   sync.Once
+	keys   []MalType
+	values []MalType
+}
+
+// get returns the entry for id, or nil if there isn't one
+func (t orderedTrie) get(id string) *hamtEntry {
+	if t.root == nil {
+		return nil
+	}
+	return t.root.get(hashID(id), 0, id)
+}
+
+// with returns a copy of t, with key and its value added. If there's already
+// an entry for id, it keeps its key and position, but gets the new value.
+func (t orderedTrie) with(id string, key MalType, value MalType) orderedTrie {
+	root := t.root
+	if root == nil {
+		root = emptyHAMTNode
+	}
+	root, added := root.assoc(0, &hamtEntry{
+		hash:  hashID(id),
+		id:    id,
+		key:   key,
+		value: value,
+		order: t.nextOrder,
+	})
+	updated := orderedTrie{
+		root:      root,
+		count:     t.count,
+		nextOrder: t.nextOrder,
+		ordered:   &orderedEntries{},
+	}
+	if added {
+		updated.count++
+		updated.nextOrder++
+	}
+	return updated
+}
+
+// without returns a copy of t, without the entry for id
+func (t orderedTrie) without(id string) orderedTrie {
+	if t.root == nil {
+		return t
+	}
+	root, removed := t.root.dissoc(hashID(id), 0, id)
+	if !removed {
+		return t
+	}
+	return orderedTrie{
+		root:      root,
+		count:     t.count - 1,
+		nextOrder: t.nextOrder,
+		ordered:   &orderedEntries{},
+	}
+}
+
+// entries returns the keys and values, in the order they were added
+func (t orderedTrie) entries() ([]MalType, []MalType) {
+	if t.root == nil || t.ordered == nil {
+		return nil, nil
+	}
+	o := t.ordered
+	o.once.Do(func() {
+		entries := t.root.entries(make([]*hamtEntry, 0, t.count))
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].order < entries[j].order
+		})
+		o.keys = make([]MalType, len(entries))
+		o.values = make([]MalType, len(entries))
+		for i, entry := range entries {
+			o.keys[i], o.values[i] = entry.key, entry.value
+		}
+	})
+	return o.keys, o.values
+}
+
+// ids returns the ids of every entry, sorted, so they don't depend on the
+// order the entries were added in
+func (t orderedTrie) ids() []string {
+	if t.root == nil {
+		return nil
+	}
+	entries := t.root.entries(make([]*hamtEntry, 0, t.count))
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.id
+	}
+	sort.Strings(ids)
+	return ids
+}
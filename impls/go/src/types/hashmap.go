@@ -0,0 +1,174 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type MalKeyword struct {
+	// Value is the keyword's name, without the leading colon
+	Value string
+}
+
+func (k *MalKeyword) String() string {
+	return ":" + k.Value
+}
+
+// MalHashMap maps keys to values. Keys can be strings, keywords, numbers,
+// symbols, booleans, nil, or collections of them. Hash maps are immutable:
+// Assoc and Dissoc return a new map, rather than changing the old one.
+// They're persistent, so the new map shares most of its structure with the
+// old one (see hamt.go). They remember the order their keys were added in,
+// which is the order they're printed in.
+type MalHashMap struct {
+	trie orderedTrie
+	Meta MalType
+}
+
+// NewHashMap creates a hash map from a list of alternating keys and values
+func NewHashMap(pairs ...MalType) (*MalHashMap, error) {
+	return (&MalHashMap{}).Assoc(pairs...)
+}
+
+// unhashableError is returned by hashKey for a value which can't be hashed
+type unhashableError struct {
+	value MalType
+}
+
+func (e *unhashableError) Error() string {
+	return fmt.Sprintf("%s can't be used as a hash map key", e.value)
+}
+
+// hashKey returns a string which identifies a key. The trie is keyed on its
+// hash. Keys have the same id if, and only if, they're =, so lists and
+// vectors with the same items have the same id, and the id of a hash map or
+// set doesn't depend on the order its keys were added in. Lazy seqs are
+// realised.
+func hashKey(key MalType) (string, error) {
+	switch key := key.(type) {
+	case *MalString:
+		return "s" + key.Value, nil
+	case *MalChar:
+		return "c" + string(key.Value), nil
+	case *MalKeyword:
+		return "k" + key.Value, nil
+	case *MalSymbol:
+		return "y" + key.Value, nil
+	case *MalInt:
+		return "i" + strconv.Itoa(key.Value), nil
+	case *MalBoolean:
+		return "b" + strconv.FormatBool(key.Value), nil
+	case *MalNil:
+		return "n", nil
+	case *MalList, *MalVector, *MalLazySeq:
+		items, err := Items(key)
+		if err != nil {
+			return "", err
+		}
+		ids := make([]string, len(items))
+		for i, item := range items {
+			id, err := hashKey(item)
+			if err != nil {
+				return "", err
+			}
+			ids[i] = strconv.Quote(id)
+		}
+		return "(" + strings.Join(ids, " ") + ")", nil
+	case *MalHashMap:
+		keys, values := key.trie.entries()
+		ids := make([]string, len(keys))
+		for i := range keys {
+			keyID, err := hashKey(keys[i])
+			if err != nil {
+				return "", err
+			}
+			valueID, err := hashKey(values[i])
+			if err != nil {
+				return "", err
+			}
+			ids[i] = strconv.Quote(keyID) + " " + strconv.Quote(valueID)
+		}
+		sort.Strings(ids)
+		return "{" + strings.Join(ids, " ") + "}", nil
+	case *MalSet:
+		ids := key.trie.ids()
+		for i, id := range ids {
+			ids[i] = strconv.Quote(id)
+		}
+		return "#{" + strings.Join(ids, " ") + "}", nil
+	}
+	return "", &unhashableError{value: key}
+}
+
+// Get returns the value of key, and whether it's in the map
+func (m *MalHashMap) Get(key MalType) (MalType, bool) {
+	id, err := hashKey(key)
+	if err != nil {
+		return nil, false
+	}
+	entry := m.trie.get(id)
+	if entry == nil {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// Assoc returns a copy of the map, with each of a list of alternating keys
+// and values added to it. Keys which are already in the map keep their
+// position, but get the new value.
+func (m *MalHashMap) Assoc(pairs ...MalType) (*MalHashMap, error) {
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("hash maps need an even number of keys and values, got %d", len(pairs))
+	}
+	updated := &MalHashMap{trie: m.trie, Meta: m.Meta}
+	for i := 0; i < len(pairs); i += 2 {
+		id, err := hashKey(pairs[i])
+		if err != nil {
+			return nil, err
+		}
+		updated.trie = updated.trie.with(id, pairs[i], pairs[i+1])
+	}
+	return updated, nil
+}
+
+// Dissoc returns a copy of the map, without keys
+func (m *MalHashMap) Dissoc(keys ...MalType) (*MalHashMap, error) {
+	updated := &MalHashMap{trie: m.trie, Meta: m.Meta}
+	for _, key := range keys {
+		id, err := hashKey(key)
+		if err != nil {
+			return nil, err
+		}
+		updated.trie = updated.trie.without(id)
+	}
+	return updated, nil
+}
+
+// Keys returns the map's keys, in the order they were added. The slice
+// mustn't be modified.
+func (m *MalHashMap) Keys() []MalType {
+	keys, _ := m.trie.entries()
+	return keys
+}
+
+// Values returns the map's values, in the same order as Keys. The slice
+// mustn't be modified.
+func (m *MalHashMap) Values() []MalType {
+	_, values := m.trie.entries()
+	return values
+}
+
+func (m *MalHashMap) Len() int {
+	return m.trie.count
+}
+
+func (m *MalHashMap) String() string {
+	keys, values := m.trie.entries()
+	itemStrings := make([]string, 0, 2*len(keys))
+	for i, key := range keys {
+		itemStrings = append(itemStrings, key.String(), values[i].String())
+	}
+	return fmt.Sprintf("{%s}", strings.Join(itemStrings, " "))
+}
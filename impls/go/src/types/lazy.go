@@ -0,0 +1,209 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Sequences are lists, lazy seqs and nil (which is an empty sequence). Next
+// walks any of them (or a vector, set or string) an item at a time, so code
+// which uses it works with sequences which are infinite, or too big to hold
+// in memory at once.
+
+// MalLazySeq is a sequence whose items are only computed when they're
+// needed. It starts out as a thunk: a function which returns the sequence (a
+// list, nil, or another lazy seq). The first time the seq is looked at, the
+// thunk is called, and its result is memoized as the seq's first item and
+// the rest of the sequence, which may be lazy itself.
+//
+// Lazy seqs can be shared between goroutines, so realising one takes a lock.
+// The lock isn't held while the thunk runs: other goroutines wait for it to
+// finish. A thunk which looks at the seq it's computing (e.g.
+// (def! s (lazy-seq (cons 1 (rest s))))) waits for itself forever.
+type MalLazySeq struct {
+	mu       sync.Mutex
+	thunk    func() (MalType, error)
+	realized bool
+	// realizing is true while the thunk is running, and done is closed when
+	// it's finished
+	realizing bool
+	done      chan struct{}
+	// Once the seq is realised, empty is true if it has no items. Otherwise,
+	// first is its first item, and rest is the rest of the sequence. If the
+	// thunk failed, err is its error.
+	empty bool
+	first MalType
+	rest  MalType
+	err   error
+}
+
+// NewLazySeq creates a lazy seq, whose items are computed by thunk
+func NewLazySeq(thunk func() (MalType, error)) *MalLazySeq {
+	return &MalLazySeq{
+		thunk: thunk,
+	}
+}
+
+// Cons creates a sequence whose first item is first, followed by the items
+// of the sequence rest, without realising any of rest
+func Cons(first MalType, rest MalType) *MalLazySeq {
+	return &MalLazySeq{
+		realized: true,
+		first:    first,
+		rest:     rest,
+	}
+}
+
+// Realized reports whether the seq's first item has been computed
+func (s *MalLazySeq) Realized() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.realized
+}
+
+func (s *MalLazySeq) realize() error {
+	s.mu.Lock()
+	if s.realized {
+		s.mu.Unlock()
+		return s.err
+	}
+	if s.realizing {
+		done := s.done
+		s.mu.Unlock()
+		<-done
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.err
+	}
+	s.realizing = true
+	s.done = make(chan struct{})
+	thunk := s.thunk
+	s.mu.Unlock()
+
+	var (
+		first, rest MalType
+		ok          bool
+		err         error
+	)
+	// The seq's state is recorded even if the thunk panics, so goroutines
+	// waiting for it don't wait forever
+	defer func() {
+		r := recover()
+		if r != nil {
+			err = fmt.Errorf("realising lazy seq panicked: %v", r)
+		}
+		s.mu.Lock()
+		s.first, s.rest, s.empty, s.err = first, rest, !ok, err
+		s.realized = true
+		s.realizing = false
+		// The thunk may refer to a lot of data (e.g. the sequence it's
+		// mapping over), which we don't need any more
+		s.thunk = nil
+		close(s.done)
+		s.mu.Unlock()
+		if r != nil {
+			panic(r)
+		}
+	}()
+
+	value, err := thunk()
+	if err == nil {
+		first, rest, ok, err = Next(value)
+	}
+	return err
+}
+
+func (s *MalLazySeq) String() string {
+	var itemStrings []string
+	var seq MalType = s
+	for {
+		first, rest, ok, err := Next(seq)
+		if err != nil {
+			itemStrings = append(itemStrings, (&MalError{Message: err.Error()}).String())
+			break
+		}
+		if !ok {
+			break
+		}
+		itemStrings = append(itemStrings, first.String())
+		seq = rest
+	}
+	return fmt.Sprintf("(%s)", strings.Join(itemStrings, " "))
+}
+
+// IsSeq reports whether t is a list or a lazy seq
+func IsSeq(t MalType) bool {
+	switch t.(type) {
+	case *MalList, *MalLazySeq:
+		return true
+	}
+	return false
+}
+
+// Next splits a sequence into its first item and the rest of the sequence.
+// ok is false if the sequence is empty. A lazy seq is only realised as far as
+// its first item. Go's nil is treated like mal's nil, so thunks written in Go
+// can return it.
+func Next(seq MalType) (first MalType, rest MalType, ok bool, err error) {
+	switch seq := seq.(type) {
+	case nil, *MalNil:
+		return nil, nil, false, nil
+	case *MalList:
+		if seq.Len() == 0 {
+			return nil, nil, false, nil
+		}
+		return seq.First(), seq.Rest(), true, nil
+	case *MalVector:
+		// The rest of a vector is a list, backed by the vector's items, so
+		// walking it is O(1) per item
+		return nextItem(seq.Items())
+	case *MalSet:
+		return nextItem(seq.Items())
+	case *MalString:
+		// A string is a sequence of characters, one per rune
+		return nextItem(Chars(seq.Value))
+	case *MalLazySeq:
+		if err := seq.realize(); err != nil {
+			return nil, nil, false, err
+		}
+		if seq.empty {
+			return nil, nil, false, nil
+		}
+		return seq.first, seq.rest, true, nil
+	}
+	return nil, nil, false, fmt.Errorf("%s isn't a sequence", seq)
+}
+
+func nextItem(items []MalType) (MalType, MalType, bool, error) {
+	if len(items) == 0 {
+		return nil, nil, false, nil
+	}
+	return items[0], NewList(items[1:]...), true, nil
+}
+
+// Items returns every item of a sequence, realising all of it
+func Items(seq MalType) ([]MalType, error) {
+	switch seq := seq.(type) {
+	case *MalList:
+		return seq.Items(), nil
+	case *MalVector:
+		return seq.Items(), nil
+	case *MalSet:
+		return seq.Items(), nil
+	case *MalString:
+		return Chars(seq.Value), nil
+	}
+	var items []MalType
+	for {
+		first, rest, ok, err := Next(seq)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return items, nil
+		}
+		items = append(items, first)
+		seq = rest
+	}
+}
@@ -0,0 +1,53 @@
+package types
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLazySeqRealisedOnce(t *testing.T) {
+	calls := 0
+	release := make(chan struct{})
+	seq := NewLazySeq(func() (MalType, error) {
+		calls++
+		<-release
+		return NewList(ints(3)...), nil
+	})
+
+	// Goroutines which look at the seq while another is realising it wait
+	// for it, rather than failing
+	var wg sync.WaitGroup
+	firsts := make([]MalType, 10)
+	for i := range firsts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			first, _, ok, err := Next(seq)
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			firsts[i] = first
+		}(i)
+	}
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, 1, calls)
+	for _, first := range firsts {
+		assert.Equal(t, "0", first.String())
+	}
+}
+
+func TestLazySeqThunkPanics(t *testing.T) {
+	seq := NewLazySeq(func() (MalType, error) {
+		panic("oops")
+	})
+	assert.PanicsWithValue(t, "oops", func() {
+		Next(seq)
+	})
+
+	// Later realisations don't wait for the thunk which panicked
+	_, _, _, err := Next(seq)
+	assert.EqualError(t, err, "realising lazy seq panicked: oops")
+}
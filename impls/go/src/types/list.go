@@ -0,0 +1,168 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// MalList is an immutable list. Lists are persistent: Cons returns a new list
+// which shares every item of the old one, rather than copying them, so adding
+// an item to the front of a list is O(1), and so is taking its Rest.
+//
+// A list is either backed by a slice (e.g. lists read from source code, or
+// built by NewList), or it's a cell: a first item followed by the items of
+// another list.
+type MalList struct {
+	// items are the items of a slice backed list. They're nil for a cell.
+	items []MalType
+	// first and rest are a cell's first item, and the list after it. rest is
+	// nil for a slice backed list.
+	first MalType
+	rest  *MalList
+	count int
+	// flattened caches a cell's items as a slice, once Items is called
+	flattened *flatItems
+	// Pos is where the list was read from, or nil if it wasn't read from
+	// source code
+	Pos  *Position
+	Meta MalType
+}
+
+// flatItems holds the items of a persistent collection as a slice, which is
+// computed the first time it's needed. Collections are shared between
+// goroutines, so it's only computed once.
+type flatItems struct {
+	once  sync.Once
+	items []MalType
+}
+
+func (f *flatItems) get(compute func() []MalType) []MalType {
+	f.once.Do(func() {
+		f.items = compute()
+	})
+	return f.items
+}
+
+// NewList creates a list of items. The list takes ownership of the slice, so
+// it mustn't be modified afterwards.
+func NewList(items ...MalType) *MalList {
+	return &MalList{items: items}
+}
+
+// NewListAt creates a list of items, like NewList, whose source position is
+// pos. It's used for lists which replace a list read from source code (e.g.
+// when a macro call is expanded), so errors still point at the original.
+func NewListAt(pos *Position, items ...MalType) *MalList {
+	return &MalList{items: items, Pos: pos}
+}
+
+// Items returns the list's items. For a slice backed list, this is O(1). A
+// cell's items are copied into a slice the first time they're asked for. The
+// slice mustn't be modified.
+func (l *MalList) Items() []MalType {
+	if l.rest == nil {
+		return l.items
+	}
+	return l.flattened.get(func() []MalType {
+		items := make([]MalType, 0, l.count)
+		list := l
+		for list.rest != nil {
+			items = append(items, list.first)
+			list = list.rest
+		}
+		return append(items, list.items...)
+	})
+}
+
+// Nth returns the item at index i, which must be in range. It walks the
+// cells before the item, rather than copying them like Items does, so it's
+// O(i).
+func (l *MalList) Nth(i int) MalType {
+	for ; l.rest != nil; l = l.rest {
+		if i == 0 {
+			return l.first
+		}
+		i--
+	}
+	return l.items[i]
+}
+
+// Iter returns an iterator over the list's items, which doesn't copy them
+// like Items does
+func (l *MalList) Iter() *ListIter {
+	return &ListIter{list: l}
+}
+
+// ListIter iterates over the items of a list (see MalList.Iter)
+type ListIter struct {
+	list *MalList
+	// i is the index of the next item of list's slice, once list is slice
+	// backed
+	i int
+}
+
+// Next returns the next item, and false if there aren't any more
+func (it *ListIter) Next() (MalType, bool) {
+	if it.list.rest != nil {
+		item := it.list.first
+		it.list = it.list.rest
+		return item, true
+	}
+	if it.i >= len(it.list.items) {
+		return nil, false
+	}
+	it.i++
+	return it.list.items[it.i-1], true
+}
+
+// Len returns the number of items in the list
+func (l *MalList) Len() int {
+	if l.rest == nil {
+		return len(l.items)
+	}
+	return l.count
+}
+
+// First returns the list's first item, or nil if it's empty
+func (l *MalList) First() MalType {
+	if l.rest != nil {
+		return l.first
+	}
+	if len(l.items) == 0 {
+		return nil
+	}
+	return l.items[0]
+}
+
+// Rest returns the list without its first item. The rest of an empty list is
+// empty.
+func (l *MalList) Rest() *MalList {
+	if l.rest != nil {
+		return l.rest
+	}
+	if len(l.items) <= 1 {
+		return &MalList{}
+	}
+	return &MalList{items: l.items[1:]}
+}
+
+// Cons returns a new list, with item followed by the items of l. l is shared,
+// not copied.
+func (l *MalList) Cons(item MalType) *MalList {
+	return &MalList{
+		first:     item,
+		rest:      l,
+		count:     l.Len() + 1,
+		flattened: &flatItems{},
+	}
+}
+
+func (l *MalList) String() string {
+	items := l.Items()
+	itemStrings := make([]string, len(items))
+	for i, item := range items {
+		itemStrings[i] = item.String()
+	}
+	return fmt.Sprintf("(%s)", strings.Join(itemStrings, " "))
+}
@@ -0,0 +1,62 @@
+package types
+
+import "fmt"
+
+// Metadata is a value (usually a hash map) attached to another value, which
+// describes it without changing it. Two values which differ only in their
+// metadata are equal.
+
+// Meta returns the metadata attached to t, or nil if it has none
+func Meta(t MalType) MalType {
+	var meta MalType
+	switch t := t.(type) {
+	case *MalFunction:
+		meta = t.Meta
+	case *MalList:
+		meta = t.Meta
+	case *MalVector:
+		meta = t.Meta
+	case *MalHashMap:
+		meta = t.Meta
+	case *MalSet:
+		meta = t.Meta
+	case *MalSymbol:
+		meta = t.Meta
+	}
+	if meta == nil {
+		return &MalNil{}
+	}
+	return meta
+}
+
+// WithMeta returns a copy of t, with meta attached to it. Only functions,
+// lists, vectors, hash maps, sets and symbols can have metadata.
+func WithMeta(t MalType, meta MalType) (MalType, error) {
+	switch t := t.(type) {
+	case *MalFunction:
+		copied := *t
+		copied.Meta = meta
+		return &copied, nil
+	case *MalList:
+		copied := *t
+		copied.Meta = meta
+		return &copied, nil
+	case *MalVector:
+		copied := *t
+		copied.Meta = meta
+		return &copied, nil
+	case *MalHashMap:
+		copied := *t
+		copied.Meta = meta
+		return &copied, nil
+	case *MalSet:
+		copied := *t
+		copied.Meta = meta
+		return &copied, nil
+	case *MalSymbol:
+		copied := *t
+		copied.Meta = meta
+		return &copied, nil
+	}
+	return nil, fmt.Errorf("%s can't have metadata", t)
+}
@@ -0,0 +1,243 @@
+package types
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ints(n int) []MalType {
+	items := make([]MalType, n)
+	for i := range items {
+		items[i] = &MalInt{Value: i}
+	}
+	return items
+}
+
+func TestListCons(t *testing.T) {
+	list := NewList(ints(3)...)
+	consed := list.Cons(&MalKeyword{Value: "a"}).Cons(&MalKeyword{Value: "b"})
+	assert.Equal(t, "(:b :a 0 1 2)", consed.String())
+	assert.Equal(t, 5, consed.Len())
+	assert.Equal(t, "(:a 0 1 2)", consed.Rest().String())
+	assert.Equal(t, "(0 1 2)", consed.Rest().Rest().String())
+	// The original list is unchanged, and shared by the new one
+	assert.Equal(t, "(0 1 2)", list.String())
+	assert.Same(t, list, consed.Rest().Rest())
+
+	empty := &MalList{}
+	assert.Nil(t, empty.First())
+	assert.Equal(t, 0, empty.Rest().Len())
+	assert.Equal(t, "(1)", empty.Cons(&MalInt{Value: 1}).String())
+}
+
+func TestListNthAndIter(t *testing.T) {
+	list := NewList(ints(3)...).Cons(&MalKeyword{Value: "a"}).Cons(&MalKeyword{Value: "b"})
+	for i, expected := range []string{":b", ":a", "0", "1", "2"} {
+		assert.Equal(t, expected, list.Nth(i).String())
+	}
+
+	var items []string
+	it := list.Iter()
+	for item, ok := it.Next(); ok; item, ok = it.Next() {
+		items = append(items, item.String())
+	}
+	assert.Equal(t, []string{":b", ":a", "0", "1", "2"}, items)
+
+	_, ok := (&MalList{}).Iter().Next()
+	assert.False(t, ok)
+}
+
+func TestVector(t *testing.T) {
+	// These sizes fill the tail, then one, two and three levels of the trie
+	for _, n := range []int{0, 1, 32, 33, 64, 1056, 1057, 32800, 40000} {
+		v := NewVector()
+		for i := 0; i < n; i++ {
+			v = v.Conj(&MalInt{Value: i})
+		}
+		require.Equal(t, n, v.Len())
+		for i := 0; i < n; i++ {
+			item, ok := v.Nth(i)
+			require.True(t, ok)
+			require.Equal(t, i, item.(*MalInt).Value, "item %d of %d", i, n)
+		}
+		_, ok := v.Nth(n)
+		assert.False(t, ok)
+		assert.Equal(t, ints(n), append([]MalType{}, v.Items()...))
+	}
+}
+
+func TestVectorAssoc(t *testing.T) {
+	v := NewVector(ints(2000)...)
+	updated := v
+	for _, i := range []int{0, 31, 32, 1023, 1024, 1999} {
+		var err error
+		updated, err = updated.Assoc(i, &MalKeyword{Value: strconv.Itoa(i)})
+		require.NoError(t, err)
+	}
+	for i := 0; i < 2000; i++ {
+		item, _ := updated.Nth(i)
+		old, _ := v.Nth(i)
+		assert.Equal(t, strconv.Itoa(i), old.String(), "the original vector is unchanged")
+		switch i {
+		case 0, 31, 32, 1023, 1024, 1999:
+			assert.Equal(t, ":"+strconv.Itoa(i), item.String())
+		default:
+			assert.Equal(t, strconv.Itoa(i), item.String())
+		}
+	}
+
+	appended, err := v.Assoc(2000, &MalNil{})
+	require.NoError(t, err)
+	assert.Equal(t, 2001, appended.Len())
+	_, err = v.Assoc(2001, &MalNil{})
+	assert.EqualError(t, err, "index 2001 out of range for a vector of 2000 items")
+}
+
+func TestHashMap(t *testing.T) {
+	m, err := NewHashMap()
+	require.NoError(t, err)
+	for i := 0; i < 5000; i++ {
+		m, err = m.Assoc(&MalInt{Value: i}, &MalString{Value: strconv.Itoa(i)})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 5000, m.Len())
+
+	removed, err := m.Dissoc(&MalInt{Value: 10}, &MalInt{Value: 4999}, &MalInt{Value: 5000})
+	require.NoError(t, err)
+	assert.Equal(t, 4998, removed.Len())
+	_, ok := removed.Get(&MalInt{Value: 10})
+	assert.False(t, ok)
+	value, ok := m.Get(&MalInt{Value: 10})
+	assert.True(t, ok, "the original map is unchanged")
+	assert.Equal(t, `"10"`, value.String())
+
+	// Keys are listed in the order they were added, and replacing a value
+	// doesn't move its key
+	updated, err := removed.Assoc(&MalInt{Value: 0}, &MalNil{}, &MalInt{Value: 10}, &MalNil{})
+	require.NoError(t, err)
+	keys := updated.Keys()
+	require.Equal(t, 4999, len(keys))
+	assert.Equal(t, "0", keys[0].String())
+	assert.Equal(t, "11", keys[10].String())
+	assert.Equal(t, "10", keys[4998].String())
+	assert.Equal(t, "nil", updated.Values()[0].String())
+}
+
+func TestHashCollisions(t *testing.T) {
+	// Give every entry the same hash, so they all end up in one collision
+	// node at the bottom of the trie
+	root := emptyHAMTNode
+	for i := 0; i < 10; i++ {
+		root, _ = root.assoc(0, &hamtEntry{hash: 42, id: strconv.Itoa(i), value: &MalInt{Value: i}})
+	}
+	for i := 0; i < 10; i++ {
+		entry := root.get(42, 0, strconv.Itoa(i))
+		require.NotNil(t, entry)
+		assert.Equal(t, i, entry.value.(*MalInt).Value)
+	}
+	assert.Nil(t, root.get(42, 0, "10"))
+
+	root, removed := root.dissoc(42, 0, "3")
+	assert.True(t, removed)
+	assert.Nil(t, root.get(42, 0, "3"))
+	assert.Len(t, root.entries(nil), 9)
+}
+
+// The benchmarks compare the persistent collections with copying a slice,
+// which is how lists and hash maps used to be updated
+
+func BenchmarkListCons(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		list := &MalList{}
+		for j := 0; j < 1000; j++ {
+			list = list.Cons(&MalInt{Value: j})
+		}
+	}
+}
+
+// BenchmarkListConsNth builds a list with cons, looking at its first item
+// with Nth each time, like a mal loop which does (nth acc 0) then
+// (cons n acc). It should be O(1) per item, like BenchmarkListCons.
+func BenchmarkListConsNth(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		list := NewList(&MalInt{Value: -1})
+		for j := 0; j < 1000; j++ {
+			_ = list.Nth(0)
+			list = list.Cons(&MalInt{Value: j})
+		}
+	}
+}
+
+func BenchmarkSliceCons(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		var items []MalType
+		for j := 0; j < 1000; j++ {
+			items = append([]MalType{&MalInt{Value: j}}, items...)
+		}
+	}
+}
+
+func BenchmarkVectorConj(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		v := NewVector()
+		for j := 0; j < 1000; j++ {
+			v = v.Conj(&MalInt{Value: j})
+		}
+	}
+}
+
+func BenchmarkVectorAssoc(b *testing.B) {
+	v := NewVector(ints(1000)...)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 1000; j++ {
+			v, _ = v.Assoc(j, &MalInt{Value: i})
+		}
+	}
+}
+
+func BenchmarkSliceAssoc(b *testing.B) {
+	items := ints(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 1000; j++ {
+			items = append([]MalType(nil), items...)
+			items[j] = &MalInt{Value: i}
+		}
+	}
+}
+
+func BenchmarkHashMapAssoc(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		m := &MalHashMap{}
+		for j := 0; j < 1000; j++ {
+			m, _ = m.Assoc(&MalInt{Value: j}, &MalNil{})
+		}
+	}
+}
+
+// BenchmarkCopyingHashMapAssoc copies the whole map on every assoc, like
+// hash maps used to
+func BenchmarkCopyingHashMapAssoc(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		var keys, values []MalType
+		index := map[string]int{}
+		for j := 0; j < 1000; j++ {
+			key := &MalInt{Value: j}
+			keys = append([]MalType(nil), keys...)
+			values = append([]MalType(nil), values...)
+			copied := make(map[string]int, len(index)+1)
+			for k, v := range index {
+				copied[k] = v
+			}
+			id, _ := hashKey(key)
+			copied[id] = len(keys)
+			keys = append(keys, key)
+			values = append(values, &MalNil{})
+			index = copied
+		}
+	}
+}
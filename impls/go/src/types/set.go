@@ -0,0 +1,87 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MalSet is an immutable collection of distinct values. Two values are the
+// same item if they're =, so anything which can be a hash map key can be in
+// a set. Like hash maps, sets are persistent (see hamt.go), and remember the
+// order their items were added in, which is the order they're printed in.
+type MalSet struct {
+	trie orderedTrie
+	Meta MalType
+}
+
+// NewSet creates a set of items. Duplicates are dropped.
+func NewSet(items ...MalType) (*MalSet, error) {
+	return (&MalSet{}).Conj(items...)
+}
+
+// setKey returns the id of an item of a set
+func setKey(item MalType) (string, error) {
+	id, err := hashKey(item)
+	if unhashable, ok := err.(*unhashableError); ok {
+		return "", fmt.Errorf("%s can't be in a set", unhashable.value)
+	}
+	return id, err
+}
+
+// Contains reports whether item is in the set
+func (s *MalSet) Contains(item MalType) bool {
+	id, err := setKey(item)
+	if err != nil {
+		return false
+	}
+	return s.trie.get(id) != nil
+}
+
+// Conj returns a copy of the set, with items added to it. Items which are
+// already in the set keep their position.
+func (s *MalSet) Conj(items ...MalType) (*MalSet, error) {
+	updated := &MalSet{trie: s.trie, Meta: s.Meta}
+	for _, item := range items {
+		id, err := setKey(item)
+		if err != nil {
+			return nil, err
+		}
+		if updated.trie.get(id) == nil {
+			updated.trie = updated.trie.with(id, item, nil)
+		}
+	}
+	return updated, nil
+}
+
+// Disj returns a copy of the set, without items
+func (s *MalSet) Disj(items ...MalType) (*MalSet, error) {
+	updated := &MalSet{trie: s.trie, Meta: s.Meta}
+	for _, item := range items {
+		id, err := setKey(item)
+		if err != nil {
+			return nil, err
+		}
+		updated.trie = updated.trie.without(id)
+	}
+	return updated, nil
+}
+
+// Items returns the set's items, in the order they were added. The slice
+// mustn't be modified.
+func (s *MalSet) Items() []MalType {
+	items, _ := s.trie.entries()
+	return items
+}
+
+func (s *MalSet) Len() int {
+	return s.trie.count
+}
+
+func (s *MalSet) String() string {
+	items := s.Items()
+	itemStrings := make([]string, len(items))
+	for i, item := range items {
+		itemStrings[i] = item.String()
+	}
+	return fmt.Sprintf("#{%s}", strings.Join(itemStrings, " "))
+}
@@ -1,9 +1,10 @@
 package types
 
 import (
+	"context"
 	"fmt"
 	"strconv"
-	"strings"
+	"sync"
 )
 
 type MalType interface {
@@ -16,18 +17,6 @@ type EnvType interface {
 	Find(string) (EnvType, error)
 }
 
-type MalList struct {
-	Items []MalType
-}
-
-func (l *MalList) String() string {
-	itemStrings := make([]string, len(l.Items))
-	for i, item := range l.Items {
-		itemStrings[i] = item.String()
-	}
-	return fmt.Sprintf("(%s)", strings.Join(itemStrings, " "))
-}
-
 type MalInt struct {
 	Value int
 }
@@ -38,6 +27,10 @@ func (i *MalInt) String() string {
 
 type MalSymbol struct {
 	Value string
+	// Pos is where the symbol was read from, or nil if it wasn't read from
+	// source code
+	Pos  *Position
+	Meta MalType
 }
 
 func (s *MalSymbol) String() string {
@@ -45,12 +38,23 @@ func (s *MalSymbol) String() string {
 }
 
 type MalFunction struct {
-	Func              func(args ...MalType) (MalType, error)
+	// Name is the symbol the function was first bound to with def!, or the
+	// name it was registered under for builtins. It's empty for anonymous
+	// functions.
+	Name string
+	Func func(args ...MalType) (MalType, error)
+	// CallContext, if it's set, calls the function like Func does, but the
+	// call stops once ctx is cancelled, as well as when the interpreter is
+	// shut down. It's set for functions written in mal.
+	CallContext       func(ctx context.Context, args ...MalType) (MalType, error)
 	TailCallOptimised bool
 	AST               MalType
 	Params            []*MalSymbol
 	Env               EnvType
 	IsMacro           bool
+	// Meta is the function's metadata. def! records the function's name,
+	// and where it was defined, in it.
+	Meta MalType
 }
 
 func (f *MalFunction) String() string {
@@ -81,3 +85,186 @@ type MalString struct {
 func (s *MalString) String() string {
 	return fmt.Sprintf(`"%s"`, s.Value)
 }
+
+// MalError is an error, returned as a value rather than raised. Builtins
+// raise an exception if they're passed one, unless they're written to accept
+// them (e.g. error?), so errors can't be ignored by accident.
+type MalError struct {
+	Message string
+}
+
+func (e *MalError) String() string {
+	return fmt.Sprintf("#<error: %s>", e.Message)
+}
+
+// MalPromise is a value which is delivered later, possibly by another
+// goroutine. A future is a promise which is delivered with the result of a
+// function run on its own goroutine.
+type MalPromise struct {
+	done  chan struct{}
+	once  sync.Once
+	value MalType
+	err   error
+}
+
+func NewPromise() *MalPromise {
+	return &MalPromise{
+		done: make(chan struct{}),
+	}
+}
+
+// Deliver sets the promise's value, or the error which stopped it being
+// computed. Only the first delivery counts: Deliver reports whether this
+// was it.
+func (p *MalPromise) Deliver(value MalType, err error) bool {
+	delivered := false
+	p.once.Do(func() {
+		p.value, p.err = value, err
+		close(p.done)
+		delivered = true
+	})
+	return delivered
+}
+
+// Done is closed once the promise has been delivered
+func (p *MalPromise) Done() <-chan struct{} {
+	return p.done
+}
+
+// Result returns what the promise was delivered with. It mustn't be called
+// until Done is closed.
+func (p *MalPromise) Result() (MalType, error) {
+	return p.value, p.err
+}
+
+func (p *MalPromise) String() string {
+	return "#<promise>"
+}
+
+// MalChannel is a channel of mal values, which goroutines (e.g. futures)
+// can use to communicate.
+//
+// Go panics if you send on a closed channel, so closing a MalChannel doesn't
+// close Values. Instead, it closes Closed. Senders and receivers wait on both:
+// once Closed is closed, sends fail, and receives return any values left in
+// the buffer, then nil.
+type MalChannel struct {
+	Values chan MalType
+	Closed chan struct{}
+	once   sync.Once
+}
+
+// NewChannel creates a channel which buffers up to size values
+func NewChannel(size int) *MalChannel {
+	return &MalChannel{
+		Values: make(chan MalType, size),
+		Closed: make(chan struct{}),
+	}
+}
+
+// Close closes the channel. Closing a channel more than once has no effect.
+func (c *MalChannel) Close() {
+	c.once.Do(func() {
+		close(c.Closed)
+	})
+}
+
+func (c *MalChannel) String() string {
+	return "#<channel>"
+}
+
+// Position is a location in mal source code. Lines and columns start at 1.
+type Position struct {
+	// File is the name of the file the source code was read from, or "" if
+	// it didn't come from a file
+	File   string
+	Line   int
+	Column int
+}
+
+func (p *Position) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// PositionOf returns where t was read from, or nil if it's not known
+func PositionOf(t MalType) *Position {
+	switch tok := t.(type) {
+	case *MalList:
+		return tok.Pos
+	case *MalSymbol:
+		return tok.Pos
+	}
+	return nil
+}
+
+// MalException is the value bound by catch* when evaluating the body of a
+// try* fails. It keeps the original error around so its stack trace can be
+// inspected.
+type MalException struct {
+	Err error
+}
+
+// String prints the error's message as a string literal. Quotes and control
+// characters in the message are escaped, so it reads as one string.
+func (e *MalException) String() string {
+	return strconv.Quote(e.Err.Error())
+}
+
+// StackFrame is a single mal function call which was in progress when an
+// error occurred.
+type StackFrame struct {
+	// Name is the name of the function being called, or "" if it's anonymous
+	Name string
+	// Form is the list which called the function
+	Form MalType
+}
+
+func (f *StackFrame) String() string {
+	name := f.Name
+	if name == "" {
+		name = "<anonymous>"
+	}
+	if pos := PositionOf(f.Form); pos != nil {
+		return fmt.Sprintf("%s: %s at %s", name, f.Form, pos)
+	}
+	return fmt.Sprintf("%s: %s", name, f.Form)
+}
+
+// EvalError is an error raised while evaluating mal code, along with the mal
+// call stack at the point it was raised. The innermost call comes first.
+type EvalError struct {
+	Err   error
+	Stack []*StackFrame
+}
+
+func (e *EvalError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *EvalError) Unwrap() error {
+	return e.Err
+}
+
+// WithStackFrame adds frame to the outermost end of err's stack trace. Errors
+// which don't have a stack trace yet are wrapped in an EvalError.
+func WithStackFrame(err error, frame *StackFrame) error {
+	evalErr, ok := err.(*EvalError)
+	if !ok {
+		evalErr = &EvalError{Err: err}
+	}
+	evalErr.Stack = append(evalErr.Stack, frame)
+	return evalErr
+}
+
+// StackTrace returns the mal call stack attached to err, innermost call
+// first. It returns nil if err has no stack trace.
+func StackTrace(err error) []*StackFrame {
+	evalErr, ok := err.(*EvalError)
+	if !ok {
+		return nil
+	}
+	return evalErr.Stack
+}
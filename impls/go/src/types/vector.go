@@ -0,0 +1,225 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MalVector is an immutable, indexed sequence of values. Vectors are
+// persistent: Conj and Assoc return a new vector, which shares almost all of
+// its structure with the old one.
+//
+// The items are stored in a trie, each of whose nodes has up to 32 children,
+// so looking up an index, or replacing it, only visits log32(n) nodes (at
+// most 7 for any vector which fits in memory). The last few items are kept in
+// a separate tail, so appending only has to copy the tail, until it's full
+// and gets pushed into the trie.
+type MalVector struct {
+	count int
+	// shift is the number of index bits below the root: 5 for each level of
+	// the trie under it
+	shift uint
+	root  *vectorNode
+	tail  []MalType
+	// flattened caches the vector's items as a slice, once Items is called
+	flattened *flatItems
+	Meta      MalType
+}
+
+const (
+	vectorBits  = 5
+	vectorWidth = 1 << vectorBits
+	vectorMask  = vectorWidth - 1
+)
+
+// vectorNode is a node in a vector's trie. Nodes at the bottom of the trie
+// hold values; the rest hold children.
+type vectorNode struct {
+	children []*vectorNode
+	values   []MalType
+}
+
+var emptyVectorNode = &vectorNode{}
+
+// NewVector creates a vector of items
+func NewVector(items ...MalType) *MalVector {
+	v := &MalVector{
+		shift:     vectorBits,
+		root:      emptyVectorNode,
+		flattened: &flatItems{},
+	}
+	if len(items) <= vectorWidth {
+		v.count = len(items)
+		v.tail = append([]MalType(nil), items...)
+		return v
+	}
+	for _, item := range items {
+		v = v.Conj(item)
+	}
+	return v
+}
+
+// Len returns the number of items in the vector
+func (v *MalVector) Len() int {
+	return v.count
+}
+
+// tailOffset is the index of the first item in the tail
+func (v *MalVector) tailOffset() int {
+	if v.count < vectorWidth {
+		return 0
+	}
+	return ((v.count - 1) >> vectorBits) << vectorBits
+}
+
+// Nth returns the item at index i, and whether there is one
+func (v *MalVector) Nth(i int) (MalType, bool) {
+	if i < 0 || i >= v.count {
+		return nil, false
+	}
+	if i >= v.tailOffset() {
+		return v.tail[i&vectorMask], true
+	}
+	node := v.root
+	for level := v.shift; level > 0; level -= vectorBits {
+		node = node.children[(i>>level)&vectorMask]
+	}
+	return node.values[i&vectorMask], true
+}
+
+// Conj returns a new vector, with item added to the end
+func (v *MalVector) Conj(item MalType) *MalVector {
+	updated := &MalVector{
+		count:     v.count + 1,
+		shift:     v.shift,
+		root:      v.root,
+		flattened: &flatItems{},
+		Meta:      v.Meta,
+	}
+	if updated.root == nil {
+		// v is a zero MalVector, rather than one made by NewVector
+		updated.shift, updated.root = vectorBits, emptyVectorNode
+	}
+	if v.count-v.tailOffset() < vectorWidth {
+		updated.tail = make([]MalType, len(v.tail)+1)
+		copy(updated.tail, v.tail)
+		updated.tail[len(v.tail)] = item
+		return updated
+	}
+
+	// The tail is full, so it becomes a leaf of the trie. If the trie is
+	// full too, it grows a new root, which is one level higher.
+	leaf := &vectorNode{values: v.tail}
+	if (v.count >> vectorBits) > (1 << updated.shift) {
+		updated.root = &vectorNode{
+			children: []*vectorNode{updated.root, newVectorPath(updated.shift, leaf)},
+		}
+		updated.shift += vectorBits
+	} else {
+		updated.root = pushVectorTail(v.count, updated.shift, updated.root, leaf)
+	}
+	updated.tail = []MalType{item}
+	return updated
+}
+
+// pushVectorTail returns a copy of node, the node at level in the trie of a
+// vector of count items, with leaf added as its last leaf
+func pushVectorTail(count int, level uint, node *vectorNode, leaf *vectorNode) *vectorNode {
+	i := ((count - 1) >> level) & vectorMask
+	updated := &vectorNode{
+		children: append([]*vectorNode(nil), node.children...),
+	}
+	var child *vectorNode
+	switch {
+	case level == vectorBits:
+		child = leaf
+	case i < len(node.children):
+		child = pushVectorTail(count, level-vectorBits, node.children[i], leaf)
+	default:
+		child = newVectorPath(level-vectorBits, leaf)
+	}
+	if i < len(updated.children) {
+		updated.children[i] = child
+	} else {
+		updated.children = append(updated.children, child)
+	}
+	return updated
+}
+
+// newVectorPath returns a branch of the trie, level bits high, whose only
+// leaf is leaf
+func newVectorPath(level uint, leaf *vectorNode) *vectorNode {
+	if level == 0 {
+		return leaf
+	}
+	return &vectorNode{
+		children: []*vectorNode{newVectorPath(level-vectorBits, leaf)},
+	}
+}
+
+// Assoc returns a new vector, with the item at index i replaced by item. i
+// can be the vector's length, which adds item to the end.
+func (v *MalVector) Assoc(i int, item MalType) (*MalVector, error) {
+	if i == v.count {
+		return v.Conj(item), nil
+	}
+	if i < 0 || i > v.count {
+		return nil, fmt.Errorf("index %d out of range for a vector of %d items", i, v.count)
+	}
+	updated := &MalVector{
+		count:     v.count,
+		shift:     v.shift,
+		root:      v.root,
+		tail:      v.tail,
+		flattened: &flatItems{},
+		Meta:      v.Meta,
+	}
+	if i >= v.tailOffset() {
+		updated.tail = append([]MalType(nil), v.tail...)
+		updated.tail[i&vectorMask] = item
+		return updated, nil
+	}
+	updated.root = assocVector(v.shift, v.root, i, item)
+	return updated, nil
+}
+
+func assocVector(level uint, node *vectorNode, i int, item MalType) *vectorNode {
+	if level == 0 {
+		values := append([]MalType(nil), node.values...)
+		values[i&vectorMask] = item
+		return &vectorNode{values: values}
+	}
+	children := append([]*vectorNode(nil), node.children...)
+	j := (i >> level) & vectorMask
+	children[j] = assocVector(level-vectorBits, children[j], i, item)
+	return &vectorNode{children: children}
+}
+
+// Items returns the vector's items as a slice. They're copied out of the
+// trie the first time they're asked for. The slice mustn't be modified.
+func (v *MalVector) Items() []MalType {
+	if v.flattened == nil {
+		return nil
+	}
+	return v.flattened.get(func() []MalType {
+		items := make([]MalType, 0, v.count)
+		var walk func(node *vectorNode)
+		walk = func(node *vectorNode) {
+			items = append(items, node.values...)
+			for _, child := range node.children {
+				walk(child)
+			}
+		}
+		walk(v.root)
+		return append(items, v.tail...)
+	})
+}
+
+func (v *MalVector) String() string {
+	items := v.Items()
+	itemStrings := make([]string, len(items))
+	for i, item := range items {
+		itemStrings[i] = item.String()
+	}
+	return fmt.Sprintf("[%s]", strings.Join(itemStrings, " "))
+}